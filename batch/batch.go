@@ -0,0 +1,231 @@
+// Package batch reads the APIs that batch mode runs against, given either in
+// the separated format of CF_PLEX_APIS, or as a JSON or YAML document.
+package batch
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/EngineerBetter/cf-plex/env"
+	"github.com/EngineerBetter/cf-plex/redact"
+	"github.com/EngineerBetter/cf-plex/selector"
+	"github.com/EngineerBetter/cf-plex/target"
+	"gopkg.in/yaml.v2"
+)
+
+// Api describes one API. For client credentials, Username and Password hold
+// the client ID and secret.
+type Api struct {
+	Api               string            `yaml:"api"`
+	Name              string            `yaml:"name"`
+	Group             string            `yaml:"group"`
+	Username          string            `yaml:"username"`
+	Password          string            `yaml:"password"`
+	Auth              env.AuthType      `yaml:"auth"`
+	Origin            string            `yaml:"origin"`
+	CredentialHelper  string            `yaml:"credential_helper"`
+	Org               string            `yaml:"org"`
+	Space             string            `yaml:"space"`
+	OrgMap            map[string]string `yaml:"org_map"`
+	SpaceMap          map[string]string `yaml:"space_map"`
+	SkipSslValidation bool              `yaml:"skip_ssl_validation"`
+	Labels            map[string]string `yaml:"labels"`
+}
+
+// unsafe matches the characters of a username that are replaced in the
+// name of its directory.
+var unsafe = regexp.MustCompile(`[^A-Za-z0-9._@+-]`)
+
+// quoted matches the values that YAML errors quote, which may be passwords.
+var quoted = regexp.MustCompile("`[^`]*`")
+
+type document struct {
+	Apis []Api `yaml:"apis"`
+}
+
+// IsDocument reports whether CF_PLEX_APIS holds a JSON document rather than
+// separated values.
+func IsDocument(cfEnvs string) bool {
+	trimmed := strings.TrimSpace(cfEnvs)
+	return strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{")
+}
+
+// Load reads a file of APIs. JSON is read as YAML, of which it is a subset,
+// so either can be used.
+func Load(path string) ([]Api, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(bytes)
+}
+
+// Parse reads a list of APIs, either on its own or as the apis field of an
+// object. Which it is comes from the first character, so that errors are
+// about the form that was meant.
+func Parse(bytes []byte) ([]Api, error) {
+	var apis []Api
+	var err error
+	if isList(string(bytes)) {
+		err = yaml.UnmarshalStrict(bytes, &apis)
+	} else {
+		var doc document
+		err = yaml.UnmarshalStrict(bytes, &doc)
+		apis = doc.Apis
+	}
+	if err != nil {
+		return nil, errors.New("batch APIs are invalid: " + quoted.ReplaceAllString(err.Error(), "`"+redact.Mask+"`"))
+	}
+	return apis, Validate(apis)
+}
+
+// isList reports whether a batch document is a list of APIs, rather than an
+// object with an apis field.
+func isList(document string) bool {
+	trimmed := strings.TrimSpace(document)
+	if strings.HasPrefix(trimmed, "---") {
+		return false
+	}
+	return strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "-")
+}
+
+// FromCoords converts APIs given in the separated format.
+func FromCoords(coords []env.Coord) []Api {
+	var apis []Api
+	for _, coord := range coords {
+		apis = append(apis, Api{Api: coord.Api, Username: coord.Username, Password: coord.Password, Auth: coord.Auth, Origin: coord.Origin})
+	}
+	return apis
+}
+
+func Validate(apis []Api) error {
+	if len(apis) == 0 {
+		return errors.New("batch APIs are invalid: no APIs are listed")
+	}
+
+	dirs := make(map[string]bool)
+	for _, api := range apis {
+		if !strings.HasPrefix(api.Api, "https://") && !strings.HasPrefix(api.Api, "http://") {
+			return errors.New("api '" + api.Api + "' is not an http or https URL")
+		}
+		if api.Name != "" {
+			if err := target.ValidateName(api.Name); err != nil {
+				return err
+			}
+		}
+		if api.Group != "" {
+			if err := target.ValidateName(api.Group); err != nil {
+				return errors.New("group " + err.Error())
+			}
+		}
+		switch api.Auth {
+		case "", env.Password, env.ClientCredentials:
+		default:
+			return errors.New("auth for " + api.DisplayName() + " must be password or client-credentials")
+		}
+		if api.Space != "" && api.Org == "" {
+			return errors.New("space for " + api.DisplayName() + " needs an org")
+		}
+		for key, value := range api.Labels {
+			if err := selector.ValidateKey(key); err != nil {
+				return err
+			}
+			if err := selector.ValidateValue(value); err != nil {
+				return err
+			}
+		}
+
+		if dirs[api.Dir()] {
+			return errors.New(api.DisplayName() + " is listed more than once; give each a different name")
+		}
+		dirs[api.Dir()] = true
+	}
+	return nil
+}
+
+// DisplayName is the name of the API if it has one, or else its URL.
+func (a Api) DisplayName() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Api
+}
+
+// Dir is the name of the API's CF_HOME within the batch group, which is
+// keyed by user as well as API so that the sessions of different users of
+// the same API are kept apart.
+func (a Api) Dir() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	if a.Username == "" {
+		return target.Sanitise(a.Api)
+	}
+	return unsafe.ReplaceAllString(a.Username, "_") + "@" + target.Sanitise(a.Api)
+}
+
+// Fingerprint returns a salted hash of the credentials that the API is
+// logged in to with, which can be kept to tell whether they have changed
+// without keeping the credentials themselves.
+func (a Api) Fingerprint() (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return a.fingerprint(salt)
+}
+
+// HasFingerprint reports whether fingerprint was made from the API's
+// current credentials.
+func (a Api) HasFingerprint(fingerprint string) bool {
+	parts := strings.SplitN(fingerprint, "$", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	expected, err := a.fingerprint(salt)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(fingerprint)) == 1
+}
+
+func (a Api) fingerprint(salt []byte) (string, error) {
+	auth := a.Auth
+	if auth == "" {
+		auth = env.Password
+	}
+	credentials, err := json.Marshal([]string{a.Api, string(auth), a.Origin, a.Username, a.Password})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write(credentials)
+	return hex.EncodeToString(salt) + "$" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// InGroup reports whether the API was listed in a group, where APIs listed
+// without one are in the default group.
+func (a Api) InGroup(group string) bool {
+	if a.Group == "" {
+		return group == "default"
+	}
+	return a.Group == group
+}
+
+// ClientCredentials reports whether the API is logged in to as a UAA client.
+func (a Api) ClientCredentials() bool {
+	return a.Auth == env.ClientCredentials
+}
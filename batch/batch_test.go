@@ -0,0 +1,146 @@
+package batch_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/batch"
+	"github.com/EngineerBetter/cf-plex/env"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("batch", func() {
+	Describe("Parse", func() {
+		It("reads a JSON list", func() {
+			apis, err := Parse([]byte(`[
+  {"api": "https://api.one.com", "username": "admin", "password": "p^a>s;s", "org": "system", "space": "ops"},
+  {"api": "https://api.two.com", "name": "two-ci", "username": "ci", "password": "secret", "auth": "client-credentials",
+   "skip_ssl_validation": true, "labels": {"env": "prod"}}
+]`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(apis).Should(Equal([]Api{
+				{Api: "https://api.one.com", Username: "admin", Password: "p^a>s;s", Org: "system", Space: "ops"},
+				{Api: "https://api.two.com", Name: "two-ci", Username: "ci", Password: "secret", Auth: env.ClientCredentials, SkipSslValidation: true, Labels: map[string]string{"env": "prod"}},
+			}))
+			Ω(apis[1].ClientCredentials()).Should(BeTrue())
+			Ω(apis[0].Dir()).Should(Equal("admin@https___api.one.com"))
+			Ω(apis[1].Dir()).Should(Equal("two-ci"))
+		})
+
+		It("reads YAML with the APIs under an apis field", func() {
+			apis, err := Parse([]byte(`
+apis:
+- api: https://api.one.com
+  username: admin
+  password: secret
+  origin: ldap
+`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(apis).Should(Equal([]Api{{Api: "https://api.one.com", Username: "admin", Password: "secret", Origin: "ldap"}}))
+		})
+
+		It("rejects unknown fields", func() {
+			_, err := Parse([]byte(`[{"api": "https://api.one.com", "pasword": "secret"}]`))
+			Ω(err).Should(MatchError(ContainSubstring("batch APIs are invalid")))
+		})
+
+		It("names unknown fields in the entries of lists", func() {
+			_, err := Parse([]byte("- api: https://api.one.com\n  usernme: admin\n"))
+			Ω(err).Should(MatchError(ContainSubstring("field usernme not found")))
+
+			_, err = Parse([]byte(`[{"api": "https://api.one.com", "usernme": "admin"}]`))
+			Ω(err).Should(MatchError(ContainSubstring("field usernme not found")))
+
+			_, err = Parse([]byte("apis:\n- api: https://api.one.com\n  usernme: admin\n"))
+			Ω(err).Should(MatchError(ContainSubstring("field usernme not found")))
+		})
+
+		It("rejects invalid APIs", func() {
+			invalid := map[string]string{
+				`[]`:                       "no APIs are listed",
+				`[{"api": "api.one.com"}]`: "is not an http or https URL",
+				`[{"api": "https://api.one.com", "name": "no spaces"}]`:            "name no spaces is invalid",
+				`[{"api": "https://api.one.com", "auth": "sso"}]`:                  "must be password or client-credentials",
+				`[{"api": "https://api.one.com", "space": "dev"}]`:                 "needs an org",
+				`[{"api": "https://api.one.com", "group": "a/b"}]`:                 "group name a/b is invalid",
+				`[{"api": "https://api.one.com", "labels": {"bad key": "x"}}]`:     "is invalid",
+				`[{"api": "https://api.one.com"}, {"api": "https://api.one.com"}]`: "listed more than once",
+			}
+			for doc, message := range invalid {
+				_, err := Parse([]byte(doc))
+				Ω(err).Should(MatchError(ContainSubstring(message)), doc)
+			}
+		})
+	})
+
+	It("puts APIs listed without a group in the default group", func() {
+		Ω(Api{Group: "prod"}.InGroup("prod")).Should(BeTrue())
+		Ω(Api{Group: "prod"}.InGroup("default")).Should(BeFalse())
+		Ω(Api{}.InGroup("default")).Should(BeTrue())
+		Ω(Api{}.InGroup("prod")).Should(BeFalse())
+	})
+
+	It("keys directories by user as well as API", func() {
+		Ω(Api{Api: "https://api.one.com", Username: "ci/bot"}.Dir()).Should(Equal("ci_bot@https___api.one.com"))
+		Ω(Api{Api: "https://api.one.com"}.Dir()).Should(Equal("https___api.one.com"))
+
+		_, err := Parse([]byte(`[{"api": "https://api.one.com", "username": "admin"}, {"api": "https://api.one.com", "username": "ci"}]`))
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	Describe("fingerprints", func() {
+		api := Api{Api: "https://api.one.com", Username: "admin", Password: "secret"}
+
+		It("match the credentials they were made from, without holding them", func() {
+			fingerprint, err := api.Fingerprint()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(fingerprint).ShouldNot(ContainSubstring("secret"))
+			Ω(api.HasFingerprint(fingerprint)).Should(BeTrue())
+
+			again, err := api.Fingerprint()
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(again).ShouldNot(Equal(fingerprint), "should be salted")
+		})
+
+		It("do not match changed credentials", func() {
+			fingerprint, err := api.Fingerprint()
+			Ω(err).ShouldNot(HaveOccurred())
+
+			for _, changed := range []Api{
+				{Api: "https://api.one.com", Username: "admin", Password: "rotated"},
+				{Api: "https://api.one.com", Username: "someone-else", Password: "secret"},
+				{Api: "https://api.one.com", Username: "admin", Password: "secret", Origin: "ldap"},
+				{Api: "https://api.one.com", Username: "admin", Password: "secret", Auth: env.ClientCredentials},
+			} {
+				Ω(changed.HasFingerprint(fingerprint)).Should(BeFalse(), changed.Username)
+			}
+			Ω(api.HasFingerprint("")).Should(BeFalse())
+			Ω(api.HasFingerprint("not hex$abc")).Should(BeFalse())
+		})
+	})
+
+	It("loads files", func() {
+		dir, err := ioutil.TempDir("", "plex-batch")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "apis.yml")
+		Ω(ioutil.WriteFile(path, []byte("- api: https://api.one.com\n  username: admin\n  password: secret\n"), 0600)).Should(Succeed())
+		apis, err := Load(path)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(apis).Should(HaveLen(1))
+	})
+
+	It("tells documents from separated values", func() {
+		Ω(IsDocument(` [{"api": "https://api.one.com"}]`)).Should(BeTrue())
+		Ω(IsDocument(`{"apis": []}`)).Should(BeTrue())
+		Ω(IsDocument("admin^password>https://api.one.com")).Should(BeFalse())
+	})
+
+	It("converts separated values", func() {
+		coords := []env.Coord{{Username: "admin", Password: "secret", Api: "https://api.one.com", Auth: env.Password, Origin: "ldap"}}
+		Ω(FromCoords(coords)).Should(Equal([]Api{{Api: "https://api.one.com", Username: "admin", Password: "secret", Auth: env.Password, Origin: "ldap"}}))
+	})
+})
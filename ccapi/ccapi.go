@@ -0,0 +1,50 @@
+// Package ccapi makes the few unauthenticated requests that cf-plex needs
+// to make of a Cloud Controller directly.
+package ccapi
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Info is the part of /v2/info that cf-plex uses.
+type Info struct {
+	ApiVersion            string `json:"api_version"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// GetInfo fetches /v2/info from api, which needs no authentication, and so
+// shows whether the API is up.
+func GetInfo(ctx context.Context, api string, skipSslValidation bool) (Info, error) {
+	var info Info
+	request, err := http.NewRequest("GET", strings.TrimSuffix(api, "/")+"/v2/info", nil)
+	if err != nil {
+		return info, err
+	}
+
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipSslValidation},
+	}
+	defer transport.CloseIdleConnections()
+
+	response, err := (&http.Client{Transport: transport}).Do(request.WithContext(ctx))
+	if err != nil {
+		return info, errors.New("API unreachable: " + err.Error())
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return info, errors.New("API unhealthy: /v2/info returned " + strconv.Itoa(response.StatusCode))
+	}
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return info, errors.New("API unhealthy: /v2/info is invalid: " + err.Error())
+	}
+	return info, nil
+}
@@ -0,0 +1,13 @@
+package ccapi_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestGoto(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CC API Suite")
+}
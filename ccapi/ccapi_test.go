@@ -0,0 +1,46 @@
+package ccapi_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/ccapi"
+	"github.com/EngineerBetter/cf-plex/fakecc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("GetInfo", func() {
+	It("reads the API version", func() {
+		server := httptest.NewServer(nil)
+		defer server.Close()
+		fakecc.Configure(server.Config, fakecc.Foundation{Addr: server.URL})
+
+		info, err := GetInfo(context.Background(), server.URL+"/", false)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(info.ApiVersion).Should(Equal(fakecc.APIVersion))
+	})
+
+	It("skips SSL validation only when asked to", func() {
+		server := httptest.NewTLSServer(nil)
+		defer server.Close()
+		fakecc.Configure(server.Config, fakecc.Foundation{Addr: server.URL})
+
+		_, err := GetInfo(context.Background(), server.URL, false)
+		Ω(err).Should(MatchError(HavePrefix("API unreachable")))
+
+		_, err = GetInfo(context.Background(), server.URL, true)
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	It("reports APIs that are unhealthy", func() {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		_, err := GetInfo(context.Background(), server.URL, false)
+		Ω(err).Should(MatchError("API unhealthy: /v2/info returned 502"))
+	})
+})
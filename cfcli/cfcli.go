@@ -2,11 +2,14 @@ package cfcli
 
 import (
 	"github.com/EngineerBetter/cf-plex/env"
+	"github.com/EngineerBetter/cf-plex/redact"
 
 	"bytes"
+	"context"
 
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
@@ -14,31 +17,90 @@ import (
 	"syscall"
 )
 
+type Options struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Binary overrides the cf binary that the Runner would otherwise use.
+	Binary string
+}
+
+// Runner runs cf with the given CF_HOME, returning its exit code and stdout.
+type Runner interface {
+	Run(ctx context.Context, cfHome string, args []string, opts Options) (error, int, string)
+}
+
+// ExecRunner runs cf as a subprocess. If Binary is empty, the binary named by
+// CF_PLEX_CF_BINARY is used, or failing that cf from the PATH.
+type ExecRunner struct {
+	Binary string
+}
+
+var DefaultRunner Runner = ExecRunner{}
+
 func CommandWithEnv(env []string, args ...string) *exec.Cmd {
 	cmd := exec.Command(args[0], args[1:]...)
 	cmd.Env = env
 	return cmd
 }
 
+func Binary() string {
+	return env.Get("CF_PLEX_CF_BINARY", "cf")
+}
+
 func Run(cfHome string, args []string) (error, int, string) {
-	args[0] = "cf"
-	env := env.Set("CF_HOME", cfHome, os.Environ())
-	cmd := CommandWithEnv(env, args...)
+	return RunWithOptions(context.Background(), cfHome, args, Options{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr})
+}
 
-	buffer := bytes.NewBufferString("")
-	multiWriter := io.MultiWriter(os.Stdout, buffer)
+// RunWithOptions announces and then runs cf against cfHome using the
+// DefaultRunner, killing it if ctx is cancelled. Nil readers and writers in
+// opts are treated as empty and discarded. Secrets are masked in the
+// announcement and in everything cf prints. Callers should hold the lock on
+// cfHome, so that no other cf-plex process uses it at the same time.
+func RunWithOptions(ctx context.Context, cfHome string, args []string, opts Options) (error, int, string) {
+	args = append([]string{"cf"}, args[1:]...)
 
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = multiWriter
-	cmd.Stderr = os.Stderr
+	if opts.Stdout == nil {
+		opts.Stdout = ioutil.Discard
+	}
 
-	status := fmt.Sprintf("\nRunning '%s' on %s\n", strings.Join(args, " "), path.Base(cfHome))
+	status := fmt.Sprintf("\nRunning '%s' on %s\n", strings.Join(Redact(args), " "), path.Base(cfHome))
+	fmt.Fprint(opts.Stdout, status)
 
-	if args[1] == "auth" {
-		status = strings.Replace(status, args[3], "[expunged]", -1)
+	stdout := redact.NewWriter(opts.Stdout)
+	opts.Stdout = stdout
+	if opts.Stderr != nil {
+		stderr := redact.NewWriter(opts.Stderr)
+		opts.Stderr = stderr
+		defer stderr.Flush()
 	}
+	defer stdout.Flush()
+
+	err, exitCode, output := DefaultRunner.Run(ctx, cfHome, args, opts)
+	return redact.Error(err), exitCode, redact.String(output)
+}
+
+func (r ExecRunner) Run(ctx context.Context, cfHome string, args []string, opts Options) (error, int, string) {
+	binary := opts.Binary
+	if binary == "" {
+		binary = r.Binary
+	}
+	if binary == "" {
+		binary = Binary()
+	}
+
+	env := env.Set("CF_HOME", cfHome, os.Environ())
+	cmd := exec.CommandContext(ctx, binary, args[1:]...)
+	cmd.Env = env
+
+	buffer := bytes.NewBufferString("")
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = buffer
+	if opts.Stdout != nil {
+		cmd.Stdout = io.MultiWriter(opts.Stdout, buffer)
+	}
+	cmd.Stderr = opts.Stderr
 
-	fmt.Printf(status)
 	err := cmd.Start()
 
 	if err != nil {
@@ -47,9 +109,17 @@ func Run(cfHome string, args []string) (error, int, string) {
 
 	err = cmd.Wait()
 	output := buffer.String()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err(), determineExitCode(cmd, err), output
+	}
 	return nil, determineExitCode(cmd, err), output
 }
 
+// Redact returns a copy of args with any secrets replaced.
+func Redact(args []string) []string {
+	return redact.Command(args)
+}
+
 func determineExitCode(cmd *exec.Cmd, err error) (exitCode int) {
 	status := cmd.ProcessState.Sys().(syscall.WaitStatus)
 	if status.Signaled() {
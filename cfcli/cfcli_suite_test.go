@@ -0,0 +1,13 @@
+package cfcli_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestGoto(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CF CLI Suite")
+}
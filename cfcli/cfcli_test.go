@@ -0,0 +1,64 @@
+package cfcli_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/cfcli"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("cfcli", func() {
+	Describe("Redact", func() {
+		It("expunges the password given to cf auth", func() {
+			args := []string{"cf", "auth", "admin", "secret"}
+			Ω(Redact(args)).Should(Equal([]string{"cf", "auth", "admin", "[expunged]"}))
+			Ω(args[3]).Should(Equal("secret"), "should not modify its argument")
+		})
+
+		It("leaves other commands alone", func() {
+			args := []string{"cf", "apps"}
+			Ω(Redact(args)).Should(Equal(args))
+		})
+	})
+})
+
+var _ = Describe("cf versions", func() {
+	Describe("ParseVersion", func() {
+		It("finds the major version", func() {
+			Ω(ParseVersion("cf version 6.53.0+8e2b70a4a.2020-10-01\n")).Should(Equal(6))
+			Ω(ParseVersion("cf7 version 7.2.0+be4a5ce2b.2020-12-10\n")).Should(Equal(7))
+		})
+
+		It("errs when there is no version", func() {
+			_, err := ParseVersion("command not found")
+			Ω(err).Should(MatchError("could not find a version in 'command not found'"))
+		})
+	})
+
+	Describe("ParseConstraint", func() {
+		It("accepts major versions", func() {
+			Ω(ParseConstraint("7")).Should(Equal(7))
+			Ω(ParseConstraint("v8")).Should(Equal(8))
+			Ω(ParseConstraint("6.x")).Should(Equal(6))
+		})
+
+		It("rejects anything else", func() {
+			_, err := ParseConstraint(">=7")
+			Ω(err).Should(MatchError("cf version >=7 is invalid: use a major version such as 7"))
+		})
+	})
+
+	Describe("ResolveBinary", func() {
+		It("prefers an explicit binary", func() {
+			Ω(ResolveBinary("/opt/cf6/cf", "7")).Should(Equal("/opt/cf6/cf"))
+		})
+
+		It("uses the default binary when there is no constraint", func() {
+			Ω(ResolveBinary("", "")).Should(Equal(Binary()))
+		})
+
+		It("errs when no binary satisfies the constraint", func() {
+			_, err := ResolveBinary("", "99")
+			Ω(err).Should(MatchError(ContainSubstring("no cf binary found for version 99")))
+		})
+	})
+})
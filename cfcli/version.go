@@ -0,0 +1,67 @@
+package cfcli
+
+import (
+	"errors"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionPattern = regexp.MustCompile(`version (\d+)\.(\d+)\.(\d+)`)
+
+// Version runs `cf version` using binary, and returns the major version.
+func Version(binary string) (int, error) {
+	output, err := exec.Command(binary, "version").Output()
+	if err != nil {
+		return 0, errors.New("could not run " + binary + " version: " + err.Error())
+	}
+	return ParseVersion(string(output))
+}
+
+// ParseVersion extracts the major version from the output of `cf version`.
+func ParseVersion(output string) (int, error) {
+	matches := versionPattern.FindStringSubmatch(output)
+	if matches == nil {
+		return 0, errors.New("could not find a version in '" + strings.TrimSpace(output) + "'")
+	}
+	return strconv.Atoi(matches[1])
+}
+
+// ParseConstraint turns a version constraint such as 7, v7 or 7.x into a
+// major version.
+func ParseConstraint(constraint string) (int, error) {
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(constraint, "v"), ".x")
+	major, err := strconv.Atoi(trimmed)
+	if err != nil || major < 1 {
+		return 0, errors.New("cf version " + constraint + " is invalid: use a major version such as 7")
+	}
+	return major, nil
+}
+
+// ResolveBinary decides which cf binary to run. An explicit binary always
+// wins. Otherwise a version constraint is satisfied by cf<major> on the
+// PATH, or by the default binary if it is the right major version.
+func ResolveBinary(binary, constraint string) (string, error) {
+	if binary != "" {
+		return binary, nil
+	}
+	if constraint == "" {
+		return Binary(), nil
+	}
+
+	major, err := ParseConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+
+	if path, err := exec.LookPath("cf" + strconv.Itoa(major)); err == nil {
+		return path, nil
+	}
+
+	if found, err := Version(Binary()); err == nil && found == major {
+		return Binary(), nil
+	}
+
+	return "", errors.New("no cf binary found for version " + constraint + ": put cf" + strconv.Itoa(major) + " on the PATH")
+}
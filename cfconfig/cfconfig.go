@@ -0,0 +1,161 @@
+// Package cfconfig reads the config.json that the cf CLI keeps in each
+// CF_HOME, so that cf-plex can find out about a target's session without
+// running cf.
+//
+// config.json does not record which version of the CLI wrote it. Config
+// only exposes the minimum versions that the API advertised; cf-plex keeps
+// its own record of the CLI version used in target metadata.
+package cfconfig
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	ConfigVersion            int
+	Target                   string
+	APIVersion               string
+	AuthorizationEndpoint    string
+	UaaEndpoint              string
+	AccessToken              string
+	RefreshToken             string
+	UAAGrantType             string
+	OrganizationFields       Fields
+	SpaceFields              Fields
+	SSLDisabled              bool
+	MinCLIVersion            string
+	MinRecommendedCLIVersion string
+}
+
+type Fields struct {
+	GUID string
+	Name string
+}
+
+// Token holds what cf-plex needs from the claims of a UAA token.
+type Token struct {
+	User     string
+	ClientID string
+	Expiry   time.Time
+}
+
+func Path(cfHome string) string {
+	return filepath.Join(cfHome, ".cf", "config.json")
+}
+
+// Read reads the config.json in cfHome. A CF_HOME that cf has never been run
+// in has an empty Config.
+func Read(cfHome string) (Config, error) {
+	var config Config
+	bytes, err := ioutil.ReadFile(Path(cfHome))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return config, errors.New(Path(cfHome) + " is invalid: " + err.Error())
+	}
+	return config, nil
+}
+
+// DecodeToken reads the claims of a JWT, as found in config.json, without
+// verifying its signature.
+func DecodeToken(token string) (Token, error) {
+	token = strings.TrimSpace(token)
+	if strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = token[len("bearer "):]
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Token{}, errors.New("token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return Token{}, errors.New("token is not a JWT: " + err.Error())
+	}
+
+	var claims struct {
+		UserName string `json:"user_name"`
+		ClientID string `json:"client_id"`
+		Expiry   int64  `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return Token{}, errors.New("token is not a JWT: " + err.Error())
+	}
+
+	decoded := Token{User: claims.UserName, ClientID: claims.ClientID}
+	if claims.Expiry != 0 {
+		decoded.Expiry = time.Unix(claims.Expiry, 0)
+	}
+	return decoded, nil
+}
+
+func (c Config) LoggedIn() bool {
+	return c.AccessToken != ""
+}
+
+func (c Config) Token() (Token, error) {
+	return DecodeToken(c.AccessToken)
+}
+
+// User returns who the access token was issued to: a user, or for client
+// credentials, a client. It is empty when nobody is logged in.
+func (c Config) User() string {
+	token, err := c.Token()
+	if err != nil {
+		return ""
+	}
+	if token.User != "" {
+		return token.User
+	}
+	return token.ClientID
+}
+
+// TokenExpiry returns when the access token expires, or the zero time if it
+// is unknown.
+func (c Config) TokenExpiry() time.Time {
+	token, _ := c.Token()
+	return token.Expiry
+}
+
+// Valid reports whether cf will be able to make requests without logging in
+// again: either the access token has not expired, or it can be refreshed.
+// Refresh tokens that are opaque, rather than JWTs, are assumed to be valid.
+func (c Config) Valid(now time.Time) bool {
+	if !c.LoggedIn() {
+		return false
+	}
+
+	expiry := c.TokenExpiry()
+	if expiry.IsZero() || now.Before(expiry) {
+		return true
+	}
+	if c.RefreshToken == "" {
+		return false
+	}
+
+	refresh, err := DecodeToken(c.RefreshToken)
+	return err != nil || refresh.Expiry.IsZero() || now.Before(refresh.Expiry)
+}
+
+// LoggedInAs reports whether the config holds a valid session against api
+// for user, so that logging in again is unnecessary.
+func (c Config) LoggedInAs(api, user string, now time.Time) bool {
+	return SameApi(c.Target, api) && c.User() == user && c.Valid(now)
+}
+
+// SameApi compares API URLs, ignoring any trailing slash and the case of the
+// host.
+func SameApi(a, b string) bool {
+	return strings.ToLower(strings.TrimSuffix(a, "/")) == strings.ToLower(strings.TrimSuffix(b, "/"))
+}
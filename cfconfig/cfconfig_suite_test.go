@@ -0,0 +1,13 @@
+package cfconfig_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestGoto(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CF Config Suite")
+}
@@ -0,0 +1,124 @@
+package cfconfig_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/fakecc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("cfconfig", func() {
+	var cfHome string
+	var now time.Time
+
+	BeforeEach(func() {
+		var err error
+		cfHome, err = ioutil.TempDir("", "plex-cfconfig")
+		Ω(err).ShouldNot(HaveOccurred())
+		now = time.Unix(1600000000, 0)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(cfHome)
+	})
+
+	write := func(json string) {
+		Ω(os.MkdirAll(filepath.Join(cfHome, ".cf"), 0700)).Should(Succeed())
+		Ω(ioutil.WriteFile(Path(cfHome), []byte(json), 0600)).Should(Succeed())
+	}
+
+	token := func(username string, expiry time.Time) string {
+		return "bearer " + fakecc.AccessToken("https://api.example.com", username, expiry)
+	}
+
+	It("reads the fields that cf writes", func() {
+		write(`{
+  "ConfigVersion": 3,
+  "Target": "https://api.example.com",
+  "APIVersion": "2.150.0",
+  "AccessToken": "` + token("admin", now.Add(time.Hour)) + `",
+  "RefreshToken": "refresh",
+  "OrganizationFields": {"GUID": "org-guid", "Name": "my-org"},
+  "SpaceFields": {"GUID": "space-guid", "Name": "my-space", "AllowSSH": true},
+  "SSLDisabled": true,
+  "MinCLIVersion": "6.23.0",
+  "ColorEnabled": ""
+}`)
+
+		config, err := Read(cfHome)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.Target).Should(Equal("https://api.example.com"))
+		Ω(config.APIVersion).Should(Equal("2.150.0"))
+		Ω(config.OrganizationFields).Should(Equal(Fields{GUID: "org-guid", Name: "my-org"}))
+		Ω(config.SpaceFields.Name).Should(Equal("my-space"))
+		Ω(config.SSLDisabled).Should(BeTrue())
+		Ω(config.MinCLIVersion).Should(Equal("6.23.0"))
+		Ω(config.User()).Should(Equal("admin"))
+		Ω(config.TokenExpiry()).Should(Equal(now.Add(time.Hour)))
+	})
+
+	It("is empty for a CF_HOME that cf has not been run in", func() {
+		config, err := Read(cfHome)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.LoggedIn()).Should(BeFalse())
+		Ω(config.User()).Should(BeEmpty())
+	})
+
+	It("reports config that cannot be parsed", func() {
+		write(`{"Target": `)
+		_, err := Read(cfHome)
+		Ω(err).Should(MatchError(ContainSubstring("config.json is invalid")))
+	})
+
+	Describe("DecodeToken", func() {
+		It("identifies clients when there is no user", func() {
+			decoded, err := DecodeToken("eyJhbGciOiJIUzI1NiJ9.eyJjbGllbnRfaWQiOiJwaXBlbGluZSIsImV4cCI6MTYwMDAwMDAwMH0.c2ln")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(decoded.User).Should(BeEmpty())
+			Ω(decoded.ClientID).Should(Equal("pipeline"))
+			Ω(decoded.Expiry).Should(Equal(now))
+
+			Ω(Config{AccessToken: "bearer eyJhbGciOiJIUzI1NiJ9.eyJjbGllbnRfaWQiOiJwaXBlbGluZSJ9.c2ln"}.User()).Should(Equal("pipeline"))
+		})
+
+		It("rejects tokens that are not JWTs", func() {
+			_, err := DecodeToken("bearer opaque")
+			Ω(err).Should(MatchError("token is not a JWT"))
+		})
+	})
+
+	Describe("Valid", func() {
+		It("is valid until the access token expires", func() {
+			config := Config{AccessToken: token("admin", now.Add(time.Minute))}
+			Ω(config.Valid(now)).Should(BeTrue())
+			Ω(config.Valid(now.Add(2 * time.Minute))).Should(BeFalse())
+		})
+
+		It("is valid after the access token expires if it can be refreshed", func() {
+			config := Config{AccessToken: token("admin", now.Add(-time.Minute)), RefreshToken: "opaque"}
+			Ω(config.Valid(now)).Should(BeTrue())
+
+			config.RefreshToken = fakecc.AccessToken("https://api.example.com", "admin", now.Add(-time.Second))
+			Ω(config.Valid(now)).Should(BeFalse())
+		})
+
+		It("is not valid when nobody is logged in", func() {
+			Ω(Config{Target: "https://api.example.com"}.Valid(now)).Should(BeFalse())
+		})
+	})
+
+	Describe("LoggedInAs", func() {
+		It("needs the same API, user and a valid token", func() {
+			config := Config{Target: "https://API.example.com/", AccessToken: token("admin", now.Add(time.Minute))}
+			Ω(config.LoggedInAs("https://api.example.com", "admin", now)).Should(BeTrue())
+			Ω(config.LoggedInAs("https://api.other.com", "admin", now)).Should(BeFalse())
+			Ω(config.LoggedInAs("https://api.example.com", "someone-else", now)).Should(BeFalse())
+			Ω(config.LoggedInAs("https://api.example.com", "admin", now.Add(time.Hour))).Should(BeFalse())
+		})
+	})
+})
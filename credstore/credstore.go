@@ -0,0 +1,281 @@
+// Package credstore keeps credentials in a file under CF_PLEX_HOME, encrypted
+// with a key derived from a passphrase or the contents of a key file.
+package credstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+)
+
+// File is the name of the store within CF_PLEX_HOME.
+const File = "credentials.enc"
+
+const PassphraseVar = "CF_PLEX_PASSPHRASE"
+const KeyFileVar = "CF_PLEX_KEY_FILE"
+
+// NewPassphraseVar and NewKeyFileVar give the secret that Rekey encrypts the
+// store with from then on.
+const NewPassphraseVar = "CF_PLEX_NEW_PASSPHRASE"
+const NewKeyFileVar = "CF_PLEX_NEW_KEY_FILE"
+
+const iterations = 100000
+const keyLength = 32
+
+// Entry holds the password for one user of one API. For UAA clients,
+// Username and Password hold the client ID and secret.
+type Entry struct {
+	Api      string `json:"api"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Client   bool   `json:"client,omitempty"`
+}
+
+// Store is a decrypted copy of the store, which is only written back by Save.
+type Store struct {
+	path    string
+	secret  []byte
+	salt    []byte
+	entries []Entry
+}
+
+type sealed struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Secret returns the contents of the file named by CF_PLEX_KEY_FILE or,
+// failing that, CF_PLEX_PASSPHRASE.
+func Secret() ([]byte, error) {
+	secret, err := secretFrom(KeyFileVar, PassphraseVar)
+	if secret == nil && err == nil {
+		err = errors.New("set " + PassphraseVar + " or " + KeyFileVar + " to use the credential store")
+	}
+	return secret, err
+}
+
+// NewSecret returns the contents of the file named by CF_PLEX_NEW_KEY_FILE
+// or, failing that, CF_PLEX_NEW_PASSPHRASE.
+func NewSecret() ([]byte, error) {
+	secret, err := secretFrom(NewKeyFileVar, NewPassphraseVar)
+	if secret == nil && err == nil {
+		err = errors.New("set " + NewPassphraseVar + " or " + NewKeyFileVar + " to re-key the credential store")
+	}
+	return secret, err
+}
+
+// secretFrom reads the file named by keyFileVar or, failing that, the
+// passphrase in passphraseVar. Neither being set is not an error.
+func secretFrom(keyFileVar, passphraseVar string) ([]byte, error) {
+	if keyFile := os.Getenv(keyFileVar); keyFile != "" {
+		secret, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) == 0 {
+			return nil, errors.New(keyFile + " is empty")
+		}
+		return secret, nil
+	}
+
+	if passphrase := os.Getenv(passphraseVar); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+	return nil, nil
+}
+
+// Open decrypts the store in plexHome with secret. A store that does not
+// exist yet is empty.
+func Open(plexHome string, secret []byte) (*Store, error) {
+	store := &Store{path: filepath.Join(plexHome, File), secret: secret}
+
+	bytes, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		store.salt = make([]byte, 16)
+		_, err = rand.Read(store.salt)
+		return store, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var contents sealed
+	if err := json.Unmarshal(bytes, &contents); err != nil || contents.Version != 1 {
+		return nil, errors.New(store.path + " is not a credential store")
+	}
+	store.salt = contents.Salt
+
+	aead, err := store.cipher()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, contents.Nonce, contents.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("the credential store could not be decrypted: check the passphrase or key file")
+	}
+	if err := json.Unmarshal(plaintext, &store.entries); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Entries returns every entry, sorted by API and then username.
+func (s *Store) Entries() []Entry {
+	entries := append([]Entry{}, s.entries...)
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Api != entries[j].Api {
+			return entries[i].Api < entries[j].Api
+		}
+		return entries[i].Username < entries[j].Username
+	})
+	return entries
+}
+
+// Get finds the entry for a user of an API.
+func (s *Store) Get(api, username string) (Entry, bool) {
+	index := s.find(api, username)
+	if index < 0 {
+		return Entry{}, false
+	}
+	return s.entries[index], true
+}
+
+// Put adds an entry, replacing any for the same user of the same API.
+func (s *Store) Put(entry Entry) {
+	index := s.find(entry.Api, entry.Username)
+	if index < 0 {
+		s.entries = append(s.entries, entry)
+		return
+	}
+	s.entries[index] = entry
+}
+
+// Delete removes the entry for a user of an API, reporting whether there was
+// one.
+func (s *Store) Delete(api, username string) bool {
+	index := s.find(api, username)
+	if index < 0 {
+		return false
+	}
+	s.entries = append(s.entries[:index], s.entries[index+1:]...)
+	return true
+}
+
+// Rekey encrypts the store with secret, and a new salt, when it is next
+// saved.
+func (s *Store) Rekey(secret []byte) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	s.secret, s.salt = secret, salt
+	return nil
+}
+
+// Save encrypts the store and writes it back to disk. It is written to a
+// temporary file that then replaces the store, so that the store is never
+// left half written. Callers should hold the lock on CF_PLEX_HOME, so that
+// no other process saves it at the same time.
+func (s *Store) Save() error {
+	plaintext, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+
+	aead, err := s.cipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	bytes, err := json.Marshal(sealed{Version: 1, Salt: s.salt, Nonce: nonce, Ciphertext: aead.Seal(nil, nonce, plaintext, nil)})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	temp, err := ioutil.TempFile(filepath.Dir(s.path), "."+File+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if err := temp.Chmod(0600); err != nil {
+		temp.Close()
+		return err
+	}
+	if _, err := temp.Write(bytes); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.path)
+}
+
+func (s *Store) find(api, username string) int {
+	for index, entry := range s.entries {
+		if cfconfig.SameApi(entry.Api, api) && entry.Username == username {
+			return index
+		}
+	}
+	return -1
+}
+
+func (s *Store) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2(s.secret, s.salt, iterations, keyLength))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key from secret as described in RFC 8018, using
+// HMAC-SHA256.
+func pbkdf2(secret, salt []byte, iterations, length int) []byte {
+	prf := hmac.New(sha256.New, secret)
+	var key []byte
+
+	for block := uint32(1); len(key) < length; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var counter [4]byte
+		binary.BigEndian.PutUint32(counter[:], block)
+		prf.Write(counter[:])
+		u := prf.Sum(nil)
+
+		t := append([]byte{}, u...)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:length]
+}
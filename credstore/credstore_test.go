@@ -0,0 +1,161 @@
+package credstore_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/credstore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("credstore", func() {
+	var plexHome string
+	api := "https://api.example.com"
+
+	BeforeEach(func() {
+		var err error
+		plexHome, err = ioutil.TempDir("", "plex-credstore")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(plexHome)
+		os.Unsetenv(PassphraseVar)
+		os.Unsetenv(KeyFileVar)
+		os.Unsetenv(NewPassphraseVar)
+		os.Unsetenv(NewKeyFileVar)
+	})
+
+	save := func(secret string, entries ...Entry) {
+		store, err := Open(plexHome, []byte(secret))
+		Ω(err).ShouldNot(HaveOccurred())
+		for _, entry := range entries {
+			store.Put(entry)
+		}
+		Ω(store.Save()).Should(Succeed())
+	}
+
+	It("is empty until something is saved", func() {
+		store, err := Open(plexHome, []byte("passphrase"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(store.Entries()).Should(BeEmpty())
+		Ω(filepath.Join(plexHome, File)).ShouldNot(BeAnExistingFile())
+	})
+
+	It("saves entries encrypted, and reads them back", func() {
+		save("passphrase",
+			Entry{Api: "https://api.two.com", Username: "admin", Password: "secret-two"},
+			Entry{Api: api, Username: "admin", Password: "secret-one"},
+		)
+
+		bytes, err := ioutil.ReadFile(filepath.Join(plexHome, File))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(bytes)).ShouldNot(ContainSubstring("secret"))
+		Ω(string(bytes)).ShouldNot(ContainSubstring("admin"))
+		info, err := os.Stat(filepath.Join(plexHome, File))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(info.Mode().Perm()).Should(Equal(os.FileMode(0600)))
+
+		store, err := Open(plexHome, []byte("passphrase"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(store.Entries()).Should(Equal([]Entry{
+			{Api: api, Username: "admin", Password: "secret-one"},
+			{Api: "https://api.two.com", Username: "admin", Password: "secret-two"},
+		}))
+
+		entry, found := store.Get(api+"/", "admin")
+		Ω(found).Should(BeTrue())
+		Ω(entry.Password).Should(Equal("secret-one"))
+	})
+
+	It("replaces and deletes entries", func() {
+		save("passphrase", Entry{Api: api, Username: "admin", Password: "old"}, Entry{Api: api, Username: "dev", Password: "dev"})
+		save("passphrase", Entry{Api: api, Username: "admin", Password: "new"})
+
+		store, err := Open(plexHome, []byte("passphrase"))
+		Ω(err).ShouldNot(HaveOccurred())
+		entry, _ := store.Get(api, "admin")
+		Ω(entry.Password).Should(Equal("new"))
+
+		Ω(store.Delete(api, "dev")).Should(BeTrue())
+		Ω(store.Delete(api, "dev")).Should(BeFalse())
+		Ω(store.Entries()).Should(HaveLen(1))
+	})
+
+	It("replaces the store whole, leaving nothing else behind", func() {
+		save("passphrase", Entry{Api: api, Username: "admin", Password: "old"})
+		save("passphrase", Entry{Api: api, Username: "admin", Password: "new"})
+
+		files, err := ioutil.ReadDir(plexHome)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(files).Should(HaveLen(1))
+		Ω(files[0].Name()).Should(Equal(File))
+		Ω(files[0].Mode().Perm()).Should(Equal(os.FileMode(0600)))
+	})
+
+	It("can be re-keyed", func() {
+		save("passphrase", Entry{Api: api, Username: "admin", Password: "secret"})
+
+		store, err := Open(plexHome, []byte("passphrase"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(store.Rekey([]byte("new passphrase"))).Should(Succeed())
+		Ω(store.Save()).Should(Succeed())
+
+		_, err = Open(plexHome, []byte("passphrase"))
+		Ω(err).Should(HaveOccurred())
+		store, err = Open(plexHome, []byte("new passphrase"))
+		Ω(err).ShouldNot(HaveOccurred())
+		entry, _ := store.Get(api, "admin")
+		Ω(entry.Password).Should(Equal("secret"))
+	})
+
+	It("cannot be read with the wrong passphrase", func() {
+		save("passphrase", Entry{Api: api, Username: "admin", Password: "secret"})
+
+		_, err := Open(plexHome, []byte("guess"))
+		Ω(err).Should(MatchError("the credential store could not be decrypted: check the passphrase or key file"))
+	})
+
+	Describe("Secret", func() {
+		It("prefers a key file to a passphrase", func() {
+			keyFile := filepath.Join(plexHome, "key")
+			Ω(ioutil.WriteFile(keyFile, []byte("from a file"), 0600)).Should(Succeed())
+			os.Setenv(KeyFileVar, keyFile)
+			os.Setenv(PassphraseVar, "passphrase")
+
+			Ω(Secret()).Should(Equal([]byte("from a file")))
+		})
+
+		It("needs one or the other", func() {
+			_, err := Secret()
+			Ω(err).Should(MatchError("set CF_PLEX_PASSPHRASE or CF_PLEX_KEY_FILE to use the credential store"))
+		})
+	})
+
+	Describe("NewSecret", func() {
+		It("is read from variables of its own", func() {
+			os.Setenv(PassphraseVar, "passphrase")
+			_, err := NewSecret()
+			Ω(err).Should(MatchError("set CF_PLEX_NEW_PASSPHRASE or CF_PLEX_NEW_KEY_FILE to re-key the credential store"))
+
+			keyFile := filepath.Join(plexHome, "key")
+			Ω(ioutil.WriteFile(keyFile, []byte("from a file"), 0600)).Should(Succeed())
+			os.Setenv(NewKeyFileVar, keyFile)
+			os.Setenv(NewPassphraseVar, "new passphrase")
+			Ω(NewSecret()).Should(Equal([]byte("from a file")))
+		})
+	})
+
+	Describe("key derivation", func() {
+		It("matches the PBKDF2-HMAC-SHA256 test vectors of RFC 7914", func() {
+			key := PBKDF2([]byte("passwd"), []byte("salt"), 1, 64)
+			Ω(hex.EncodeToString(key)).Should(Equal("55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"))
+
+			key = PBKDF2([]byte("Password"), []byte("NaCl"), 80000, 64)
+			Ω(hex.EncodeToString(key)).Should(Equal("4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"))
+		})
+	})
+})
@@ -2,26 +2,37 @@ package env
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 )
 
+type AuthType string
+
+const (
+	Password          AuthType = "password"
+	ClientCredentials AuthType = "client-credentials"
+)
+
 type Coord struct {
 	Username string
 	Password string
 	Api      string
+	Auth     AuthType
+	Origin   string
 }
 
 const PlexTripleSeparator = ";"
 const PlexCredApiSeparator = ">"
 const PlexUserPassSeparator = "^"
+const PlexAuthSeparator = "|"
 
-func GetCoordinates(cfEnvs, tripleSeparator, credApiSeparator, userPassSeparator string) ([]Coord, error) {
+func GetCoordinates(cfEnvs, tripleSeparator, credApiSeparator, userPassSeparator, authSeparator string) ([]Coord, error) {
 	var coords []Coord
 	triples := GetTriples(cfEnvs, tripleSeparator)
-	for _, triple := range triples {
-		coord, err := GetCoordinate(triple, credApiSeparator, userPassSeparator)
+	for index, triple := range triples {
+		coord, err := GetCoordinate(triple, credApiSeparator, userPassSeparator, authSeparator)
 		if err != nil {
-			return nil, err
+			return nil, errors.New("entry " + strconv.Itoa(index+1) + " " + err.Error())
 		}
 		coords = append(coords, coord)
 	}
@@ -32,16 +43,44 @@ func GetTriples(cfEnvs, tripleSeparator string) []string {
 	return strings.Split(cfEnvs, tripleSeparator)
 }
 
-func GetCoordinate(triple, credApiSeparator, userPassSeparator string) (coord Coord, err error) {
-	if strings.Count(triple, credApiSeparator) != 1 ||
-		strings.Count(triple, userPassSeparator) != 1 {
-		return coord, errors.New(triple + " is invalid")
+// GetCoordinate parses one API. The credentials may be preceded by an auth
+// type and authSeparator: client-credentials, to authenticate as a UAA
+// client, or origin:<origin>, to authenticate a user against another
+// identity provider. Errors never include the triple, which holds a
+// password.
+func GetCoordinate(triple, credApiSeparator, userPassSeparator, authSeparator string) (coord Coord, err error) {
+	auth, rest := splitAuth(triple, authSeparator)
+	if strings.Count(rest, credApiSeparator) != 1 ||
+		strings.Count(rest, userPassSeparator) != 1 {
+		return coord, errors.New("is invalid: use <username>" + userPassSeparator + "<password>" + credApiSeparator + "<api>")
 	}
-	credsAndApi := strings.Split(triple, credApiSeparator)
+	credsAndApi := strings.Split(rest, credApiSeparator)
 	creds := strings.Split(credsAndApi[0], userPassSeparator)
 	username := creds[0]
 	password := creds[1]
 	api := credsAndApi[1]
 
-	return Coord{Username: username, Password: password, Api: api}, err
+	coord = Coord{Username: username, Password: password, Api: api, Auth: Password}
+	switch {
+	case auth == string(ClientCredentials):
+		coord.Auth = ClientCredentials
+	case strings.HasPrefix(auth, "origin:"):
+		coord.Origin = strings.TrimPrefix(auth, "origin:")
+	}
+	return coord, err
+}
+
+// splitAuth removes a recognised auth type from the front of triple, leaving
+// credentials that merely contain authSeparator alone.
+func splitAuth(triple, authSeparator string) (string, string) {
+	parts := strings.SplitN(triple, authSeparator, 2)
+	if len(parts) != 2 {
+		return "", triple
+	}
+
+	auth := parts[0]
+	if auth == string(ClientCredentials) || (strings.HasPrefix(auth, "origin:") && auth != "origin:") {
+		return auth, parts[1]
+	}
+	return "", triple
 }
@@ -10,7 +10,7 @@ var _ = Describe("API env var functions", func() {
 	Describe("getCoordinate", func() {
 		It("returns coordinate for an API", func() {
 			cfEnv := "username^password>api.com"
-			coords, err := GetCoordinate(cfEnv, PlexCredApiSeparator, PlexUserPassSeparator)
+			coords, err := GetCoordinate(cfEnv, PlexCredApiSeparator, PlexUserPassSeparator, PlexAuthSeparator)
 			Ω(err).ShouldNot(HaveOccurred())
 			Ω(coords.Username).Should(Equal("username"))
 			Ω(coords.Password).Should(Equal("password"))
@@ -19,9 +19,26 @@ var _ = Describe("API env var functions", func() {
 
 		It("returns an error for invalid values", func() {
 			cfEnv := "username^password"
-			_, err := GetCoordinate(cfEnv, PlexCredApiSeparator, PlexUserPassSeparator)
+			_, err := GetCoordinate(cfEnv, PlexCredApiSeparator, PlexUserPassSeparator, PlexAuthSeparator)
 			Ω(err).Should(HaveOccurred())
-			Ω(err).Should(MatchError("username^password is invalid"))
+			Ω(err).Should(MatchError("is invalid: use <username>^<password>><api>"))
+			Ω(err.Error()).ShouldNot(ContainSubstring("username^password"))
+		})
+
+		It("reads an auth type from before the credentials", func() {
+			coord, err := GetCoordinate("client-credentials|pipeline^secret>api.com", PlexCredApiSeparator, PlexUserPassSeparator, PlexAuthSeparator)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(coord).Should(Equal(Coord{Username: "pipeline", Password: "secret", Api: "api.com", Auth: ClientCredentials}))
+
+			coord, err = GetCoordinate("origin:ldap|username^password>api.com", PlexCredApiSeparator, PlexUserPassSeparator, PlexAuthSeparator)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(coord).Should(Equal(Coord{Username: "username", Password: "password", Api: "api.com", Auth: Password, Origin: "ldap"}))
+		})
+
+		It("leaves credentials containing the auth separator alone", func() {
+			coord, err := GetCoordinate("user|name^pass|word>api.com", PlexCredApiSeparator, PlexUserPassSeparator, PlexAuthSeparator)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(coord).Should(Equal(Coord{Username: "user|name", Password: "pass|word", Api: "api.com", Auth: Password}))
 		})
 	})
 
@@ -37,25 +54,26 @@ var _ = Describe("API env var functions", func() {
 	Describe("GetCoordinates", func() {
 		It("returns coordinates for many APIs", func() {
 			cfEnvs := "user1^pass1>api1.com;user2^pass2>api2.com"
-			coords, err := GetCoordinates(cfEnvs, PlexTripleSeparator, PlexCredApiSeparator, PlexUserPassSeparator)
+			coords, err := GetCoordinates(cfEnvs, PlexTripleSeparator, PlexCredApiSeparator, PlexUserPassSeparator, PlexAuthSeparator)
 			Ω(err).ShouldNot(HaveOccurred())
-			Ω(coords[0]).Should(Equal(Coord{Username: "user1", Password: "pass1", Api: "api1.com"}))
-			Ω(coords[1]).Should(Equal(Coord{Username: "user2", Password: "pass2", Api: "api2.com"}))
+			Ω(coords[0]).Should(Equal(Coord{Username: "user1", Password: "pass1", Api: "api1.com", Auth: Password}))
+			Ω(coords[1]).Should(Equal(Coord{Username: "user2", Password: "pass2", Api: "api2.com", Auth: Password}))
 		})
 
 		It("returns an error for invalid values", func() {
-			cfEnv := "username^password>api.com;user^pass"
-			_, err := GetCoordinates(cfEnv, PlexTripleSeparator, PlexCredApiSeparator, PlexUserPassSeparator)
+			cfEnv := "username^password>api.com;user^s3cret"
+			_, err := GetCoordinates(cfEnv, PlexTripleSeparator, PlexCredApiSeparator, PlexUserPassSeparator, PlexAuthSeparator)
 			Ω(err).Should(HaveOccurred())
-			Ω(err).Should(MatchError("user^pass is invalid"))
+			Ω(err).Should(MatchError("entry 2 is invalid: use <username>^<password>><api>"))
+			Ω(err.Error()).ShouldNot(ContainSubstring("s3cret"))
 		})
 
 		It("allows multi-char separators", func() {
 			cfEnvs := "user1-foo-pass1_https://api1.com|user2-foo-pass2_https://api2.com"
-			coords, err := GetCoordinates(cfEnvs, "|", "_", "-foo-")
+			coords, err := GetCoordinates(cfEnvs, "|", "_", "-foo-", PlexAuthSeparator)
 			Ω(err).ShouldNot(HaveOccurred())
-			Ω(coords[0]).Should(Equal(Coord{Username: "user1", Password: "pass1", Api: "https://api1.com"}))
-			Ω(coords[1]).Should(Equal(Coord{Username: "user2", Password: "pass2", Api: "https://api2.com"}))
+			Ω(coords[0]).Should(Equal(Coord{Username: "user1", Password: "pass1", Api: "https://api1.com", Auth: Password}))
+			Ω(coords[1]).Should(Equal(Coord{Username: "user2", Password: "pass2", Api: "https://api2.com", Auth: Password}))
 		})
 	})
 })
@@ -0,0 +1,343 @@
+package fakecc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Foundation describes the users and the orgs of a fake Cloud Foundry.
+// OtherUsers maps the names of any users besides Username to their
+// passwords.
+type Foundation struct {
+	Addr       string
+	Username   string
+	Password   string
+	OtherUsers map[string]string
+	Orgs       []string
+}
+
+type RootHandler struct {
+	Addr string
+}
+
+type InfoHandler struct {
+	Addr string
+}
+
+type V3Handler struct {
+	Addr string
+}
+
+type LoginHandler struct {
+	Addr string
+}
+
+type TokenHandler struct {
+	Foundation Foundation
+}
+
+type OrgsHandler struct {
+	Foundation Foundation
+}
+
+type SpacesHandler struct{}
+
+func (h RootHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"links": map[string]interface{}{
+			"self":                link(h.Addr),
+			"cloud_controller_v2": versionedLink(h.Addr+"/v2", APIVersion),
+			"cloud_controller_v3": versionedLink(h.Addr+"/v3", V3Version),
+			"uaa":                 link(h.Addr),
+			"login":               link(h.Addr),
+			"logging":             link(loggingEndpoint(h.Addr)),
+		},
+	})
+}
+
+func (h InfoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":                         "fakecc",
+		"build":                        "",
+		"support":                      "",
+		"version":                      0,
+		"description":                  "Fake Cloud Controller",
+		"authorization_endpoint":       h.Addr,
+		"token_endpoint":               h.Addr,
+		"min_cli_version":              nil,
+		"min_recommended_cli_version":  nil,
+		"api_version":                  APIVersion,
+		"app_ssh_endpoint":             "",
+		"app_ssh_host_key_fingerprint": "",
+		"app_ssh_oauth_client":         "ssh-proxy",
+		"doppler_logging_endpoint":     loggingEndpoint(h.Addr),
+	})
+}
+
+func (h V3Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"links": map[string]interface{}{
+			"self":          link(h.Addr + "/v3"),
+			"organizations": link(h.Addr + "/v3/organizations"),
+			"spaces":        link(h.Addr + "/v3/spaces"),
+		},
+	})
+}
+
+func (h LoginHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"app":   map[string]string{"version": "4.30.0"},
+		"links": map[string]string{"uaa": h.Addr, "login": h.Addr},
+		"prompts": map[string][]string{
+			"username": {"text", "Email"},
+			"password": {"password", "Password"},
+		},
+	})
+}
+
+func (h TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	username := h.Foundation.Username
+
+	switch r.PostForm.Get("grant_type") {
+	case "password":
+		username = r.PostForm.Get("username")
+		if !h.Foundation.accepts(username, r.PostForm.Get("password")) {
+			unauthorized(w)
+			return
+		}
+	case "client_credentials":
+		clientID, clientSecret, _ := r.BasicAuth()
+		if r.PostForm.Get("client_id") != "" {
+			clientID = r.PostForm.Get("client_id")
+			clientSecret = r.PostForm.Get("client_secret")
+		}
+		if clientID != h.Foundation.Username || clientSecret != h.Foundation.Password {
+			unauthorized(w)
+			return
+		}
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") != RefreshToken {
+			unauthorized(w)
+			return
+		}
+	default:
+		unauthorized(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  AccessToken(h.Foundation.Addr, username, time.Now().Add(10*time.Minute)),
+		"token_type":    "bearer",
+		"refresh_token": RefreshToken,
+		"expires_in":    599,
+		"scope":         "cloud_controller.read cloud_controller.write openid",
+		"jti":           "fake-jti",
+	})
+}
+
+func (f Foundation) accepts(username, password string) bool {
+	if username == f.Username {
+		return password == f.Password
+	}
+	other, found := f.OtherUsers[username]
+	return found && password == other
+}
+
+func (h OrgsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorised(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"code":        1000,
+			"description": "Invalid Auth Token",
+			"error_code":  "CF-InvalidAuthToken",
+		})
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/v2/organizations/") && strings.HasSuffix(r.URL.Path, "/spaces") {
+		writeJSON(w, http.StatusOK, v2List(nil))
+		return
+	}
+
+	names := h.Foundation.Orgs
+	if query := r.URL.Query().Get("q"); strings.HasPrefix(query, "name:") {
+		names = filter(names, []string{strings.TrimPrefix(query, "name:")})
+	}
+	if query := r.URL.Query().Get("names"); query != "" {
+		names = filter(names, strings.Split(query, ","))
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/v3/") {
+		var resources []interface{}
+		for _, name := range names {
+			resources = append(resources, map[string]interface{}{
+				"guid":       guid(name),
+				"name":       name,
+				"suspended":  false,
+				"created_at": "2016-01-01T00:00:00Z",
+				"updated_at": "2016-01-01T00:00:00Z",
+				"metadata":   map[string]interface{}{"labels": map[string]string{}, "annotations": map[string]string{}},
+				"links":      map[string]interface{}{"self": link(h.Foundation.Addr + "/v3/organizations/" + guid(name))},
+			})
+		}
+		writeJSON(w, http.StatusOK, v3List(resources))
+		return
+	}
+
+	var resources []interface{}
+	for _, name := range names {
+		resources = append(resources, map[string]interface{}{
+			"metadata": map[string]string{
+				"guid":       guid(name),
+				"url":        "/v2/organizations/" + guid(name),
+				"created_at": "2016-01-01T00:00:00Z",
+			},
+			"entity": map[string]interface{}{
+				"name":       name,
+				"status":     "active",
+				"spaces_url": "/v2/organizations/" + guid(name) + "/spaces",
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, v2List(resources))
+}
+
+func (h SpacesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/v3/") {
+		writeJSON(w, http.StatusOK, v3List(nil))
+		return
+	}
+	writeJSON(w, http.StatusOK, v2List(nil))
+}
+
+// Configure makes server behave enough like a Cloud Controller and UAA for
+// cf api, cf auth, cf login, cf orgs and cf target to work against it.
+func Configure(server *http.Server, foundation Foundation) {
+	mux := http.NewServeMux()
+	mux.Handle("/", RootHandler{Addr: foundation.Addr})
+	mux.Handle("/v2/info", InfoHandler{Addr: foundation.Addr})
+	mux.Handle("/v3", V3Handler{Addr: foundation.Addr})
+	mux.Handle("/login", LoginHandler{Addr: foundation.Addr})
+	mux.Handle("/oauth/token", TokenHandler{Foundation: foundation})
+	mux.Handle("/v2/organizations", OrgsHandler{Foundation: foundation})
+	mux.Handle("/v2/organizations/", OrgsHandler{Foundation: foundation})
+	mux.Handle("/v3/organizations", OrgsHandler{Foundation: foundation})
+	mux.Handle("/v2/spaces", SpacesHandler{})
+	mux.Handle("/v3/spaces", SpacesHandler{})
+	server.Handler = mux
+}
+
+const APIVersion = "2.150.0"
+const V3Version = "3.85.0"
+const RefreshToken = "fake-refresh-token"
+
+// AccessToken returns an unsigned JWT of the kind issued by UAA. The cf CLI
+// decodes it to find the current user and when it expires.
+func AccessToken(issuer, username string, expiry time.Time) string {
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"jti":        "fake-jti",
+		"user_id":    guid(username),
+		"user_name":  username,
+		"email":      username,
+		"origin":     "uaa",
+		"client_id":  "cf",
+		"grant_type": "password",
+		"scope":      []string{"cloud_controller.read", "cloud_controller.write", "openid"},
+		"iss":        issuer + "/oauth/token",
+		"iat":        time.Now().Unix(),
+		"exp":        expiry.Unix(),
+	})
+
+	encode := base64.RawURLEncoding.EncodeToString
+	return encode(header) + "." + encode(claims) + "." + encode([]byte("fake-signature"))
+}
+
+func authorised(r *http.Request) bool {
+	return strings.HasPrefix(strings.ToLower(r.Header.Get("Authorization")), "bearer ")
+}
+
+func unauthorized(w http.ResponseWriter) {
+	writeJSON(w, http.StatusUnauthorized, map[string]string{
+		"error":             "unauthorized",
+		"error_description": "Bad credentials",
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func link(href string) map[string]interface{} {
+	return map[string]interface{}{"href": href}
+}
+
+func versionedLink(href, version string) map[string]interface{} {
+	return map[string]interface{}{"href": href, "meta": map[string]string{"version": version}}
+}
+
+func loggingEndpoint(addr string) string {
+	return strings.Replace(addr, "http", "ws", 1)
+}
+
+func v2List(resources []interface{}) map[string]interface{} {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return map[string]interface{}{
+		"total_results": len(resources),
+		"total_pages":   1,
+		"prev_url":      nil,
+		"next_url":      nil,
+		"resources":     resources,
+	}
+}
+
+func v3List(resources []interface{}) map[string]interface{} {
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return map[string]interface{}{
+		"pagination": map[string]interface{}{
+			"total_results": len(resources),
+			"total_pages":   1,
+			"first":         link(""),
+			"last":          link(""),
+			"next":          nil,
+			"previous":      nil,
+		},
+		"resources": resources,
+	}
+}
+
+func filter(names, wanted []string) []string {
+	var filtered []string
+	for _, name := range names {
+		for _, want := range wanted {
+			if name == want {
+				filtered = append(filtered, name)
+			}
+		}
+	}
+	return filtered
+}
+
+func guid(name string) string {
+	return fmt.Sprintf("%x", []byte(name))
+}
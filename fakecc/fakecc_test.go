@@ -0,0 +1,135 @@
+package fakecc_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/fakecc"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/bitly/go-simplejson"
+
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+var _ = Describe("Fake Cloud Controller", func() {
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		server = httptest.NewServer(nil)
+		Configure(server.Config, Foundation{
+			Addr:       server.URL,
+			Username:   "admin",
+			Password:   "secret",
+			OtherUsers: map[string]string{"auditor": "hunter2"},
+			Orgs:       []string{"system", "testing"},
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(path string, token string) (*http.Response, *simplejson.Json) {
+		req, err := http.NewRequest("GET", server.URL+path, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		if token != "" {
+			req.Header.Set("Authorization", "bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		Ω(err).ShouldNot(HaveOccurred())
+		json, err := simplejson.NewFromReader(resp.Body)
+		Ω(err).ShouldNot(HaveOccurred())
+		return resp, json
+	}
+
+	authenticate := func(form url.Values) (*http.Response, *simplejson.Json) {
+		resp, err := http.PostForm(server.URL+"/oauth/token", form)
+		Ω(err).ShouldNot(HaveOccurred())
+		json, err := simplejson.NewFromReader(resp.Body)
+		Ω(err).ShouldNot(HaveOccurred())
+		return resp, json
+	}
+
+	Describe("/v2/info", func() {
+		It("points at itself for authentication", func() {
+			_, json := get("/v2/info", "")
+			Ω(json.Get("authorization_endpoint").MustString()).Should(Equal(server.URL))
+			Ω(json.Get("token_endpoint").MustString()).Should(Equal(server.URL))
+			Ω(json.Get("api_version").MustString()).Should(Equal(APIVersion))
+		})
+	})
+
+	Describe("/ and /v3", func() {
+		It("links to the v2 and v3 APIs", func() {
+			_, json := get("/", "")
+			Ω(json.GetPath("links", "cloud_controller_v3", "href").MustString()).Should(Equal(server.URL + "/v3"))
+
+			_, json = get("/v3", "")
+			Ω(json.GetPath("links", "organizations", "href").MustString()).Should(Equal(server.URL + "/v3/organizations"))
+		})
+	})
+
+	Describe("/oauth/token", func() {
+		It("issues a token for the right username and password", func() {
+			resp, json := authenticate(url.Values{"grant_type": {"password"}, "username": {"admin"}, "password": {"secret"}})
+			Ω(resp.StatusCode).Should(Equal(200))
+
+			claims := strings.Split(json.Get("access_token").MustString(), ".")[1]
+			decoded, err := base64.RawURLEncoding.DecodeString(claims)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(decoded)).Should(ContainSubstring(`"user_name":"admin"`))
+		})
+
+		It("issues tokens to other users in their own names", func() {
+			resp, json := authenticate(url.Values{"grant_type": {"password"}, "username": {"auditor"}, "password": {"hunter2"}})
+			Ω(resp.StatusCode).Should(Equal(200))
+
+			claims := strings.Split(json.Get("access_token").MustString(), ".")[1]
+			decoded, err := base64.RawURLEncoding.DecodeString(claims)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(decoded)).Should(ContainSubstring(`"user_name":"auditor"`))
+
+			resp, _ = authenticate(url.Values{"grant_type": {"password"}, "username": {"auditor"}, "password": {"secret"}})
+			Ω(resp.StatusCode).Should(Equal(401))
+		})
+
+		It("issues a token for client credentials", func() {
+			resp, _ := authenticate(url.Values{"grant_type": {"client_credentials"}, "client_id": {"admin"}, "client_secret": {"secret"}})
+			Ω(resp.StatusCode).Should(Equal(200))
+		})
+
+		It("rejects the wrong password", func() {
+			resp, json := authenticate(url.Values{"grant_type": {"password"}, "username": {"admin"}, "password": {"wrong"}})
+			Ω(resp.StatusCode).Should(Equal(401))
+			Ω(json.Get("error").MustString()).Should(Equal("unauthorized"))
+		})
+	})
+
+	Describe("organizations", func() {
+		It("requires a token", func() {
+			resp, _ := get("/v2/organizations", "")
+			Ω(resp.StatusCode).Should(Equal(401))
+		})
+
+		It("lists orgs", func() {
+			_, json := get("/v2/organizations", "token")
+			Ω(json.Get("total_results").MustInt()).Should(Equal(2))
+			Ω(json.Get("resources").GetIndex(1).GetPath("entity", "name").MustString()).Should(Equal("testing"))
+
+			_, json = get("/v3/organizations", "token")
+			Ω(json.GetPath("pagination", "total_results").MustInt()).Should(Equal(2))
+			Ω(json.Get("resources").GetIndex(0).Get("name").MustString()).Should(Equal("system"))
+		})
+
+		It("filters orgs by name", func() {
+			_, json := get("/v2/organizations?q=name:testing", "token")
+			Ω(json.Get("total_results").MustInt()).Should(Equal(1))
+
+			_, json = get("/v3/organizations?names=does-not-exist", "token")
+			Ω(json.GetPath("pagination", "total_results").MustInt()).Should(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,41 @@
+// A fake cf CLI for tests. Invocations are appended to the file named by
+// FAKE_CF_LOG, and responses are replayed from the script named by
+// FAKE_CF_SCRIPT. Invocations that match no rule fall back to an imitation
+// of the commands that cf-plex relies upon.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/EngineerBetter/cf-plex/fakecf"
+)
+
+func main() {
+	args := os.Args[1:]
+	cfHome := os.Getenv("CF_HOME")
+	if cfHome == "" {
+		cfHome = os.Getenv("HOME")
+	}
+
+	if logPath := os.Getenv(fakecf.LogVar); logPath != "" {
+		bailIfB0rked(fakecf.Record(logPath, fakecf.Invocation{Binary: os.Args[0], Args: args, CfHome: cfHome}))
+	}
+
+	if scriptPath := os.Getenv(fakecf.ScriptVar); scriptPath != "" {
+		script, err := fakecf.ReadScript(scriptPath)
+		bailIfB0rked(err)
+		if rule, ok := script.Match(args, cfHome); ok {
+			os.Exit(rule.Replay(os.Stdout, os.Stderr))
+		}
+	}
+
+	os.Exit(fakecf.Builtin(args, cfHome, os.Stdin, os.Stdout, os.Stderr))
+}
+
+func bailIfB0rked(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,322 @@
+package fakecf
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/fakecc"
+)
+
+// Rule is a canned response. It applies to invocations whose arguments start
+// with Args, and whose CF_HOME contains CfHome.
+type Rule struct {
+	Args     []string `json:"args"`
+	CfHome   string   `json:"cf_home,omitempty"`
+	Stdout   string   `json:"stdout,omitempty"`
+	Stderr   string   `json:"stderr,omitempty"`
+	ExitCode int      `json:"exit_code"`
+	DelayMs  int      `json:"delay_ms,omitempty"`
+}
+
+type Script struct {
+	Rules []Rule `json:"rules"`
+}
+
+type Invocation struct {
+	Binary string   `json:"binary"`
+	Args   []string `json:"args"`
+	CfHome string   `json:"cf_home"`
+}
+
+const ScriptVar = "FAKE_CF_SCRIPT"
+const LogVar = "FAKE_CF_LOG"
+const PasswordVar = "FAKE_CF_PASSWORD"
+const VersionVar = "FAKE_CF_VERSION"
+
+func WriteScript(path string, script Script) error {
+	bytes, err := json.Marshal(script)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, bytes, 0600)
+}
+
+func ReadScript(path string) (Script, error) {
+	var script Script
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return script, err
+	}
+	err = json.Unmarshal(bytes, &script)
+	return script, err
+}
+
+// Match returns the first rule that applies to an invocation.
+func (s Script) Match(args []string, cfHome string) (Rule, bool) {
+	for _, rule := range s.Rules {
+		if len(rule.Args) > len(args) || !strings.Contains(cfHome, rule.CfHome) {
+			continue
+		}
+
+		matches := true
+		for index, arg := range rule.Args {
+			if args[index] != arg {
+				matches = false
+			}
+		}
+		if matches {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (r Rule) Replay(stdout, stderr io.Writer) int {
+	time.Sleep(time.Duration(r.DelayMs) * time.Millisecond)
+	fmt.Fprint(stdout, r.Stdout)
+	fmt.Fprint(stderr, r.Stderr)
+	return r.ExitCode
+}
+
+// Record appends an invocation to the log at path.
+func Record(path string, invocation Invocation) error {
+	bytes, err := json.Marshal(invocation)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(bytes, '\n'))
+	return err
+}
+
+// Invocations reads back every invocation recorded at path.
+func Invocations(path string) ([]Invocation, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var invocations []Invocation
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var invocation Invocation
+		if err := json.Unmarshal(scanner.Bytes(), &invocation); err != nil {
+			return nil, err
+		}
+		invocations = append(invocations, invocation)
+	}
+	return invocations, scanner.Err()
+}
+
+func ConfigPath(cfHome string) string {
+	return cfconfig.Path(cfHome)
+}
+
+func ReadConfig(cfHome string) (cfconfig.Config, error) {
+	config := cfconfig.Config{ConfigVersion: 3}
+	bytes, err := ioutil.ReadFile(ConfigPath(cfHome))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	err = json.Unmarshal(bytes, &config)
+	return config, err
+}
+
+func WriteConfig(cfHome string, config cfconfig.Config) error {
+	bytes, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ConfigPath(cfHome)), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ConfigPath(cfHome), bytes, 0600)
+}
+
+// Builtin imitates the commands that cf-plex itself relies upon, keeping
+// state in config.json much as the real cf CLI does. Other commands print
+// their arguments and succeed.
+func Builtin(args []string, cfHome string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stdout, "Usage: cf [command]")
+		return 0
+	}
+
+	config, err := ReadConfig(cfHome)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	in := bufio.NewReader(stdin)
+	flags, positional := parseFlags(args[1:])
+	loggedIn := config.AccessToken != ""
+
+	switch args[0] {
+	case "version", "--version", "-v":
+		version := os.Getenv(VersionVar)
+		if version == "" {
+			version = "6.53.0+fake.2020-01-01"
+		}
+		fmt.Fprintln(stdout, "cf version "+version)
+		return 0
+	case "api":
+		if len(positional) > 0 {
+			config.Target = positional[0]
+			_, config.SSLDisabled = flags["skip-ssl-validation"]
+			fmt.Fprintf(stdout, "Setting api endpoint to %s...\nOK\n\n", config.Target)
+		}
+		if config.Target == "" {
+			fmt.Fprintln(stdout, "No api endpoint set. Use 'cf api' to set an endpoint")
+			return 0
+		}
+		fmt.Fprintf(stdout, "api endpoint:   %s\napi version:    %s\n", config.Target, fakecc.APIVersion)
+		if !loggedIn {
+			fmt.Fprintln(stdout, "Not logged in. Use 'cf login' to log in.")
+		}
+	case "auth":
+		if len(positional) < 2 {
+			fmt.Fprintln(stdout, "Incorrect Usage: the required arguments `USERNAME` and `PASSWORD` were not provided")
+			return 1
+		}
+		if !authenticate(&config, positional[0], positional[1], stdout) {
+			return 1
+		}
+		fmt.Fprintln(stdout, "Use 'cf target' to view or set your target org and space.")
+	case "login":
+		if api, ok := flags["a"]; ok {
+			config.Target = api
+			fmt.Fprintf(stdout, "API endpoint: %s\n\n", api)
+		}
+		if _, ok := flags["sso"]; ok {
+			fmt.Fprintln(stdout, "Temporary Authentication Code ( Get one at "+config.Target+"/passcode )>")
+			prompt(in)
+			flags["u"] = "sso-user"
+			flags["p"] = os.Getenv(PasswordVar)
+		}
+		if _, ok := flags["u"]; !ok {
+			fmt.Fprint(stdout, "Email> ")
+			flags["u"] = prompt(in)
+		}
+		if _, ok := flags["p"]; !ok {
+			fmt.Fprint(stdout, "Password> ")
+			flags["p"] = prompt(in)
+		}
+		if !authenticate(&config, flags["u"], flags["p"], stdout) {
+			return 1
+		}
+		config.OrganizationFields.Name = flags["o"]
+		config.SpaceFields.Name = flags["s"]
+	case "logout":
+		fmt.Fprintln(stdout, "Logging out...\nOK")
+		config.AccessToken = ""
+		config.RefreshToken = ""
+		config.OrganizationFields.Name = ""
+		config.SpaceFields.Name = ""
+	case "oauth-token":
+		if !loggedIn {
+			fmt.Fprintln(stdout, "FAILED\nNot logged in. Use 'cf login' to log in.")
+			return 1
+		}
+		fmt.Fprintln(stdout, config.AccessToken)
+		return 0
+	case "target":
+		if !loggedIn {
+			fmt.Fprintln(stdout, "FAILED\nNot logged in. Use 'cf login' to log in.")
+			return 1
+		}
+		if org, ok := flags["o"]; ok {
+			config.OrganizationFields.Name = org
+			config.SpaceFields.Name = ""
+		}
+		if space, ok := flags["s"]; ok {
+			config.SpaceFields.Name = space
+		}
+		fmt.Fprintf(stdout, "api endpoint:   %s\norg:            %s\nspace:          %s\n", config.Target, config.OrganizationFields.Name, config.SpaceFields.Name)
+	default:
+		fmt.Fprintln(stdout, "fake cf "+strings.Join(args, " "))
+		return 0
+	}
+
+	if err := WriteConfig(cfHome, config); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func authenticate(config *cfconfig.Config, username, password string, stdout io.Writer) bool {
+	if config.Target == "" {
+		fmt.Fprintln(stdout, "No API endpoint set. Use 'cf login' or 'cf api' to target an endpoint.")
+		return false
+	}
+
+	fmt.Fprintln(stdout, "Authenticating...")
+	expected := os.Getenv(PasswordVar)
+	if expected != "" && password != expected {
+		fmt.Fprintln(stdout, "Credentials were rejected, please try again.\nFAILED")
+		return false
+	}
+
+	config.AccessToken = "bearer " + fakecc.AccessToken(config.Target, username, time.Now().Add(10*time.Minute))
+	config.RefreshToken = fakecc.RefreshToken
+	fmt.Fprint(stdout, "OK\n\n")
+	return true
+}
+
+// parseFlags splits args into flags and positional arguments. Flags that
+// are followed by another flag, or nothing, have empty values.
+func parseFlags(args []string) (map[string]string, []string) {
+	flags := make(map[string]string)
+	var positional []string
+
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+		if !strings.HasPrefix(arg, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if index+1 < len(args) && !strings.HasPrefix(args[index+1], "-") && !isSwitch(name) {
+			flags[name] = args[index+1]
+			index++
+		} else {
+			flags[name] = ""
+		}
+	}
+	return flags, positional
+}
+
+func isSwitch(name string) bool {
+	switch name {
+	case "skip-ssl-validation", "sso", "client-credentials", "f", "force":
+		return true
+	}
+	return false
+}
+
+func prompt(in *bufio.Reader) string {
+	line, _ := in.ReadString('\n')
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,144 @@
+package fakecf_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/fakecf"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("fake cf", func() {
+	var tmpDir string
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "fakecf")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	Describe("Script", func() {
+		script := Script{Rules: []Rule{
+			{Args: []string{"apps"}, CfHome: "prod", Stdout: "prod apps", ExitCode: 1},
+			{Args: []string{"apps"}, Stdout: "other apps"},
+		}}
+
+		It("matches rules by argument prefix and CF_HOME", func() {
+			rule, ok := script.Match([]string{"apps", "--guids"}, "/home/groups/prod/api")
+			Ω(ok).Should(BeTrue())
+			Ω(rule.Stdout).Should(Equal("prod apps"))
+
+			rule, ok = script.Match([]string{"apps"}, "/home/groups/dev/api")
+			Ω(ok).Should(BeTrue())
+			Ω(rule.Stdout).Should(Equal("other apps"))
+
+			_, ok = script.Match([]string{"orgs"}, "/home/groups/dev/api")
+			Ω(ok).Should(BeFalse())
+		})
+
+		It("can be written and read back", func() {
+			path := filepath.Join(tmpDir, "script.json")
+			Ω(WriteScript(path, script)).Should(Succeed())
+			readBack, err := ReadScript(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(readBack).Should(Equal(script))
+		})
+
+		It("replays output and exit codes", func() {
+			stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+			rule := Rule{Stdout: "out", Stderr: "err", ExitCode: 3}
+			Ω(rule.Replay(stdout, stderr)).Should(Equal(3))
+			Ω(stdout.String()).Should(Equal("out"))
+			Ω(stderr.String()).Should(Equal("err"))
+		})
+	})
+
+	Describe("invocation log", func() {
+		It("records invocations with their CF_HOME", func() {
+			path := filepath.Join(tmpDir, "log")
+			Ω(Record(path, Invocation{Args: []string{"apps"}, CfHome: "/one"})).Should(Succeed())
+			Ω(Record(path, Invocation{Args: []string{"orgs"}, CfHome: "/two"})).Should(Succeed())
+
+			invocations, err := Invocations(path)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(invocations).Should(Equal([]Invocation{
+				{Args: []string{"apps"}, CfHome: "/one"},
+				{Args: []string{"orgs"}, CfHome: "/two"},
+			}))
+		})
+
+		It("is empty when nothing has been invoked", func() {
+			invocations, err := Invocations(filepath.Join(tmpDir, "missing"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(invocations).Should(BeEmpty())
+		})
+	})
+
+	Describe("Builtin", func() {
+		run := func(args ...string) (int, string) {
+			stdout := new(bytes.Buffer)
+			exitCode := Builtin(args, tmpDir, strings.NewReader(""), stdout, stdout)
+			return exitCode, stdout.String()
+		}
+
+		It("reports not being logged in until authenticated", func() {
+			exitCode, output := run("api", "https://api.example.com")
+			Ω(exitCode).Should(Equal(0))
+			Ω(output).Should(ContainSubstring("Setting api endpoint to https://api.example.com...\nOK"))
+			Ω(output).Should(ContainSubstring("Not logged in"))
+
+			exitCode, output = run("auth", "admin", "secret")
+			Ω(exitCode).Should(Equal(0))
+			Ω(output).Should(ContainSubstring("Authenticating...\nOK"))
+
+			_, output = run("api", "https://api.example.com")
+			Ω(output).ShouldNot(ContainSubstring("Not logged in"))
+
+			config, err := ReadConfig(tmpDir)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(config.Target).Should(Equal("https://api.example.com"))
+			Ω(config.AccessToken).Should(HavePrefix("bearer "))
+		})
+
+		It("rejects the wrong password when one is expected", func() {
+			os.Setenv(PasswordVar, "right")
+			defer os.Unsetenv(PasswordVar)
+
+			run("api", "https://api.example.com")
+			exitCode, output := run("auth", "admin", "wrong")
+			Ω(exitCode).Should(Equal(1))
+			Ω(output).Should(ContainSubstring("FAILED"))
+		})
+
+		It("prompts for credentials when logging in", func() {
+			os.Setenv(PasswordVar, "secret")
+			defer os.Unsetenv(PasswordVar)
+
+			stdout := new(bytes.Buffer)
+			exitCode := Builtin([]string{"login", "-a", "https://api.example.com"}, tmpDir, strings.NewReader("admin\nsecret\n"), stdout, stdout)
+			Ω(exitCode).Should(Equal(0))
+			Ω(stdout.String()).Should(ContainSubstring("Email> "))
+			Ω(stdout.String()).Should(ContainSubstring("Authenticating...\nOK"))
+		})
+
+		It("targets orgs and spaces only when logged in", func() {
+			exitCode, _ := run("target", "-o", "org")
+			Ω(exitCode).Should(Equal(1))
+
+			run("api", "https://api.example.com")
+			run("auth", "admin", "secret")
+			exitCode, output := run("target", "-o", "org", "-s", "space")
+			Ω(exitCode).Should(Equal(0))
+			Ω(output).Should(ContainSubstring("org:            org"))
+			Ω(output).Should(ContainSubstring("space:          space"))
+		})
+	})
+})
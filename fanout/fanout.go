@@ -0,0 +1,151 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/target"
+)
+
+type ExitPolicy string
+
+const (
+	AnyFailed   ExitPolicy = "any-failed"
+	AllFailed   ExitPolicy = "all-failed"
+	FirstFailed ExitPolicy = "first-failed"
+)
+
+// Func runs a command against a single target, returning its exit code.
+type Func func(ctx context.Context, t target.Target) (int, error)
+
+type Result struct {
+	Name     string
+	Group    string
+	ExitCode int
+	Started  time.Time
+	Duration time.Duration
+	Skipped  bool
+	Err      error
+	// Finished is the order in which the target finished, counting from 1.
+	Finished int
+}
+
+func (r Result) Failed() bool {
+	return !r.Skipped && (r.ExitCode != 0 || r.Err != nil)
+}
+
+// Cancelled reports whether the target was stopped because another failed.
+// Targets that fail of their own accord once others have failed are not.
+func (r Result) Cancelled() bool {
+	return errors.Is(r.Err, context.Canceled)
+}
+
+// Run calls fn for every target, with no more than parallel calls in flight
+// at once. Unless force is set, the first failure stops any more targets from
+// being started and cancels the context of those still running. If done is
+// not nil, it is called with each result as its target finishes. Results are
+// returned in the same order as targets.
+func Run(targets []target.Target, parallel int, force bool, fn Func, done func(int, Result)) []Result {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make([]Result, len(targets))
+	for index, aTarget := range targets {
+		results[index] = Result{Name: aTarget.Name, Group: aTarget.Group, Skipped: true}
+	}
+
+	var lock sync.Mutex
+	var finished int
+
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+
+	for index, aTarget := range targets {
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(index int, aTarget target.Target) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			start := time.Now()
+			code, err := fn(ctx, aTarget)
+			result := Result{Name: aTarget.Name, Group: aTarget.Group, ExitCode: code, Started: start, Duration: time.Since(start), Err: err}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			finished++
+			result.Finished = finished
+			if result.Failed() && !force {
+				cancel()
+			}
+			results[index] = result
+			if done != nil {
+				done(index, result)
+			}
+		}(index, aTarget)
+	}
+
+	wg.Wait()
+	return results
+}
+
+func ParseExitPolicy(policy string) (ExitPolicy, error) {
+	switch ExitPolicy(policy) {
+	case AnyFailed, AllFailed, FirstFailed:
+		return ExitPolicy(policy), nil
+	}
+	return "", errors.New("exit policy must be one of any-failed, all-failed or first-failed")
+}
+
+// ExitCode decides the overall exit code of a run. any-failed exits 1 if
+// any target failed; all-failed exits 1 only if every target that ran
+// failed; first-failed exits with the code of the first target to fail, in
+// the order they finished, or failing that, the order they were given in.
+func (p ExitPolicy) ExitCode(results []Result) int {
+	var ran, failures int
+	var first *Result
+
+	for index, result := range results {
+		if result.Skipped {
+			continue
+		}
+		ran++
+
+		if result.Failed() {
+			failures++
+			if !result.Cancelled() && (first == nil || result.Finished < first.Finished) {
+				first = &results[index]
+			}
+		}
+	}
+
+	switch p {
+	case AnyFailed:
+		if failures > 0 {
+			return 1
+		}
+	case AllFailed:
+		if ran > 0 && failures == ran {
+			return 1
+		}
+	case FirstFailed:
+		if first != nil {
+			if first.ExitCode > 0 {
+				return first.ExitCode
+			}
+			return 1
+		}
+	}
+	return 0
+}
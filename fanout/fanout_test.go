@@ -0,0 +1,225 @@
+package fanout_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/fanout"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/target"
+)
+
+var _ = Describe("Run", func() {
+	var targets []target.Target
+
+	BeforeEach(func() {
+		targets = []target.Target{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	})
+
+	It("runs against every target", func() {
+		var lock sync.Mutex
+		var ran []string
+		results := Run(targets, 2, false, func(ctx context.Context, t target.Target) (int, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			ran = append(ran, t.Name)
+			return 0, nil
+		}, nil)
+		Ω(ran).Should(ConsistOf("a", "b", "c", "d"))
+		Ω(results).Should(HaveLen(4))
+		for _, result := range results {
+			Ω(result.Failed()).Should(BeFalse())
+		}
+	})
+
+	It("never runs more than the given number of targets at once", func() {
+		var lock sync.Mutex
+		var running, maxRunning int
+		Run(targets, 2, false, func(ctx context.Context, t target.Target) (int, error) {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return 0, nil
+		}, nil)
+		Ω(maxRunning).Should(Equal(2))
+	})
+
+	It("notifies as each target finishes", func() {
+		var finished []string
+		Run(targets, 1, false, func(ctx context.Context, t target.Target) (int, error) {
+			return 0, nil
+		}, func(index int, result Result) {
+			Ω(result.Name).Should(Equal(targets[index].Name))
+			finished = append(finished, result.Name)
+		})
+		Ω(finished).Should(Equal([]string{"a", "b", "c", "d"}))
+	})
+
+	It("runs targets in order when parallelism is 1", func() {
+		var ran []string
+		Run(targets, 1, false, func(ctx context.Context, t target.Target) (int, error) {
+			ran = append(ran, t.Name)
+			return 0, nil
+		}, nil)
+		Ω(ran).Should(Equal([]string{"a", "b", "c", "d"}))
+	})
+
+	It("records the name, group, exit code and duration of each target", func() {
+		targets[0].Group = "prod"
+		results := Run(targets[:1], 1, false, func(ctx context.Context, t target.Target) (int, error) {
+			time.Sleep(10 * time.Millisecond)
+			return 0, nil
+		}, nil)
+		Ω(results[0].Name).Should(Equal("a"))
+		Ω(results[0].Group).Should(Equal("prod"))
+		Ω(results[0].ExitCode).Should(Equal(0))
+		Ω(results[0].Duration).Should(BeNumerically(">=", 10*time.Millisecond))
+		Ω(results[0].Skipped).Should(BeFalse())
+	})
+
+	Context("when a target fails", func() {
+		It("does not start any more targets", func() {
+			var ran []string
+			results := Run(targets, 1, false, func(ctx context.Context, t target.Target) (int, error) {
+				ran = append(ran, t.Name)
+				if t.Name == "b" {
+					return 3, nil
+				}
+				return 0, nil
+			}, nil)
+			Ω(ran).Should(Equal([]string{"a", "b"}))
+			Ω(results[1].ExitCode).Should(Equal(3))
+			Ω(results[1].Failed()).Should(BeTrue())
+			Ω(results[2].Skipped).Should(BeTrue())
+			Ω(results[3].Skipped).Should(BeTrue())
+		})
+
+		It("cancels targets that are still running", func() {
+			results := Run(targets[:2], 2, false, func(ctx context.Context, t target.Target) (int, error) {
+				if t.Name == "a" {
+					return 1, nil
+				}
+
+				select {
+				case <-ctx.Done():
+					return 137, ctx.Err()
+				case <-time.After(5 * time.Second):
+					return 0, nil
+				}
+			}, nil)
+			Ω(results[0].ExitCode).Should(Equal(1))
+			Ω(results[0].Cancelled()).Should(BeFalse())
+			Ω(results[1].Cancelled()).Should(BeTrue())
+		})
+
+		It("keeps the errors of targets that fail of their own accord once others have", func() {
+			results := Run(targets[:2], 2, false, func(ctx context.Context, t target.Target) (int, error) {
+				if t.Name == "a" {
+					return 1, nil
+				}
+
+				<-ctx.Done()
+				return -1, errors.New("cf not found")
+			}, nil)
+			Ω(results[1].Err).Should(MatchError("cf not found"))
+			Ω(results[1].Cancelled()).Should(BeFalse())
+		})
+
+		It("records the order in which targets finish", func() {
+			results := Run(targets[:2], 2, true, func(ctx context.Context, t target.Target) (int, error) {
+				if t.Name == "a" {
+					time.Sleep(100 * time.Millisecond)
+				}
+				return 0, nil
+			}, nil)
+			Ω(results[0].Finished).Should(Equal(2))
+			Ω(results[1].Finished).Should(Equal(1))
+		})
+
+		It("records errors", func() {
+			results := Run(targets, 1, false, func(ctx context.Context, t target.Target) (int, error) {
+				return -1, errors.New("cf not found")
+			}, nil)
+			Ω(results[0].Err).Should(MatchError("cf not found"))
+		})
+
+		Context("and force is set", func() {
+			It("carries on regardless", func() {
+				var ran []string
+				results := Run(targets, 1, true, func(ctx context.Context, t target.Target) (int, error) {
+					ran = append(ran, t.Name)
+					if t.Name == "b" {
+						return 3, nil
+					}
+					return 0, nil
+				}, nil)
+				Ω(ran).Should(Equal([]string{"a", "b", "c", "d"}))
+				Ω(results[1].ExitCode).Should(Equal(3))
+				Ω(results[3].Failed()).Should(BeFalse())
+			})
+		})
+	})
+})
+
+var _ = Describe("ExitPolicy", func() {
+	ok := Result{Name: "ok"}
+	failed := Result{Name: "failed", ExitCode: 3}
+	cancelled := Result{Name: "cancelled", ExitCode: 137, Err: context.Canceled}
+	skipped := Result{Name: "skipped", Skipped: true}
+
+	It("parses known policies", func() {
+		policy, err := ParseExitPolicy("all-failed")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(policy).Should(Equal(AllFailed))
+
+		_, err = ParseExitPolicy("some-failed")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	Describe("any-failed", func() {
+		It("fails if any target failed", func() {
+			Ω(AnyFailed.ExitCode([]Result{ok, failed})).Should(Equal(1))
+			Ω(AnyFailed.ExitCode([]Result{ok, ok, skipped})).Should(Equal(0))
+		})
+	})
+
+	Describe("all-failed", func() {
+		It("fails only if every target that ran failed", func() {
+			Ω(AllFailed.ExitCode([]Result{ok, failed})).Should(Equal(0))
+			Ω(AllFailed.ExitCode([]Result{failed, cancelled, skipped})).Should(Equal(1))
+		})
+	})
+
+	Describe("first-failed", func() {
+		It("exits with the code of the first target to fail", func() {
+			Ω(FirstFailed.ExitCode([]Result{ok, cancelled, failed, skipped})).Should(Equal(3))
+			Ω(FirstFailed.ExitCode([]Result{ok, ok})).Should(Equal(0))
+		})
+
+		It("goes by the order in which targets finished", func() {
+			early := Result{Name: "early", ExitCode: 4, Finished: 1}
+			late := Result{Name: "late", ExitCode: 3, Finished: 2}
+			Ω(FirstFailed.ExitCode([]Result{late, early})).Should(Equal(4))
+		})
+
+		It("does not count targets that were cancelled, however their error is wrapped", func() {
+			wrapped := Result{Name: "wrapped", ExitCode: 137, Err: fmt.Errorf("stopped: %w", context.Canceled), Finished: 1}
+			failed := Result{Name: "failed", ExitCode: 3, Finished: 2}
+			Ω(FirstFailed.ExitCode([]Result{wrapped, failed})).Should(Equal(3))
+		})
+	})
+})
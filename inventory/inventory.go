@@ -0,0 +1,242 @@
+// Package inventory reconciles the targets kept in CF_PLEX_HOME against a
+// declarative list of groups and targets, written in YAML or JSON.
+package inventory
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/EngineerBetter/cf-plex/selector"
+	"github.com/EngineerBetter/cf-plex/target"
+	"gopkg.in/yaml.v2"
+)
+
+type Inventory struct {
+	Groups map[string][]Entry `yaml:"groups"`
+}
+
+type Entry struct {
+	Api               string            `yaml:"api"`
+	Name              string            `yaml:"name"`
+	Labels            map[string]string `yaml:"labels"`
+	SkipSslValidation bool              `yaml:"skip_ssl_validation"`
+	Credentials       string            `yaml:"credentials"`
+	CredentialHelper  string            `yaml:"credential_helper"`
+	Origin            string            `yaml:"origin"`
+	CfBinary          string            `yaml:"cf_binary"`
+	CfVersion         string            `yaml:"cf_version"`
+	Org               string            `yaml:"org"`
+	Space             string            `yaml:"space"`
+	OrgMap            map[string]string `yaml:"org_map"`
+	SpaceMap          map[string]string `yaml:"space_map"`
+}
+
+type Action string
+
+const (
+	Create Action = "create"
+	Update Action = "update"
+	Remove Action = "remove"
+)
+
+// Change is a single step needed to bring CF_PLEX_HOME into line with an
+// inventory.
+type Change struct {
+	Action   Action
+	Group    string
+	Name     string
+	Api      string
+	Path     string
+	Metadata target.Metadata
+}
+
+// Load reads an inventory file. JSON is read as YAML, of which it is a
+// subset, so either can be used.
+func Load(path string) (Inventory, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Inventory{}, err
+	}
+	return Parse(bytes)
+}
+
+func Parse(bytes []byte) (Inventory, error) {
+	var inventory Inventory
+	if err := yaml.UnmarshalStrict(bytes, &inventory); err != nil {
+		return Inventory{}, errors.New("inventory is invalid: " + err.Error())
+	}
+	return inventory, inventory.Validate()
+}
+
+func (i Inventory) Validate() error {
+	for _, group := range i.groupNames() {
+		if group == "batch" {
+			return errors.New("group name batch is reserved")
+		}
+		if err := target.ValidateName(group); err != nil {
+			return errors.New("group " + err.Error())
+		}
+
+		dirs := make(map[string]bool)
+		for _, entry := range i.Groups[group] {
+			if !strings.HasPrefix(entry.Api, "https://") && !strings.HasPrefix(entry.Api, "http://") {
+				return errors.New("api '" + entry.Api + "' in group " + group + " is not an http or https URL")
+			}
+			if entry.Name != "" {
+				if err := target.ValidateName(entry.Name); err != nil {
+					return err
+				}
+			}
+			for key, value := range entry.Labels {
+				if err := selector.ValidateKey(key); err != nil {
+					return err
+				}
+				if err := selector.ValidateValue(value); err != nil {
+					return err
+				}
+			}
+
+			if entry.Space != "" && entry.Org == "" {
+				return errors.New("space for " + entry.displayName() + " in group " + group + " needs an org")
+			}
+
+			dir := entry.dir()
+			if dirs[dir] {
+				return errors.New("group " + group + " lists " + entry.displayName() + " more than once")
+			}
+			dirs[dir] = true
+		}
+	}
+	return nil
+}
+
+// Plan works out the changes needed to make the targets in plexHome match
+// the inventory. Targets created in batch mode are left alone.
+func Plan(plexHome string, inventory Inventory) ([]Change, error) {
+	groups, err := target.List(plexHome)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	existing := make(map[string]target.Target)
+	for _, group := range groups {
+		for _, aTarget := range group.Apis {
+			existing[aTarget.Path] = aTarget
+		}
+	}
+
+	var changes []Change
+	wanted := make(map[string]bool)
+	for _, group := range inventory.groupNames() {
+		for _, entry := range inventory.Groups[group] {
+			path := filepath.Join(target.GroupDir(plexHome, group), entry.dir())
+			wanted[path] = true
+			change := Change{Group: group, Name: entry.displayName(), Api: entry.Api, Path: path, Metadata: entry.metadata()}
+
+			if _, found := existing[path]; !found {
+				change.Action = Create
+				changes = append(changes, change)
+				continue
+			}
+
+			current, err := target.ReadMetadata(path)
+			if err != nil {
+				return nil, err
+			}
+			change.Metadata.LastCfMajor = current.LastCfMajor
+			if !sameMetadata(current, change.Metadata) {
+				change.Action = Update
+				changes = append(changes, change)
+			}
+		}
+	}
+
+	for _, group := range groups {
+		for _, aTarget := range group.Apis {
+			if !wanted[aTarget.Path] {
+				changes = append(changes, Change{Action: Remove, Group: group.Name, Name: aTarget.Name, Api: aTarget.Api, Path: aTarget.Path})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// Apply makes the changes returned by Plan.
+func Apply(plexHome string, changes []Change) error {
+	for _, change := range changes {
+		switch change.Action {
+		case Create, Update:
+			if err := os.MkdirAll(change.Path, 0700); err != nil {
+				return err
+			}
+			if err := target.WriteMetadata(change.Path, change.Metadata); err != nil {
+				return err
+			}
+		case Remove:
+			aTarget := target.Target{Name: change.Name, Api: change.Api, Group: change.Group, Path: change.Path}
+			if err := target.Delete(plexHome, aTarget); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (i Inventory) groupNames() []string {
+	var names []string
+	for name := range i.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (e Entry) dir() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return target.Sanitise(e.Api)
+}
+
+func (e Entry) displayName() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Api
+}
+
+func (e Entry) metadata() target.Metadata {
+	return target.Metadata{
+		Api:               e.Api,
+		Name:              e.Name,
+		Labels:            e.Labels,
+		CfBinary:          e.CfBinary,
+		CfVersion:         e.CfVersion,
+		SkipSslValidation: e.SkipSslValidation,
+		Credentials:       e.Credentials,
+		CredentialHelper:  e.CredentialHelper,
+		Origin:            e.Origin,
+		Org:               e.Org,
+		Space:             e.Space,
+		OrgMap:            e.OrgMap,
+		SpaceMap:          e.SpaceMap,
+	}
+}
+
+func sameMetadata(a, b target.Metadata) bool {
+	if len(a.Labels) == 0 && len(b.Labels) == 0 {
+		a.Labels, b.Labels = nil, nil
+	}
+	if len(a.OrgMap) == 0 && len(b.OrgMap) == 0 {
+		a.OrgMap, b.OrgMap = nil, nil
+	}
+	if len(a.SpaceMap) == 0 && len(b.SpaceMap) == 0 {
+		a.SpaceMap, b.SpaceMap = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,172 @@
+package inventory_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/inventory"
+	"github.com/EngineerBetter/cf-plex/target"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Inventory", func() {
+	var plexHome string
+
+	BeforeEach(func() {
+		var err error
+		plexHome, err = ioutil.TempDir("", "plex-inventory")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(plexHome)
+	})
+
+	parse := func(yaml string) Inventory {
+		inventory, err := Parse([]byte(yaml))
+		Ω(err).ShouldNot(HaveOccurred())
+		return inventory
+	}
+
+	Describe("Parse", func() {
+		It("reads YAML", func() {
+			inventory := parse(`
+groups:
+  prod:
+  - api: https://api.prod.example.com
+    name: prod-admin
+    labels:
+      env: prod
+    skip_ssl_validation: true
+    credentials: env:PROD_ADMIN
+    credential_helper: vault-helper
+    cf_version: "7"
+    org: system
+    space: ops
+    org_map: {dev: development}
+    space_map: {web: web-apps}
+`)
+			Ω(inventory.Groups["prod"]).Should(Equal([]Entry{{
+				Api:               "https://api.prod.example.com",
+				Name:              "prod-admin",
+				Labels:            map[string]string{"env": "prod"},
+				SkipSslValidation: true,
+				Credentials:       "env:PROD_ADMIN",
+				CredentialHelper:  "vault-helper",
+				CfVersion:         "7",
+				Org:               "system",
+				Space:             "ops",
+				OrgMap:            map[string]string{"dev": "development"},
+				SpaceMap:          map[string]string{"web": "web-apps"},
+			}}))
+		})
+
+		It("reads JSON", func() {
+			inventory := parse(`{"groups": {"default": [{"api": "https://api.example.com"}]}}`)
+			Ω(inventory.Groups["default"]).Should(Equal([]Entry{{Api: "https://api.example.com"}}))
+		})
+
+		It("rejects unknown fields, so that typos are not ignored", func() {
+			_, err := Parse([]byte(`{"groups": {"default": [{"api": "https://api.example.com", "lables": {}}]}}`))
+			Ω(err).Should(MatchError(HavePrefix("inventory is invalid")))
+		})
+
+		It("rejects inventories that could not be laid out", func() {
+			for _, yaml := range []string{
+				`{"groups": {"batch": [{"api": "https://api.example.com"}]}}`,
+				`{"groups": {"../prod": [{"api": "https://api.example.com"}]}}`,
+				`{"groups": {"prod": [{"api": "api.example.com"}]}}`,
+				`{"groups": {"prod": [{"api": "https://api.example.com", "name": "a/b"}]}}`,
+				`{"groups": {"prod": [{"api": "https://api.example.com", "labels": {"env": "pr od"}}]}}`,
+				`{"groups": {"prod": [{"api": "https://api.example.com"}, {"api": "https://api.example.com"}]}}`,
+				`{"groups": {"prod": [{"api": "https://api.example.com", "space": "ops"}]}}`,
+			} {
+				_, err := Parse([]byte(yaml))
+				Ω(err).Should(HaveOccurred(), yaml)
+			}
+		})
+	})
+
+	Describe("Plan and Apply", func() {
+		It("creates targets that are missing", func() {
+			inventory := parse(`{"groups": {"default": [{"api": "https://api.one.com"}], "prod": [{"api": "https://api.two.com", "name": "two", "credentials": "env:TWO"}]}}`)
+
+			changes, err := Plan(plexHome, inventory)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(HaveLen(2))
+			Ω(changes[0].Action).Should(Equal(Create))
+			Ω(changes[0].Path).Should(Equal(filepath.Join(plexHome, "https___api.one.com")))
+			Ω(changes[1].Path).Should(Equal(filepath.Join(plexHome, "groups", "prod", "two")))
+
+			Ω(Apply(plexHome, changes)).Should(Succeed())
+			found, err := target.Find(plexHome, "prod", "two")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(found[0].Api).Should(Equal("https://api.two.com"))
+			Ω(found[0].Credentials).Should(Equal("env:TWO"))
+
+			changes, err = Plan(plexHome, inventory)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(BeEmpty())
+		})
+
+		It("updates targets whose settings differ, keeping what cf-plex has recorded", func() {
+			apiDir, err := target.AddToGroup(plexHome, "prod", "https://api.example.com")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(target.WriteMetadata(apiDir, target.Metadata{Api: "https://api.example.com", LastCfMajor: 6})).Should(Succeed())
+
+			changes, err := Plan(plexHome, parse(`{"groups": {"prod": [{"api": "https://api.example.com"}]}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(BeEmpty())
+
+			changes, err = Plan(plexHome, parse(`{"groups": {"prod": [{"api": "https://api.example.com", "labels": {"env": "prod"}}]}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(HaveLen(1))
+			Ω(changes[0].Action).Should(Equal(Update))
+
+			Ω(Apply(plexHome, changes)).Should(Succeed())
+			Ω(target.ReadMetadata(apiDir)).Should(Equal(target.Metadata{Api: "https://api.example.com", Labels: map[string]string{"env": "prod"}, LastCfMajor: 6}))
+		})
+
+		It("keeps the origin that users authenticate against", func() {
+			inventory := parse(`{"groups": {"prod": [{"api": "https://api.example.com", "origin": "ldap"}]}}`)
+
+			changes, err := Plan(plexHome, inventory)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(Apply(plexHome, changes)).Should(Succeed())
+
+			metadata, err := target.ReadMetadata(filepath.Join(plexHome, "groups", "prod", "https___api.example.com"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(metadata.Origin).Should(Equal("ldap"))
+
+			changes, err = Plan(plexHome, inventory)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(BeEmpty())
+		})
+
+		It("removes targets that are not listed, but leaves batch targets alone", func() {
+			apiDir, err := target.AddToGroup(plexHome, "old", "https://api.old.com")
+			Ω(err).ShouldNot(HaveOccurred())
+			batchDir, err := target.AddToBatch(plexHome, "https://api.batch.com")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			changes, err := Plan(plexHome, parse(`{"groups": {}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(HaveLen(1))
+			Ω(changes[0].Action).Should(Equal(Remove))
+			Ω(changes[0].Api).Should(Equal("https://api.old.com"))
+
+			Ω(Apply(plexHome, changes)).Should(Succeed())
+			Ω(apiDir).ShouldNot(BeADirectory())
+			Ω(filepath.Dir(apiDir)).ShouldNot(BeADirectory())
+			Ω(batchDir).Should(BeADirectory())
+		})
+
+		It("plans against a CF_PLEX_HOME that does not exist yet", func() {
+			changes, err := Plan(filepath.Join(plexHome, "missing"), parse(`{"groups": {"default": [{"api": "https://api.one.com"}]}}`))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(changes).Should(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,162 @@
+// Package lock takes advisory locks on directories, so that cf-plex
+// processes sharing a CF_PLEX_HOME do not write the same files at once.
+//
+// Locks are released when they are released explicitly, or when the process
+// that holds them exits, however it exits.
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/env"
+)
+
+// File is the name of the lock file kept in each locked directory.
+const File = ".cf-plex.lock"
+
+// TimeoutVar names the variable that sets how long to wait for a lock.
+const TimeoutVar = "CF_PLEX_LOCK_TIMEOUT"
+
+// DefaultTimeout is how long to wait for a lock when TimeoutVar is not set.
+const DefaultTimeout = time.Minute
+
+// pollInterval is how often a held lock is tried again.
+var pollInterval = 50 * time.Millisecond
+
+// Lock is held on a directory until it is released.
+type Lock struct {
+	file *os.File
+}
+
+// held keeps every lock that has not been released reachable, so that its
+// file is not closed by the garbage collector, which would release it.
+var held = make(map[*Lock]bool)
+var heldLock sync.Mutex
+
+// Timeout returns how long to wait for a lock, as set by TimeoutVar.
+func Timeout() (time.Duration, error) {
+	value := env.Get(TimeoutVar, "")
+	if value == "" {
+		return DefaultTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, errors.New(TimeoutVar + " must be a duration, such as 2m")
+	}
+	return timeout, nil
+}
+
+// Acquire locks dir, which must already exist, and waits up to timeout for
+// any other process that holds the lock to release it. The holder records
+// its process ID in the lock file, so that the error can name it. If dir is
+// removed whilst waiting, by the process that held the lock, Acquire fails
+// rather than bringing it back.
+func Acquire(dir string, timeout time.Duration) (*Lock, error) {
+	if _, err := os.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New(dir + " does not exist")
+		}
+		return nil, err
+	}
+	path := filepath.Join(dir, File)
+	file, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	waiting := false
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+
+		if !time.Now().Before(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("%s is locked by %s; gave up after %s. Set %s to wait longer", dir, holder(path), timeout, TimeoutVar)
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "Waiting for %s to unlock %s\n", holder(path), dir)
+			waiting = true
+		}
+		time.Sleep(pollInterval)
+	}
+
+	if err := stillThere(file, path); err != nil {
+		unlock(file)
+		file.Close()
+		return nil, err
+	}
+
+	lock := &Lock{file: file}
+	heldLock.Lock()
+	held[lock] = true
+	heldLock.Unlock()
+
+	if err := lock.record(); err != nil {
+		lock.Release()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Release unlocks the directory. The lock file is left in place, as
+// removing it would let another process lock a file that is about to go.
+func (l *Lock) Release() error {
+	heldLock.Lock()
+	delete(held, l)
+	heldLock.Unlock()
+
+	if err := unlock(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// stillThere makes sure that the lock file at path has not been removed, or
+// replaced, since file was opened.
+func stillThere(file *os.File, path string) error {
+	opened, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	current, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && !os.SameFile(opened, current)) {
+		return errors.New(filepath.Dir(path) + " was removed whilst waiting for it to be unlocked")
+	}
+	return err
+}
+
+// record writes the process ID and name of the holder into the lock file.
+// Arguments are left out, as they may hold passwords.
+func (l *Lock) record() error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.file.WriteAt([]byte(strconv.Itoa(os.Getpid())+" "+filepath.Base(os.Args[0])+"\n"), 0)
+	return err
+}
+
+// holder describes the process named in a lock file.
+func holder(path string) string {
+	bytes, err := ioutil.ReadFile(path)
+	fields := strings.Fields(string(bytes))
+	if err != nil || len(fields) < 2 {
+		return "another process"
+	}
+	return "process " + fields[0] + " (" + fields[1] + ")"
+}
@@ -0,0 +1,104 @@
+package lock_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/lock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var _ = Describe("lock", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "plex-lock")
+		Ω(err).ShouldNot(HaveOccurred())
+		dir = filepath.Join(dir, "target")
+		Ω(os.Mkdir(dir, 0700)).Should(Succeed())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(filepath.Dir(dir))).Should(Succeed())
+	})
+
+	It("records the holder", func() {
+		held, err := Acquire(dir, time.Second)
+		Ω(err).ShouldNot(HaveOccurred())
+		defer held.Release()
+
+		contents, err := ioutil.ReadFile(filepath.Join(dir, File))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(string(contents)).Should(HavePrefix(strconv.Itoa(os.Getpid()) + " "))
+	})
+
+	It("names the holder when it gives up waiting", func() {
+		held, err := Acquire(dir, time.Second)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		_, err = Acquire(dir, 100*time.Millisecond)
+		Ω(err).Should(MatchError(ContainSubstring(dir + " is locked by process " + strconv.Itoa(os.Getpid()))))
+		Ω(err).Should(MatchError(ContainSubstring("gave up after 100ms. Set CF_PLEX_LOCK_TIMEOUT to wait longer")))
+
+		Ω(held.Release()).Should(Succeed())
+		again, err := Acquire(dir, 0)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(again.Release()).Should(Succeed())
+	})
+
+	It("does not create directories that do not exist", func() {
+		missing := filepath.Join(dir, "missing")
+		_, err := Acquire(missing, time.Second)
+		Ω(err).Should(MatchError(missing + " does not exist"))
+		Ω(missing).ShouldNot(BeADirectory())
+	})
+
+	It("fails if the holder removes the directory", func() {
+		held, err := Acquire(dir, time.Second)
+		Ω(err).ShouldNot(HaveOccurred())
+		go func(dir string) {
+			time.Sleep(200 * time.Millisecond)
+			os.RemoveAll(dir)
+			held.Release()
+		}(dir)
+
+		_, err = Acquire(dir, 5*time.Second)
+		Ω(err).Should(MatchError(dir + " was removed whilst waiting for it to be unlocked"))
+		Ω(dir).ShouldNot(BeADirectory())
+	})
+
+	It("waits for the lock to be released", func() {
+		held, err := Acquire(dir, time.Second)
+		Ω(err).ShouldNot(HaveOccurred())
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			held.Release()
+		}()
+
+		again, err := Acquire(dir, 5*time.Second)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(again.Release()).Should(Succeed())
+	})
+
+	Describe("Timeout", func() {
+		AfterEach(func() {
+			os.Unsetenv(TimeoutVar)
+		})
+
+		It("reads CF_PLEX_LOCK_TIMEOUT", func() {
+			Ω(Timeout()).Should(Equal(DefaultTimeout))
+
+			os.Setenv(TimeoutVar, "5s")
+			Ω(Timeout()).Should(Equal(5 * time.Second))
+
+			os.Setenv(TimeoutVar, "soon")
+			_, err := Timeout()
+			Ω(err).Should(MatchError("CF_PLEX_LOCK_TIMEOUT must be a duration, such as 2m"))
+		})
+	})
+})
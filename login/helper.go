@@ -0,0 +1,81 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// HelperVar names the credential helper used in batch mode.
+const HelperVar = "CF_PLEX_CREDENTIAL_HELPER"
+
+// HelperTimeout is how long a credential helper may take to answer.
+var HelperTimeout = 30 * time.Second
+
+// HelperRequest is written as JSON to a credential helper's stdin.
+type HelperRequest struct {
+	Api      string `json:"api"`
+	Username string `json:"username,omitempty"`
+}
+
+type helperResponse struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// FromHelper asks a credential helper for the credentials of an API. The
+// helper is run as `<helper> get <apiUrl>`, without a shell, with a
+// HelperRequest on stdin, and must print the credentials as a JSON object of
+// username and password, or client_id and client_secret. If username is
+// given, the helper must answer for that user.
+func FromHelper(helper, api, username string) (Credentials, error) {
+	words := strings.Fields(helper)
+	if len(words) == 0 {
+		return Credentials{}, errors.New("credential helper is empty")
+	}
+
+	request, err := json.Marshal(HelperRequest{Api: api, Username: username})
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HelperTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, words[0], append(words[1:], "get", api)...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return Credentials{}, errors.New("credential helper '" + helper + "' failed: " + err.Error())
+	}
+
+	var response helperResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		// The message of err may quote the secret, so it is not passed on.
+		return Credentials{}, errors.New("credential helper '" + helper + "' did not print a JSON object")
+	}
+
+	credentials := Credentials(response)
+	switch {
+	case credentials.Username != "" && credentials.Password != "":
+		if username != "" && credentials.Username != username {
+			return Credentials{}, errors.New("credential helper '" + helper + "' answered for " + credentials.Username + " rather than " + username)
+		}
+		return known(Credentials{Username: credentials.Username, Password: credentials.Password}), nil
+	case credentials.ClientID != "" && credentials.ClientSecret != "":
+		return known(Credentials{ClientID: credentials.ClientID, ClientSecret: credentials.ClientSecret}), nil
+	}
+	return Credentials{}, errors.New("credential helper '" + helper + "' printed neither a username and password nor client credentials")
+}
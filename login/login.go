@@ -0,0 +1,178 @@
+// Package login works out whether targets need to log in again, and how cf
+// should be asked to do it.
+package login
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/credstore"
+	"github.com/EngineerBetter/cf-plex/redact"
+	"github.com/EngineerBetter/cf-plex/target"
+)
+
+// Credentials are used to log in either as a user, or as a UAA client.
+type Credentials struct {
+	Username     string
+	Password     string
+	ClientID     string
+	ClientSecret string
+}
+
+// Empty reports whether there is nothing to log in with, so cf must prompt.
+func (c Credentials) Empty() bool {
+	return c.Username == "" && c.ClientID == ""
+}
+
+// Needed reports whether a target has no valid session with its API.
+func Needed(aTarget target.Target, now time.Time) (bool, error) {
+	config, err := cfconfig.Read(aTarget.Path)
+	if err != nil {
+		return false, err
+	}
+	return !cfconfig.SameApi(config.Target, aTarget.Api) || !config.Valid(now), nil
+}
+
+// Resolver looks up the credentials that references refer to, opening the
+// credential store in PlexHome the first time it is needed. It can be used
+// by several goroutines at once.
+type Resolver struct {
+	PlexHome string
+	lock     sync.Mutex
+	store    *credstore.Store
+}
+
+// Resolve looks up the credentials that a reference refers to, for a
+// target's API. A reference of env:PREFIX uses PREFIX_USERNAME and
+// PREFIX_PASSWORD, or failing those PREFIX_CLIENT_ID and
+// PREFIX_CLIENT_SECRET. A reference of store:USERNAME uses the password
+// saved for that user of the API in the credential store.
+func (r *Resolver) Resolve(ref, api string) (Credentials, error) {
+	scheme := strings.SplitN(ref, ":", 2)
+	if len(scheme) != 2 || scheme[1] == "" {
+		return Credentials{}, errors.New("credentials reference '" + ref + "' is invalid: use env:<prefix> or store:<username>")
+	}
+
+	switch scheme[0] {
+	case "env":
+		return fromEnv(scheme[1])
+	case "store":
+		return r.FromStore(api, scheme[1])
+	}
+	return Credentials{}, errors.New("credentials reference '" + ref + "' is invalid: use env:<prefix> or store:<username>")
+}
+
+// For returns the credentials that a target should log in with: those its
+// credentials reference refers to or, failing that, those printed by its
+// credential helper. They are empty if it has neither, so that cf prompts.
+func (r *Resolver) For(aTarget target.Target) (Credentials, error) {
+	if aTarget.Credentials != "" {
+		return r.Resolve(aTarget.Credentials, aTarget.Api)
+	}
+	if aTarget.CredentialHelper != "" {
+		return FromHelper(aTarget.CredentialHelper, aTarget.Api, "")
+	}
+	return Credentials{}, nil
+}
+
+// FromStore looks up the password saved for a user of an API.
+func (r *Resolver) FromStore(api, username string) (Credentials, error) {
+	store, err := r.Store()
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	entry, found := store.Get(api, username)
+	if !found {
+		return Credentials{}, errors.New("no password is saved for " + username + " at " + api)
+	}
+	if entry.Client {
+		return known(Credentials{ClientID: entry.Username, ClientSecret: entry.Password}), nil
+	}
+	return known(Credentials{Username: entry.Username, Password: entry.Password}), nil
+}
+
+// Store opens the credential store, using the passphrase or key file given
+// in the environment.
+func (r *Resolver) Store() (*credstore.Store, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.store != nil {
+		return r.store, nil
+	}
+
+	secret, err := credstore.Secret()
+	if err != nil {
+		return nil, err
+	}
+	r.store, err = credstore.Open(r.PlexHome, secret)
+	return r.store, err
+}
+
+func fromEnv(prefix string) (Credentials, error) {
+	credentials := Credentials{Username: os.Getenv(prefix + "_USERNAME"), Password: os.Getenv(prefix + "_PASSWORD")}
+	if credentials.Username != "" && credentials.Password != "" {
+		return known(credentials), nil
+	}
+
+	credentials = Credentials{ClientID: os.Getenv(prefix + "_CLIENT_ID"), ClientSecret: os.Getenv(prefix + "_CLIENT_SECRET")}
+	if credentials.ClientID != "" && credentials.ClientSecret != "" {
+		return known(credentials), nil
+	}
+
+	return Credentials{}, errors.New(prefix + "_USERNAME and " + prefix + "_PASSWORD, or " + prefix + "_CLIENT_ID and " + prefix + "_CLIENT_SECRET, must be set")
+}
+
+// known makes the secrets in credentials known, so that they are masked in
+// everything printed from then on.
+func known(credentials Credentials) Credentials {
+	redact.Add(credentials.Password, credentials.ClientSecret)
+	return credentials
+}
+
+// Commands returns the cf commands that log a target in. With no
+// credentials, cf login prompts for them, or for a one-time passcode if sso
+// is set. Users log in with the target's origin, if it has one.
+func Commands(aTarget target.Target, credentials Credentials, sso bool) [][]string {
+	var ssl, origin []string
+	if aTarget.SkipSslValidation {
+		ssl = []string{"--skip-ssl-validation"}
+	}
+	if aTarget.Origin != "" {
+		origin = []string{"--origin", aTarget.Origin}
+	}
+
+	switch {
+	case sso:
+		return [][]string{append([]string{"", "login", "-a", aTarget.Api, "--sso"}, ssl...)}
+	case credentials.ClientID != "":
+		return [][]string{
+			append([]string{"", "api", aTarget.Api}, ssl...),
+			{"", "auth", credentials.ClientID, credentials.ClientSecret, "--client-credentials"},
+		}
+	case credentials.Username != "":
+		return [][]string{
+			append([]string{"", "api", aTarget.Api}, ssl...),
+			append([]string{"", "auth", credentials.Username, credentials.Password}, origin...),
+		}
+	}
+	return [][]string{append(append([]string{"", "login", "-a", aTarget.Api}, ssl...), origin...)}
+}
+
+// SkipApi drops the cf api command from commands if config already has the
+// target's API set, with the same SSL validation, as cf auth then needs
+// nothing more.
+func SkipApi(commands [][]string, config cfconfig.Config, aTarget target.Target) [][]string {
+	if len(commands) < 2 || commands[0][1] != "api" {
+		return commands
+	}
+	if !cfconfig.SameApi(config.Target, aTarget.Api) || config.SSLDisabled != aTarget.SkipSslValidation {
+		return commands
+	}
+	return commands[1:]
+}
@@ -0,0 +1,245 @@
+package login_test
+
+import (
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/credstore"
+	"github.com/EngineerBetter/cf-plex/fakecc"
+	"github.com/EngineerBetter/cf-plex/fakecf"
+	. "github.com/EngineerBetter/cf-plex/login"
+	"github.com/EngineerBetter/cf-plex/target"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("login", func() {
+	api := "https://api.example.com"
+
+	Describe("Needed", func() {
+		var aTarget target.Target
+		var now time.Time
+
+		BeforeEach(func() {
+			cfHome, err := ioutil.TempDir("", "plex-login")
+			Ω(err).ShouldNot(HaveOccurred())
+			aTarget = target.Target{Name: "example", Api: api, Path: cfHome}
+			now = time.Now()
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(aTarget.Path)
+		})
+
+		login := func(api string, expiry time.Time, refreshToken string) {
+			config := cfconfig.Config{Target: api, AccessToken: "bearer " + fakecc.AccessToken(api, "admin", expiry), RefreshToken: refreshToken}
+			Ω(fakecf.WriteConfig(aTarget.Path, config)).Should(Succeed())
+		}
+
+		It("is needed when cf has never been used", func() {
+			Ω(Needed(aTarget, now)).Should(BeTrue())
+		})
+
+		It("is not needed while the token is valid or can be refreshed", func() {
+			login(api, now.Add(time.Hour), "")
+			Ω(Needed(aTarget, now)).Should(BeFalse())
+
+			login(api, now.Add(-time.Hour), fakecc.RefreshToken)
+			Ω(Needed(aTarget, now)).Should(BeFalse())
+		})
+
+		It("is needed when the token has expired and cannot be refreshed", func() {
+			login(api, now.Add(-time.Hour), "")
+			Ω(Needed(aTarget, now)).Should(BeTrue())
+		})
+
+		It("is needed when cf is pointed at a different API", func() {
+			login("https://api.other.com", now.Add(time.Hour), "")
+			Ω(Needed(aTarget, now)).Should(BeTrue())
+		})
+	})
+
+	Describe("Resolver", func() {
+		var resolver *Resolver
+
+		BeforeEach(func() {
+			plexHome, err := ioutil.TempDir("", "plex-login")
+			Ω(err).ShouldNot(HaveOccurred())
+			resolver = &Resolver{PlexHome: plexHome}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(resolver.PlexHome)
+			for _, name := range []string{"USERNAME", "PASSWORD", "CLIENT_ID", "CLIENT_SECRET"} {
+				os.Unsetenv("PLEX_TEST_" + name)
+			}
+			os.Unsetenv(credstore.PassphraseVar)
+		})
+
+		It("reads a username and password from the environment", func() {
+			os.Setenv("PLEX_TEST_USERNAME", "admin")
+			os.Setenv("PLEX_TEST_PASSWORD", "secret")
+			os.Setenv("PLEX_TEST_CLIENT_ID", "ignored")
+			os.Setenv("PLEX_TEST_CLIENT_SECRET", "ignored")
+
+			credentials, err := resolver.Resolve("env:PLEX_TEST", api)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(credentials).Should(Equal(Credentials{Username: "admin", Password: "secret"}))
+		})
+
+		It("falls back to client credentials", func() {
+			os.Setenv("PLEX_TEST_CLIENT_ID", "pipeline")
+			os.Setenv("PLEX_TEST_CLIENT_SECRET", "secret")
+
+			credentials, err := resolver.Resolve("env:PLEX_TEST", api)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(credentials).Should(Equal(Credentials{ClientID: "pipeline", ClientSecret: "secret"}))
+		})
+
+		It("says which variables are missing", func() {
+			os.Setenv("PLEX_TEST_USERNAME", "admin")
+
+			_, err := resolver.Resolve("env:PLEX_TEST", api)
+			Ω(err).Should(MatchError("PLEX_TEST_USERNAME and PLEX_TEST_PASSWORD, or PLEX_TEST_CLIENT_ID and PLEX_TEST_CLIENT_SECRET, must be set"))
+		})
+
+		It("reads passwords saved for the API in the credential store", func() {
+			os.Setenv(credstore.PassphraseVar, "passphrase")
+			store, err := resolver.Store()
+			Ω(err).ShouldNot(HaveOccurred())
+			store.Put(credstore.Entry{Api: api, Username: "admin", Password: "secret"})
+			Ω(store.Save()).Should(Succeed())
+
+			credentials, err := (&Resolver{PlexHome: resolver.PlexHome}).Resolve("store:admin", api)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(credentials).Should(Equal(Credentials{Username: "admin", Password: "secret"}))
+
+			_, err = resolver.Resolve("store:admin", "https://api.other.com")
+			Ω(err).Should(MatchError("no password is saved for admin at https://api.other.com"))
+
+			store.Put(credstore.Entry{Api: api, Username: "pipeline", Password: "secret", Client: true})
+			credentials, err = resolver.Resolve("store:pipeline", api)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(credentials).Should(Equal(Credentials{ClientID: "pipeline", ClientSecret: "secret"}))
+		})
+
+		It("needs a passphrase or key file to use the credential store", func() {
+			_, err := resolver.Resolve("store:admin", api)
+			Ω(err).Should(MatchError(ContainSubstring("set CF_PLEX_PASSPHRASE or CF_PLEX_KEY_FILE")))
+		})
+
+		It("rejects references it does not understand", func() {
+			_, err := resolver.Resolve("vault:secret/cf", api)
+			Ω(err).Should(MatchError("credentials reference 'vault:secret/cf' is invalid: use env:<prefix> or store:<username>"))
+		})
+	})
+
+	Describe("FromHelper", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "plex-helper")
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		helper := func(script string) string {
+			path := filepath.Join(dir, "helper")
+			Ω(ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0700)).Should(Succeed())
+			return path
+		}
+
+		It("passes the API to the helper, and reads back credentials", func() {
+			path := helper(`cat > "$(dirname "$0")/request"; echo "$@" > "$(dirname "$0")/args"; echo '{"username": "admin", "password": "secret"}'`)
+
+			credentials, err := FromHelper(path+" --vault", api, "admin")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(credentials).Should(Equal(Credentials{Username: "admin", Password: "secret"}))
+
+			args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(args)).Should(Equal("--vault get " + api + "\n"))
+			request, err := ioutil.ReadFile(filepath.Join(dir, "request"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(string(request)).Should(MatchJSON(`{"api": "` + api + `", "username": "admin"}`))
+		})
+
+		It("reads back client credentials", func() {
+			path := helper(`echo '{"client_id": "pipeline", "client_secret": "secret"}'`)
+
+			credentials, err := FromHelper(path, api, "")
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(credentials).Should(Equal(Credentials{ClientID: "pipeline", ClientSecret: "secret"}))
+		})
+
+		It("reports helpers that fail or answer badly", func() {
+			_, err := FromHelper(helper("exit 3"), api, "")
+			Ω(err).Should(MatchError(ContainSubstring("failed: exit status 3")))
+
+			_, err = FromHelper(helper("echo password"), api, "")
+			Ω(err).Should(MatchError(ContainSubstring("did not print a JSON object")))
+
+			_, err = FromHelper(helper(`echo '{"username": "admin"}'`), api, "")
+			Ω(err).Should(MatchError(ContainSubstring("printed neither a username and password nor client credentials")))
+
+			_, err = FromHelper(helper(`echo '{"username": "admin", "password": "secret"}'`), api, "someone-else")
+			Ω(err).Should(MatchError(ContainSubstring("answered for admin rather than someone-else")))
+		})
+
+		It("gives up on helpers that take too long", func() {
+			original := HelperTimeout
+			HelperTimeout = 100 * time.Millisecond
+			defer func() { HelperTimeout = original }()
+
+			_, err := FromHelper(helper("exec sleep 10"), api, "")
+			Ω(err).Should(MatchError(ContainSubstring("context deadline exceeded")))
+		})
+	})
+
+	Describe("Commands", func() {
+		aTarget := target.Target{Api: api, SkipSslValidation: true}
+
+		It("authenticates users and clients non-interactively", func() {
+			Ω(Commands(aTarget, Credentials{Username: "admin", Password: "secret"}, false)).Should(Equal([][]string{
+				{"", "api", api, "--skip-ssl-validation"},
+				{"", "auth", "admin", "secret"},
+			}))
+			Ω(Commands(aTarget, Credentials{ClientID: "pipeline", ClientSecret: "secret"}, false)).Should(Equal([][]string{
+				{"", "api", api, "--skip-ssl-validation"},
+				{"", "auth", "pipeline", "secret", "--client-credentials"},
+			}))
+		})
+
+		It("logs users in with the target's origin", func() {
+			aTarget := target.Target{Api: api, Origin: "ldap"}
+			Ω(Commands(aTarget, Credentials{Username: "admin", Password: "secret"}, false)).Should(Equal([][]string{
+				{"", "api", api},
+				{"", "auth", "admin", "secret", "--origin", "ldap"},
+			}))
+			Ω(Commands(aTarget, Credentials{ClientID: "pipeline", ClientSecret: "secret"}, false)[1]).Should(Equal([]string{"", "auth", "pipeline", "secret", "--client-credentials"}))
+			Ω(Commands(aTarget, Credentials{}, false)).Should(Equal([][]string{{"", "login", "-a", api, "--origin", "ldap"}}))
+		})
+
+		It("lets cf prompt when there are no credentials", func() {
+			Ω(Commands(aTarget, Credentials{}, false)).Should(Equal([][]string{{"", "login", "-a", api, "--skip-ssl-validation"}}))
+			Ω(Commands(aTarget, Credentials{}, true)).Should(Equal([][]string{{"", "login", "-a", api, "--sso", "--skip-ssl-validation"}}))
+		})
+
+		It("skips setting the API when it is already set", func() {
+			commands := Commands(aTarget, Credentials{Username: "admin", Password: "secret"}, false)
+			Ω(SkipApi(commands, cfconfig.Config{Target: api + "/", SSLDisabled: true}, aTarget)).Should(Equal([][]string{{"", "auth", "admin", "secret"}}))
+			Ω(SkipApi(commands, cfconfig.Config{Target: api}, aTarget)).Should(HaveLen(2), "should set the API again to change SSL validation")
+			Ω(SkipApi(commands, cfconfig.Config{Target: "https://api.other.com", SSLDisabled: true}, aTarget)).Should(HaveLen(2))
+
+			prompting := Commands(aTarget, Credentials{}, false)
+			Ω(SkipApi(prompting, cfconfig.Config{Target: api, SSLDisabled: true}, aTarget)).Should(Equal(prompting))
+		})
+	})
+})
@@ -0,0 +1,166 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var colours = []int{36, 33, 35, 32, 34, 31}
+
+// LineWriter buffers writes until it has complete lines, and then writes
+// them to the underlying writer whilst holding a lock. LineWriters that share
+// a lock will never interleave their output mid-line, unless they have been
+// told to show partial lines.
+type LineWriter struct {
+	out    io.Writer
+	lock   sync.Locker
+	prefix []byte
+
+	// mutex guards what follows, which the timer that shows partial lines
+	// uses too.
+	mutex   sync.Mutex
+	buf     bytes.Buffer
+	idle    time.Duration
+	timer   *time.Timer
+	midLine bool
+}
+
+func NewLineWriter(out io.Writer, lock sync.Locker) *LineWriter {
+	return &LineWriter{out: out, lock: lock}
+}
+
+// NewPrefixWriter returns a LineWriter that starts every line with prefix.
+// If lock is nil, the LineWriter uses a lock of its own.
+func NewPrefixWriter(out io.Writer, lock sync.Locker, prefix string) *LineWriter {
+	if lock == nil {
+		lock = new(sync.Mutex)
+	}
+	return &LineWriter{out: out, lock: lock, prefix: []byte(prefix)}
+}
+
+// Prefix returns a prefix for the nth of several names, padded to the length
+// of the longest name. When colour is set, each n is given its own colour.
+func Prefix(name string, n int, names []string, colour bool) string {
+	width := 0
+	for _, aName := range names {
+		if len(aName) > width {
+			width = len(aName)
+		}
+	}
+
+	label := "[" + name + "]"
+	padding := strings.Repeat(" ", width-len(name)+1)
+	if colour {
+		label = fmt.Sprintf("\x1b[%dm%s\x1b[0m", colours[n%len(colours)], label)
+	}
+	return label + padding
+}
+
+// ColourEnabled works out whether to colour output written to file, honouring
+// the cf CLI's CF_COLOR variable as well as NO_COLOR.
+func ColourEnabled(file *os.File) bool {
+	switch os.Getenv("CF_COLOR") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ShowPartialLines makes w write a partial line once nothing more has been
+// written to it for idle, so that prompts that do not end in a newline are
+// seen before they are answered. The rest of the line follows it unprefixed.
+func (w *LineWriter) ShowPartialLines(idle time.Duration) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.idle = idle
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.buf.Write(p)
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	if end := bytes.LastIndexByte(w.buf.Bytes(), '\n'); end != -1 {
+		lines := w.buf.Next(end + 1)
+		if err := w.write(lines); err != nil {
+			return 0, err
+		}
+		w.midLine = false
+	}
+
+	if w.buf.Len() > 0 && w.idle > 0 {
+		w.timer = time.AfterFunc(w.idle, w.showPartialLine)
+	}
+	return len(p), nil
+}
+
+// Flush writes any partial line that has not yet been terminated.
+func (w *LineWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if w.buf.Len() == 0 && !w.midLine {
+		return nil
+	}
+
+	err := w.write(append(w.buf.Next(w.buf.Len()), '\n'))
+	w.midLine = false
+	return err
+}
+
+func (w *LineWriter) showPartialLine() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.buf.Len() == 0 {
+		return
+	}
+	if w.write(w.buf.Next(w.buf.Len())) == nil {
+		w.midLine = true
+	}
+}
+
+// write writes lines, prefixing each of them unless it continues a partial
+// line that has been written already.
+func (w *LineWriter) write(lines []byte) error {
+	if len(w.prefix) > 0 {
+		var prefixed bytes.Buffer
+		for index, line := range bytes.SplitAfter(lines, []byte("\n")) {
+			if len(line) > 0 {
+				if index > 0 || !w.midLine {
+					prefixed.Write(w.prefix)
+				}
+				prefixed.Write(line)
+			}
+		}
+		lines = prefixed.Bytes()
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	_, err := w.out.Write(lines)
+	return err
+}
@@ -0,0 +1,160 @@
+package output_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/output"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ = Describe("LineWriter", func() {
+	var out *bytes.Buffer
+	var lock *sync.Mutex
+
+	BeforeEach(func() {
+		out = new(bytes.Buffer)
+		lock = new(sync.Mutex)
+	})
+
+	It("holds back partial lines until they are terminated", func() {
+		writer := NewLineWriter(out, lock)
+		writer.Write([]byte("hello "))
+		Ω(out.String()).Should(BeEmpty())
+
+		writer.Write([]byte("world\nand "))
+		Ω(out.String()).Should(Equal("hello world\n"))
+	})
+
+	It("writes remaining partial lines when flushed", func() {
+		writer := NewLineWriter(out, lock)
+		writer.Write([]byte("no newline"))
+		Ω(writer.Flush()).Should(Succeed())
+		Ω(out.String()).Should(Equal("no newline\n"))
+	})
+
+	It("never interleaves concurrent writers mid-line", func() {
+		var wg sync.WaitGroup
+		for _, word := range []string{"aaaa", "bbbb", "cccc"} {
+			wg.Add(1)
+			go func(word string) {
+				defer wg.Done()
+				writer := NewLineWriter(out, lock)
+				for i := 0; i < 100; i++ {
+					for _, char := range word {
+						writer.Write([]byte(string(char)))
+					}
+					writer.Write([]byte("\n"))
+				}
+			}(word)
+		}
+		wg.Wait()
+
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			Ω(line).Should(MatchRegexp("^(aaaa|bbbb|cccc)$"))
+		}
+	})
+})
+
+var _ = Describe("PrefixWriter", func() {
+	It("prefixes every line", func() {
+		out := new(bytes.Buffer)
+		writer := NewPrefixWriter(out, nil, "[api] ")
+		writer.Write([]byte("one\ntwo\nthr"))
+		writer.Write([]byte("ee\n"))
+		Ω(out.String()).Should(Equal("[api] one\n[api] two\n[api] three\n"))
+	})
+
+	Describe("showing partial lines", func() {
+		var out *bytes.Buffer
+		var lock *sync.Mutex
+
+		BeforeEach(func() {
+			out = new(bytes.Buffer)
+			lock = new(sync.Mutex)
+		})
+
+		written := func() string {
+			lock.Lock()
+			defer lock.Unlock()
+			return out.String()
+		}
+
+		It("writes them once nothing more has been written for a while", func() {
+			writer := NewPrefixWriter(out, lock, "[api] ")
+			writer.ShowPartialLines(50 * time.Millisecond)
+			writer.Write([]byte("done\nEmail> "))
+			Ω(written()).Should(Equal("[api] done\n"))
+			Eventually(written).Should(Equal("[api] done\n[api] Email> "))
+
+			writer.Write([]byte("\nnext\n"))
+			Ω(written()).Should(Equal("[api] done\n[api] Email> \n[api] next\n"))
+			Ω(writer.Flush()).Should(Succeed())
+			Ω(written()).Should(Equal("[api] done\n[api] Email> \n[api] next\n"))
+		})
+
+		It("ends a partial line that has been shown when flushed", func() {
+			writer := NewPrefixWriter(out, lock, "[api] ")
+			writer.ShowPartialLines(time.Millisecond)
+			writer.Write([]byte("Password> "))
+			Eventually(written).Should(Equal("[api] Password> "))
+
+			Ω(writer.Flush()).Should(Succeed())
+			Ω(written()).Should(Equal("[api] Password> \n"))
+		})
+	})
+
+		It("prefixes partial lines when flushed", func() {
+		out := new(bytes.Buffer)
+		writer := NewPrefixWriter(out, nil, "[api] ")
+		writer.Write([]byte("Really delete? [yN]:"))
+		writer.Flush()
+		Ω(out.String()).Should(Equal("[api] Really delete? [yN]:\n"))
+	})
+})
+
+var _ = Describe("Prefix", func() {
+	names := []string{"short", "much-longer"}
+
+	It("pads names to the same width", func() {
+		Ω(Prefix("short", 0, names, false)).Should(Equal("[short]       "))
+		Ω(Prefix("much-longer", 1, names, false)).Should(Equal("[much-longer] "))
+	})
+
+	It("colours names differently when asked", func() {
+		Ω(Prefix("short", 0, names, true)).Should(HavePrefix("\x1b[36m[short]\x1b[0m"))
+		Ω(Prefix("much-longer", 1, names, true)).Should(HavePrefix("\x1b[33m[much-longer]\x1b[0m"))
+	})
+})
+
+var _ = Describe("ColourEnabled", func() {
+	var oldColor string
+
+	BeforeEach(func() {
+		oldColor = os.Getenv("CF_COLOR")
+	})
+
+	AfterEach(func() {
+		os.Setenv("CF_COLOR", oldColor)
+	})
+
+	It("honours CF_COLOR", func() {
+		os.Setenv("CF_COLOR", "true")
+		Ω(ColourEnabled(os.Stdout)).Should(BeTrue())
+		os.Setenv("CF_COLOR", "false")
+		Ω(ColourEnabled(os.Stdout)).Should(BeFalse())
+	})
+
+	It("does not colour files", func() {
+		os.Setenv("CF_COLOR", "")
+		file, err := ioutil.TempFile("", "plex-output")
+		Ω(err).ShouldNot(HaveOccurred())
+		defer os.Remove(file.Name())
+		Ω(ColourEnabled(file)).Should(BeFalse())
+	})
+})
@@ -1,20 +1,71 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/EngineerBetter/cf-plex/batch"
 	"github.com/EngineerBetter/cf-plex/cfcli"
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/credstore"
 	"github.com/EngineerBetter/cf-plex/env"
+	"github.com/EngineerBetter/cf-plex/fanout"
+	"github.com/EngineerBetter/cf-plex/inventory"
+	"github.com/EngineerBetter/cf-plex/lock"
+	"github.com/EngineerBetter/cf-plex/login"
+	"github.com/EngineerBetter/cf-plex/output"
+	"github.com/EngineerBetter/cf-plex/preflight"
+	"github.com/EngineerBetter/cf-plex/prune"
+	"github.com/EngineerBetter/cf-plex/redact"
+	"github.com/EngineerBetter/cf-plex/report"
+	"github.com/EngineerBetter/cf-plex/scope"
+	"github.com/EngineerBetter/cf-plex/selector"
+	"github.com/EngineerBetter/cf-plex/status"
 	"github.com/EngineerBetter/cf-plex/target"
 	"github.com/mitchellh/go-homedir"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 )
 
-var cfUsage = "cf-plex [-g <group>] <cf cli command> [--force]"
-var addUsage = "cf-plex add-api [-g <group>] <apiUrl> [<username> <password>]"
-var listUsage = "cf-plex list-apis"
-var removeUsage = "cf-plex remove-api [-g <group>] <apiUrl>"
+var cfUsage = "cf-plex [-g <group>] [-t <name>]... [--selector <selector>] [--org <org>] [--space <space>] [--parallel <n>] [--prefix] [--exit-policy <policy>] [--output <format>] [--skip-preflight] <cf cli command> [--force]"
+var addUsage = "cf-plex add-api [-g <group>] [--name <name>] [--label <key>=<value>]... [--skip-ssl-validation] [--credentials <reference> | --save-credentials] [--credential-helper <command>] [--origin <origin>] [--org <org> [--space <space>]] [--map-org <name>=<actual>]... [--map-space <name>=<actual>]... [--cf-binary <path> | --cf-version <major>] [--group-cf-binary <path> | --group-cf-version <major>] [--group-credential-helper <command>] <apiUrl> [<username> <password> | --client-credentials <client> <secret>]"
+var listUsage = "cf-plex list-apis [--selector <selector>] [--output <format>]"
+var removeUsage = "cf-plex remove-api [-g <group>] <apiUrl | name>"
+var renameUsage = "cf-plex rename-api [-g <group>] <apiUrl | name> <new name>"
+var labelUsage = "cf-plex label [-g <group>] <apiUrl | name> <key>=<value> | <key>-..."
+var migrateUsage = "cf-plex migrate"
+var syncUsage = "cf-plex sync [--dry-run] <inventory file>"
+var statusUsage = "cf-plex status [-g <group>] [-t <name>]... [--selector <selector>] [--timeout <duration>] [--output <format>]"
+var loginUsage = "cf-plex login [-g <group>] [-t <name>]... [--selector <selector>] [--sso] [--force]"
+var credentialsUsage = "cf-plex credentials list | rotate <apiUrl> <username> | delete <apiUrl> <username> | rekey"
+var pruneUsage = "cf-plex prune [--dry-run] [--older-than <duration>]"
+
+// progress is where output from setting up targets is written, which must be
+// kept out of stdout when it is carrying machine-readable output.
+var progress io.Writer = os.Stdout
+
+type runOptions struct {
+	names         []string
+	selector      selector.Selector
+	scope         scope.Override
+	parallel      int
+	prefix        bool
+	force         bool
+	policy        fanout.ExitPolicy
+	format        report.Format
+	skipPreflight bool
+	// locked is set once batch mode has locked every target for the run.
+	locked bool
+}
 
 func main() {
 	args := os.Args
@@ -30,65 +81,196 @@ func main() {
 		printUsageAndBail()
 	case "add-api":
 		bailIfCfEnvs()
+		mustLockHome(cfPlexHome)
 
-		if len(args) >= 3 {
-			if args[2] == "-g" {
-				if len(args) == 5 {
-					group := args[3]
-					api := args[4]
-					fullPath, err := target.AddToGroup(cfPlexHome, group, api)
-					bailIfB0rked(err)
-					mustRunCf(fullPath, []string{"", "login", "-a", api})
-					fmt.Println("Added " + api + " to group '" + group + "'")
-					os.Exit(0)
-				} else if len(args) == 7 {
-					group := args[3]
-					api := args[4]
-					username := args[5]
-					password := args[6]
-
-					fullPath, err := target.AddToGroup(cfPlexHome, group, api)
-					bailIfB0rked(err)
-					mustRunCf(fullPath, []string{"", "api", api})
-					mustRunCf(fullPath, []string{"", "auth", username, password})
-					fmt.Println("Added " + api + " to group '" + group + "'")
-					os.Exit(0)
-				}
-			} else if len(args) == 3 {
-				api := args[2]
-				fullPath, err := target.Add(cfPlexHome, api)
-				bailIfB0rked(err)
-				mustRunCf(fullPath, []string{"", "login", "-a", api})
-				os.Exit(0)
-			} else if len(args) == 5 {
-				api := args[2]
-				username := args[3]
-				password := args[4]
-
-				fullPath, err := target.Add(cfPlexHome, api)
-				bailIfB0rked(err)
-				mustRunCf(fullPath, []string{"", "api", api})
-				mustRunCf(fullPath, []string{"", "auth", username, password})
-				os.Exit(0)
+		var metadata, groupMetadata target.Metadata
+		args, metadata.Name = popFlag(args, "--name")
+		args, labels := popFlags(args, "--label")
+		args, metadata.SkipSslValidation = popSwitch(args, "--skip-ssl-validation")
+		args, metadata.Credentials = popFlag(args, "--credentials")
+		args, saveCredentials := popSwitch(args, "--save-credentials")
+		args, metadata.Origin = popFlag(args, "--origin")
+		args, metadata.Org = popFlag(args, "--org")
+		args, metadata.Space = popFlag(args, "--space")
+		args, orgMappings := popFlags(args, "--map-org")
+		args, spaceMappings := popFlags(args, "--map-space")
+		args, clientID, clientSecret := popFlagPair(args, "--client-credentials")
+		args, metadata.CfBinary = popFlag(args, "--cf-binary")
+		args, metadata.CfVersion = popFlag(args, "--cf-version")
+		args, metadata.CredentialHelper = popFlag(args, "--credential-helper")
+		args, groupMetadata.CfBinary = popFlag(args, "--group-cf-binary")
+		args, groupMetadata.CfVersion = popFlag(args, "--group-cf-version")
+		args, groupMetadata.CredentialHelper = popFlag(args, "--group-credential-helper")
+
+		var group, api, username, password string
+		rest := args[2:]
+		if len(rest) > 0 && rest[0] == "-g" && len(rest) > 1 {
+			group = rest[1]
+			rest = rest[2:]
+		}
+
+		switch {
+		case len(rest) == 1:
+			api = rest[0]
+		case len(rest) == 3 && clientID == "":
+			api, username, password = rest[0], rest[1], rest[2]
+		default:
+			fmt.Println("Usage: " + addUsage)
+			os.Exit(1)
+		}
+		credentials := login.Credentials{Username: username, Password: password, ClientID: clientID, ClientSecret: clientSecret}
+		redact.Add(password, clientSecret)
+
+		resolver := &login.Resolver{PlexHome: cfPlexHome}
+		if saveCredentials {
+			if credentials.Empty() {
+				fmt.Println("--save-credentials needs a username and password, or client credentials")
+				os.Exit(1)
+			}
+			_, err := resolver.Store()
+			bailIfB0rked(err)
+			if metadata.Credentials == "" {
+				metadata.Credentials = "store:" + username + clientID
 			}
 		}
 
-		fmt.Println("Usage: " + addUsage)
-		os.Exit(1)
+		for _, label := range labels {
+			key, value, err := selector.ParseLabel(label)
+			bailIfB0rked(err)
+			if metadata.Labels == nil {
+				metadata.Labels = make(map[string]string)
+			}
+			metadata.Labels[key] = value
+		}
+
+		if metadata.Space != "" && metadata.Org == "" {
+			fmt.Println("--space needs --org")
+			os.Exit(1)
+		}
+		metadata.OrgMap = mustParseMappings(orgMappings)
+		metadata.SpaceMap = mustParseMappings(spaceMappings)
+
+		dirName := api
+		if metadata.Name != "" {
+			bailIfB0rked(target.ValidateName(metadata.Name))
+			dirName = metadata.Name
+		}
+
+		var fullPath string
+		var err error
+		if group == "" {
+			fullPath, err = target.Add(cfPlexHome, dirName)
+		} else {
+			fullPath, err = target.AddToGroup(cfPlexHome, group, dirName)
+		}
+		bailIfB0rked(err)
+
+		existing, err := target.ReadMetadata(fullPath)
+		bailIfB0rked(err)
+		if existing.Api != "" && existing.Api != api {
+			fmt.Println("Name " + metadata.Name + " is already used for " + existing.Api)
+			os.Exit(1)
+		}
+
+		if groupMetadata.CfBinary != "" || groupMetadata.CfVersion != "" || groupMetadata.CredentialHelper != "" {
+			groupDir := filepath.Dir(fullPath)
+			existing, err := target.ReadMetadata(groupDir)
+			bailIfB0rked(err)
+			if groupMetadata.CfBinary != "" || groupMetadata.CfVersion != "" {
+				existing.CfBinary = groupMetadata.CfBinary
+				existing.CfVersion = groupMetadata.CfVersion
+			}
+			if groupMetadata.CredentialHelper != "" {
+				existing.CredentialHelper = groupMetadata.CredentialHelper
+			}
+			bailIfB0rked(target.WriteMetadata(groupDir, existing))
+		}
+
+		metadata.Api = api
+		bailIfB0rked(target.WriteMetadata(fullPath, metadata))
+		aTarget, err := target.Load(fullPath, group)
+		bailIfB0rked(err)
+
+		if credentials.Empty() {
+			credentials, err = resolver.For(aTarget)
+			bailIfB0rked(err)
+		}
+		held, err := lockTarget(aTarget)
+		bailIfB0rked(err)
+		for _, command := range login.Commands(aTarget, credentials, false) {
+			mustRunCf(aTarget, command)
+		}
+		bailIfB0rked(held.Release())
+
+		if saveCredentials {
+			store, err := resolver.Store()
+			bailIfB0rked(err)
+			if clientID != "" {
+				store.Put(credstore.Entry{Api: api, Username: clientID, Password: clientSecret, Client: true})
+			} else {
+				store.Put(credstore.Entry{Api: api, Username: username, Password: password})
+			}
+			bailIfB0rked(store.Save())
+		}
+		checkCfVersions([]target.Target{aTarget}, runOptions{})
+
+		if group != "" {
+			fmt.Println("Added " + aTarget.Name + " to group '" + group + "'")
+		}
+		os.Exit(0)
 	case "list-apis":
 		bailIfCfEnvs()
 
+		format := report.Text
+		var apiSelector selector.Selector
+		for rest := args[2:]; len(rest) > 0; rest = rest[2:] {
+			if len(rest) < 2 {
+				fmt.Println("Usage: " + listUsage)
+				os.Exit(1)
+			}
+
+			var err error
+			switch rest[0] {
+			case "--output":
+				format, err = report.ParseFormat(rest[1])
+			case "--selector":
+				apiSelector, err = selector.Parse(rest[1])
+			default:
+				fmt.Println("Usage: " + listUsage)
+				os.Exit(1)
+			}
+			bailIfB0rked(err)
+		}
+
 		groups, err := target.List(cfPlexHome)
 		bailIfB0rked(err)
+
+		if apiSelector != nil {
+			groups = selectGroups(groups, apiSelector)
+		}
+
+		if format != report.Text {
+			bailIfB0rked(report.Write(os.Stdout, format, report.ApiRecords(groups)))
+			os.Exit(0)
+		}
+
 		for _, group := range groups {
 			fmt.Println(group.Name)
 
 			for _, target := range group.Apis {
-				fmt.Println("\t" + target.Name)
+				description := "\t" + target.Name
+				if target.Name != target.Api {
+					description += "\t" + target.Api
+				}
+				if len(target.Labels) > 0 {
+					description += "\t" + selector.Format(target.Labels)
+				}
+				fmt.Println(description + describeCf(target) + describeSession(target))
 			}
 		}
 	case "remove-api":
 		bailIfCfEnvs()
+		mustLockHome(cfPlexHome)
 
 		if len(args) < 3 {
 			fmt.Println("Usage: " + removeUsage)
@@ -103,72 +285,540 @@ func main() {
 
 			group := args[3]
 			api := args[4]
+			mustLockFound(cfPlexHome, group, api)
 			err := target.RemoveFromGroup(cfPlexHome, group, api)
 			bailIfB0rked(err)
 			fmt.Println("Removed " + api + " from '" + group + "'")
 		} else {
 			api := args[2]
+			mustLockFound(cfPlexHome, "default", api)
 			err := target.Remove(cfPlexHome, api)
 			bailIfB0rked(err)
 			fmt.Println("Removed " + api)
 		}
-	default:
+	case "rename-api":
+		bailIfCfEnvs()
+		mustLockHome(cfPlexHome)
+
+		group := "default"
+		rest := args[2:]
+		if len(rest) > 0 && rest[0] == "-g" && len(rest) > 1 {
+			group = rest[1]
+			rest = rest[2:]
+		}
+
+		if len(rest) != 2 {
+			fmt.Println("Usage: " + renameUsage)
+			os.Exit(1)
+		}
+
+		mustLockFound(cfPlexHome, group, rest[0])
+		aTarget, err := target.Rename(cfPlexHome, group, rest[0], rest[1])
+		bailIfB0rked(err)
+		fmt.Println("Renamed " + aTarget.Api + " to " + aTarget.Name)
+	case "label":
+		bailIfCfEnvs()
+		mustLockHome(cfPlexHome)
+
+		group := "default"
+		rest := args[2:]
+		if len(rest) > 0 && rest[0] == "-g" && len(rest) > 1 {
+			group = rest[1]
+			rest = rest[2:]
+		}
+
+		if len(rest) < 2 {
+			fmt.Println("Usage: " + labelUsage)
+			os.Exit(1)
+		}
+
+		set := make(map[string]string)
+		var remove []string
+		for _, label := range rest[1:] {
+			if strings.HasSuffix(label, "-") && !strings.Contains(label, "=") {
+				key := strings.TrimSuffix(label, "-")
+				bailIfB0rked(selector.ValidateKey(key))
+				remove = append(remove, key)
+				continue
+			}
+
+			key, value, err := selector.ParseLabel(label)
+			bailIfB0rked(err)
+			set[key] = value
+		}
+
+		mustLockFound(cfPlexHome, group, rest[0])
+		aTarget, err := target.Label(cfPlexHome, group, rest[0], set, remove)
+		bailIfB0rked(err)
+		fmt.Println("Labelled " + aTarget.Name + ": " + selector.Format(aTarget.Labels))
+	case "migrate":
+		bailIfCfEnvs()
+		mustLockHome(cfPlexHome)
+
+		migrated, err := target.Migrate(cfPlexHome)
+		bailIfB0rked(err)
+		for _, aTarget := range migrated {
+			fmt.Println("Migrated " + aTarget.Api + " in group '" + aTarget.Group + "'")
+		}
+		if len(migrated) == 0 {
+			fmt.Println("Nothing to migrate")
+		}
+	case "status":
+		bailIfCfEnvs()
+
+		opts := runOptions{format: report.Text}
+		var groupName string
+		timeout := preflight.DefaultTimeout
+		for rest := args[2:]; len(rest) > 0; rest = rest[2:] {
+			if len(rest) < 2 {
+				fmt.Println("Usage: " + statusUsage)
+				os.Exit(1)
+			}
+
+			var err error
+			switch rest[0] {
+			case "-g":
+				groupName = rest[1]
+			case "-t":
+				opts.names = append(opts.names, rest[1])
+			case "--selector":
+				opts.selector, err = selector.Parse(rest[1])
+			case "--timeout":
+				timeout, err = time.ParseDuration(rest[1])
+			case "--output":
+				opts.format, err = report.ParseFormat(rest[1])
+			default:
+				fmt.Println("Usage: " + statusUsage)
+				os.Exit(1)
+			}
+			bailIfB0rked(err)
+		}
+
 		var targets []target.Target
+		if groupName != "" {
+			targets = mustGetGroup(cfPlexHome, groupName)
+		} else {
+			targets = mustGetAllTargets(cfPlexHome)
+		}
+		targets = mustNarrowTargets(targets, opts)
 
-		cfEnvs := env.Get("CF_PLEX_APIS", "")
-		if cfEnvs != "" {
-			targets = getTargetsFromEnv(cfPlexHome, cfEnvs)
+		statuses := status.Check(targets, timeout)
+		if opts.format != report.Text {
+			bailIfB0rked(report.Write(os.Stdout, opts.format, report.StatusRecords(statuses)))
 		} else {
-			if args[1] == "-g" {
-				groupName := args[2]
-				groups, err := target.List(cfPlexHome)
-				bailIfB0rked(err)
-				for _, group := range groups {
-					if group.Name == groupName {
-						targets = group.Apis
-					}
-				}
+			bailIfB0rked(report.StatusTable(os.Stdout, statuses, time.Now()))
+		}
+	case "login":
+		bailIfCfEnvs()
 
-				if len(targets) == 0 {
-					os.Stderr.WriteString("Group '" + groupName + "' not recognised")
-					os.Exit(1)
+		args, sso := popSwitch(args, "--sso")
+		args, force := popSwitch(args, "--force")
+
+		var opts runOptions
+		var groupName string
+		for rest := args[2:]; len(rest) > 0; rest = rest[2:] {
+			if len(rest) < 2 {
+				fmt.Println("Usage: " + loginUsage)
+				os.Exit(1)
+			}
+
+			var err error
+			switch rest[0] {
+			case "-g":
+				groupName = rest[1]
+			case "-t":
+				opts.names = append(opts.names, rest[1])
+			case "--selector":
+				opts.selector, err = selector.Parse(rest[1])
+			default:
+				fmt.Println("Usage: " + loginUsage)
+				os.Exit(1)
+			}
+			bailIfB0rked(err)
+		}
+
+		var targets []target.Target
+		if groupName != "" {
+			targets = mustGetGroup(cfPlexHome, groupName)
+		} else {
+			targets = mustGetAllTargets(cfPlexHome)
+		}
+		targets = mustNarrowTargets(targets, opts)
+
+		resolver := &login.Resolver{PlexHome: cfPlexHome}
+		var failed []string
+		for _, aTarget := range targets {
+			if err := logIn(resolver, aTarget, sso, force); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not log in to "+aTarget.Name+": "+redact.String(err.Error()))
+				failed = append(failed, aTarget.Name)
+			}
+		}
+		checkCfVersions(targets, runOptions{})
+
+		if len(failed) > 0 {
+			fmt.Fprintln(os.Stderr, "Logging in failed for: "+strings.Join(failed, ", "))
+			os.Exit(1)
+		}
+	case "credentials":
+		rest := args[2:]
+		list := len(rest) == 1 && rest[0] == "list"
+		rotate := len(rest) == 3 && rest[0] == "rotate"
+		remove := len(rest) == 3 && rest[0] == "delete"
+		rekey := len(rest) == 1 && rest[0] == "rekey"
+		if !list && !rotate && !remove && !rekey {
+			fmt.Println("Usage: " + credentialsUsage)
+			os.Exit(1)
+		}
+		var newPassword string
+		if rotate {
+			newPassword = mustReadNewPassword()
+		}
+		var newSecret []byte
+		if rekey {
+			var err error
+			newSecret, err = credstore.NewSecret()
+			bailIfB0rked(err)
+		}
+
+		mustLockHome(cfPlexHome)
+		store, err := (&login.Resolver{PlexHome: cfPlexHome}).Store()
+		bailIfB0rked(err)
+
+		switch {
+		case list:
+			writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(writer, "API\tUSERNAME")
+			for _, entry := range store.Entries() {
+				fmt.Fprintln(writer, entry.Api+"\t"+entry.Username)
+			}
+			bailIfB0rked(writer.Flush())
+		case rotate:
+			entry, found := store.Get(rest[1], rest[2])
+			if !found {
+				fmt.Println("No password is saved for " + rest[2] + " at " + rest[1])
+				os.Exit(1)
+			}
+			entry.Password = newPassword
+			store.Put(entry)
+			bailIfB0rked(store.Save())
+			fmt.Println("Changed the password saved for " + rest[2] + " at " + rest[1])
+		case remove:
+			if !store.Delete(rest[1], rest[2]) {
+				fmt.Println("No password is saved for " + rest[2] + " at " + rest[1])
+				os.Exit(1)
+			}
+			bailIfB0rked(store.Save())
+			fmt.Println("Deleted the password saved for " + rest[2] + " at " + rest[1])
+		case rekey:
+			bailIfB0rked(store.Rekey(newSecret))
+			bailIfB0rked(store.Save())
+			fmt.Println("Encrypted the credential store with the new passphrase or key file")
+		}
+	case "prune":
+		args, dryRun := popSwitch(args, "--dry-run")
+		args, olderThan := popFlag(args, "--older-than")
+		if len(args) != 2 {
+			fmt.Println("Usage: " + pruneUsage)
+			os.Exit(1)
+		}
+		if !batchConfigured() && olderThan == "" {
+			fmt.Println("Set CF_PLEX_APIS or CF_PLEX_APIS_FILE, or give --older-than, to choose what to prune")
+			os.Exit(1)
+		}
+		mustLockHome(cfPlexHome)
+
+		pruned := mustPrune(cfPlexHome, mustParseAge("--older-than", olderThan), prune.Either, dryRun)
+		switch {
+		case pruned == 0:
+			fmt.Println("Nothing to prune")
+		case dryRun:
+			fmt.Printf("Dry run: %d CF_HOME(s) not removed\n", pruned)
+		default:
+			fmt.Printf("%d CF_HOME(s) removed\n", pruned)
+		}
+	case "sync":
+		bailIfCfEnvs()
+		mustLockHome(cfPlexHome)
+
+		args, dryRun := popSwitch(args, "--dry-run")
+		if len(args) != 3 {
+			fmt.Println("Usage: " + syncUsage)
+			os.Exit(1)
+		}
+
+		wanted, err := inventory.Load(args[2])
+		bailIfB0rked(err)
+		changes, err := inventory.Plan(cfPlexHome, wanted)
+		bailIfB0rked(err)
+
+		for _, change := range changes {
+			fmt.Printf("%s\t%s/%s\t%s\n", change.Action, change.Group, change.Name, change.Api)
+		}
+
+		switch {
+		case len(changes) == 0:
+			fmt.Println("Already in sync")
+		case dryRun:
+			fmt.Printf("Dry run: %d change(s) not made\n", len(changes))
+		default:
+			var changing []target.Target
+			for _, change := range changes {
+				if change.Action != inventory.Create {
+					changing = append(changing, target.Target{Name: change.Name, Api: change.Api, Group: change.Group, Path: change.Path})
 				}
+			}
+			mustLockTargets(changing)
+			bailIfB0rked(inventory.Apply(cfPlexHome, changes))
+			fmt.Printf("%d change(s) made\n", len(changes))
+		}
+	default:
+		var targets []target.Target
+		var groupName string
 
+		batchMode := batchConfigured()
+		opts := runOptions{parallel: 1, format: report.Text, skipPreflight: env.Get("CF_PLEX_SKIP_PREFLIGHT", "") == "true"}
+	flags:
+		for len(args) > 2 {
+			switch args[1] {
+			case "-g":
+				groupName = args[2]
 				args = append(args[0:0], args[2:]...)
-			} else {
-				if target.GroupsExist(cfPlexHome) {
-					os.Stderr.WriteString("-g <group> is mandatory whenever groups have been added. Use '-g default' to target APIs without an explicit group.")
+			case "--parallel":
+				var err error
+				opts.parallel, err = strconv.Atoi(args[2])
+				if err != nil || opts.parallel < 1 {
+					os.Stderr.WriteString("--parallel must be a positive number")
 					os.Exit(1)
 				}
-
+				args = append(args[0:0], args[2:]...)
+			case "-t":
+				opts.names = append(opts.names, args[2])
+				args = append(args[0:0], args[2:]...)
+			case "--selector":
 				var err error
-				groups, err := target.List(cfPlexHome)
+				opts.selector, err = selector.Parse(args[2])
 				bailIfB0rked(err)
-				if len(groups[0].Apis) == 0 {
-					os.Stderr.WriteString("No APIs have been set")
-					os.Exit(1)
-				}
-				targets = groups[0].Apis
+				args = append(args[0:0], args[2:]...)
+			case "--org":
+				opts.scope.Org = args[2]
+				args = append(args[0:0], args[2:]...)
+			case "--space":
+				opts.scope.Space = args[2]
+				args = append(args[0:0], args[2:]...)
+			case "--skip-preflight":
+				opts.skipPreflight = true
+				args = append(args[0:0], args[1:]...)
+			case "--prefix":
+				opts.prefix = true
+				args = append(args[0:0], args[1:]...)
+			case "--exit-policy":
+				var err error
+				opts.policy, err = fanout.ParseExitPolicy(args[2])
+				bailIfB0rked(err)
+				args = append(args[0:0], args[2:]...)
+			case "--output":
+				var err error
+				opts.format, err = report.ParseFormat(args[2])
+				bailIfB0rked(err)
+				args = append(args[0:0], args[2:]...)
+			default:
+				break flags
 			}
 		}
 
-		var force bool
 		if args[len(args)-1] == "--force" {
-			force = true
+			opts.force = true
 			args = args[:len(args)-1]
 		}
 
-		fmt.Println()
-		for _, aTarget := range targets {
-			err, exitCode, _ := cfcli.Run(aTarget.Path, args)
+		if opts.policy == "" && !opts.force {
+			opts.policy = fanout.FirstFailed
+		}
+
+		if opts.format != report.Text {
+			progress = os.Stderr
+		}
+
+		if batchMode {
+			held := mustLockHome(cfPlexHome)
+			if env.Get("CF_PLEX_AUTO_PRUNE", "") == "true" {
+				olderThan := env.Get("CF_PLEX_PRUNE_OLDER_THAN", "")
+				if olderThan == "" {
+					bailIfB0rked(errors.New("CF_PLEX_AUTO_PRUNE needs CF_PLEX_PRUNE_OLDER_THAN, so that directories other jobs are using are not removed"))
+				}
+				mustPrune(cfPlexHome, mustParseAge("CF_PLEX_PRUNE_OLDER_THAN", olderThan), prune.Both, false)
+			}
+			targets = getBatchTargets(cfPlexHome, groupName, opts)
+			opts.locked = true
+			bailIfB0rked(held.Release())
+		} else if groupName != "" {
+			targets = mustGetGroup(cfPlexHome, groupName)
+		} else if len(opts.names) > 0 || opts.selector != nil {
+			targets = mustGetAllTargets(cfPlexHome)
+		} else {
+			if target.GroupsExist(cfPlexHome) {
+				os.Stderr.WriteString("-g <group> is mandatory whenever groups have been added. Use '-g default' to target APIs without an explicit group.")
+				os.Exit(1)
+			}
+
+			var err error
+			groups, err := target.List(cfPlexHome)
 			bailIfB0rked(err)
-			if exitCode != 0 && !force {
-				os.Exit(exitCode)
+			if len(groups[0].Apis) == 0 {
+				os.Stderr.WriteString("No APIs have been set")
+				os.Exit(1)
 			}
+			targets = groups[0].Apis
 		}
+
+		if !batchMode {
+			targets = mustNarrowTargets(targets, opts)
+		}
+		targets = resolveBinaries(targets)
+		checkCfVersions(targets, opts)
+
+		if !opts.skipPreflight && preflight.Needed(args) {
+			mustPassPreflight(targets)
+		}
+
+		if opts.format != report.Text {
+			results := runCapturing(targets, args, opts)
+			os.Exit(opts.policy.ExitCode(results))
+		}
+
+		fmt.Println()
+		results := runAll(targets, args, opts)
+		for _, result := range results {
+			if result.Err != nil && !result.Cancelled() {
+				fmt.Println(result.Err)
+			}
+		}
+
+		if len(results) > 1 {
+			fmt.Println()
+			bailIfB0rked(report.Summary(os.Stdout, results))
+		}
+		os.Exit(opts.policy.ExitCode(results))
 	}
 }
 
+// promptDelay is how long a partial line of prefixed output is held back
+// when cf may be waiting for an answer to it.
+const promptDelay = 200 * time.Millisecond
+
+func runAll(targets []target.Target, args []string, opts runOptions) []fanout.Result {
+	if !opts.prefix && opts.parallel == 1 {
+		return fanout.Run(targets, 1, opts.force, func(ctx context.Context, aTarget target.Target) (int, error) {
+			release, err := lockForRun(aTarget, opts)
+			if err != nil {
+				return -1, err
+			}
+			defer release()
+
+			cfOpts := cfcli.Options{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr, Binary: aTarget.CfBinary}
+			if exitCode, err := enterScope(ctx, aTarget, args, opts.scope, cfOpts); exitCode != 0 || err != nil {
+				return exitCode, err
+			}
+			err, exitCode, _ := cfcli.RunWithOptions(ctx, aTarget.Path, args, cfOpts)
+			return exitCode, err
+		}, nil)
+	}
+
+	var names []string
+	for _, aTarget := range targets {
+		names = append(names, aTarget.Name)
+	}
+
+	stdoutPrefixes := make(map[string]string)
+	stderrPrefixes := make(map[string]string)
+	for index, aTarget := range targets {
+		stdoutPrefixes[aTarget.Path] = output.Prefix(aTarget.Name, index, names, output.ColourEnabled(os.Stdout))
+		stderrPrefixes[aTarget.Path] = output.Prefix(aTarget.Name, index, names, output.ColourEnabled(os.Stderr))
+	}
+
+	var stdoutLock, stderrLock sync.Mutex
+	return fanout.Run(targets, opts.parallel, opts.force, func(ctx context.Context, aTarget target.Target) (int, error) {
+		stdout := output.NewPrefixWriter(os.Stdout, &stdoutLock, stdoutPrefixes[aTarget.Path])
+		stderr := output.NewPrefixWriter(os.Stderr, &stderrLock, stderrPrefixes[aTarget.Path])
+		defer stdout.Flush()
+		defer stderr.Flush()
+
+		release, err := lockForRun(aTarget, opts)
+		if err != nil {
+			return -1, err
+		}
+		defer release()
+
+		cfOpts := cfcli.Options{Stdout: stdout, Stderr: stderr, Binary: aTarget.CfBinary}
+		if opts.parallel == 1 {
+			cfOpts.Stdin = os.Stdin
+			stdout.ShowPartialLines(promptDelay)
+			stderr.ShowPartialLines(promptDelay)
+		}
+		if exitCode, err := enterScope(ctx, aTarget, args, opts.scope, cfOpts); exitCode != 0 || err != nil {
+			return exitCode, err
+		}
+		err, exitCode, _ := cfcli.RunWithOptions(ctx, aTarget.Path, args, cfOpts)
+		return exitCode, err
+	}, nil)
+}
+
+// runCapturing runs without passing through any output, and instead writes
+// a JSON record for each target containing what it captured.
+func runCapturing(targets []target.Target, args []string, opts runOptions) []fanout.Result {
+	type captured struct {
+		stdout string
+		stderr string
+	}
+
+	var lock sync.Mutex
+	outputs := make(map[string]captured)
+	redactedArgs := cfcli.Redact(append([]string{"cf"}, args[1:]...))
+
+	results := fanout.Run(targets, opts.parallel, opts.force, func(ctx context.Context, aTarget target.Target) (int, error) {
+		stderr := new(bytes.Buffer)
+		cfOpts := cfcli.Options{Stderr: stderr, Binary: aTarget.CfBinary}
+		release, err := lockForRun(aTarget, opts)
+		exitCode := -1
+		if err == nil {
+			defer release()
+			exitCode, err = enterScope(ctx, aTarget, args, opts.scope, cfOpts)
+		}
+		var stdout string
+		if exitCode == 0 && err == nil {
+			err, exitCode, stdout = cfcli.RunWithOptions(ctx, aTarget.Path, args, cfOpts)
+		}
+
+		lock.Lock()
+		defer lock.Unlock()
+		outputs[aTarget.Path] = captured{stdout: stdout, stderr: stderr.String()}
+		return exitCode, err
+	}, func(index int, result fanout.Result) {
+		if opts.format == report.JSONLines {
+			lock.Lock()
+			defer lock.Unlock()
+			out := outputs[targets[index].Path]
+			bailIfB0rked(report.WriteLine(os.Stdout, report.NewRecord(result, redactedArgs, out.stdout, out.stderr)))
+		}
+	})
+
+	var records []report.Record
+	for index, result := range results {
+		out := outputs[targets[index].Path]
+		record := report.NewRecord(result, redactedArgs, out.stdout, out.stderr)
+		if opts.format == report.JSON {
+			records = append(records, record)
+		} else if result.Skipped {
+			bailIfB0rked(report.WriteLine(os.Stdout, record))
+		}
+	}
+
+	if opts.format == report.JSON {
+		bailIfB0rked(report.Write(os.Stdout, report.JSON, records))
+	}
+	return results
+}
+
 func getConfigDir() (configDir string) {
 	configDir = os.Getenv("CF_PLEX_HOME")
 	if configDir == "" {
@@ -182,43 +832,694 @@ func getConfigDir() (configDir string) {
 }
 
 func bailIfCfEnvs() {
-	if env.Get("CF_PLEX_APIS", "") != "" {
-		fmt.Println("Managing APIs is not allowed when CF_PLEX_APIS is set")
+	if batchConfigured() {
+		fmt.Println("Managing APIs is not allowed when CF_PLEX_APIS or CF_PLEX_APIS_FILE is set")
 		os.Exit(1)
 	}
 }
 
-func getTargetsFromEnv(cfPlexHome, cfEnvs string) []target.Target {
-	var targets []target.Target
+func batchConfigured() bool {
+	return env.Get("CF_PLEX_APIS", "") != "" || env.Get("CF_PLEX_APIS_FILE", "") != ""
+}
+
+// getBatchApis reads the APIs given in CF_PLEX_APIS, either as separated
+// values or as a JSON document, or in the file named by CF_PLEX_APIS_FILE.
+// Their passwords are masked in everything printed from then on.
+func getBatchApis() []batch.Api {
+	apis := readBatchApis()
+	for _, api := range apis {
+		redact.Add(api.Password)
+	}
+	return apis
+}
+
+func readBatchApis() []batch.Api {
+	cfEnvs := env.Get("CF_PLEX_APIS", "")
+	if file := env.Get("CF_PLEX_APIS_FILE", ""); file != "" {
+		if cfEnvs != "" {
+			bailIfB0rked(errors.New("set CF_PLEX_APIS or CF_PLEX_APIS_FILE, not both"))
+		}
+		apis, err := batch.Load(file)
+		bailIfB0rked(err)
+		return apis
+	}
+
+	if batch.IsDocument(cfEnvs) {
+		apis, err := batch.Parse([]byte(cfEnvs))
+		bailIfB0rked(err)
+		return apis
+	}
+
 	tripleSeparator := env.Get("CF_PLEX_SEP_TRIPLE", env.PlexTripleSeparator)
 	credApiSeparator := env.Get("CF_PLEX_SEP_CREDS_API", env.PlexCredApiSeparator)
 	userPassSeparator := env.Get("CF_PLEX_SEP_USER_PASS", env.PlexUserPassSeparator)
+	authSeparator := env.Get("CF_PLEX_SEP_AUTH", env.PlexAuthSeparator)
 
-	coords, err := env.GetCoordinates(cfEnvs, tripleSeparator, credApiSeparator, userPassSeparator)
+	coords, err := env.GetCoordinates(cfEnvs, tripleSeparator, credApiSeparator, userPassSeparator, authSeparator)
 	bailIfB0rked(err)
+	return batch.FromCoords(coords)
+}
 
-	for _, coord := range coords {
-		apiDir, err := target.AddToBatch(cfPlexHome, coord.Api)
-		bailIfB0rked(err)
-		targets = append(targets, target.Target{Name: coord.Api, Path: apiDir})
+// batchLogin is a batch target that may need to log in, and the output of
+// doing so. Both of cf's streams are written to output, so writes to it are
+// made whilst holding lock.
+type batchLogin struct {
+	api      batch.Api
+	target   target.Target
+	metadata target.Metadata
+	loggedIn bool
+	output   bytes.Buffer
+	lock     sync.Mutex
+}
+
+// getBatchTargets sets up the CF_HOME of each batch API in groupName, if it
+// is given, and chosen with -t and --selector, and logs in to those that
+// need it. APIs that are not chosen are not logged in to, and their CF_HOMEs
+// are left alone, as other processes may be using them.
+func getBatchTargets(cfPlexHome, groupName string, opts runOptions) []target.Target {
+	var chosen []target.Target
+	apis := make(map[string]batch.Api)
 
-		output := mustRunCf(apiDir, []string{"", "api", coord.Api})
+	for _, api := range getBatchApis() {
+		if groupName != "" && !api.InGroup(groupName) {
+			continue
+		}
 
-		if strings.Contains(output, "Not logged in") {
-			mustRunCf(apiDir, []string{"", "auth", coord.Username, coord.Password})
+		apiDir := filepath.Join(target.GroupDir(cfPlexHome, "batch"), target.Sanitise(api.Dir()))
+		name := api.Api
+		if api.Name != "" {
+			name = api.Name
 		}
+		chosen = append(chosen, target.Target{Name: name, Api: api.Api, Group: "batch", Labels: api.Labels, Path: apiDir})
+		apis[apiDir] = api
+	}
+
+	if len(chosen) == 0 {
+		os.Stderr.WriteString("Group '" + groupName + "' not recognised")
+		os.Exit(1)
+	}
+	chosen = mustNarrowTargets(chosen, opts)
+	for _, aTarget := range chosen {
+		_, err := target.AddToBatch(cfPlexHome, apis[aTarget.Path].Dir())
+		bailIfB0rked(err)
+	}
+	mustLockTargets(chosen)
+
+	now := time.Now()
+	var targets []target.Target
+	var logins []*batchLogin
+	for _, chosenTarget := range chosen {
+		api := apis[chosenTarget.Path]
+		metadata, err := target.ReadMetadata(chosenTarget.Path)
+		bailIfB0rked(err)
+		metadata.Api, metadata.Name, metadata.Labels = api.Api, api.Name, api.Labels
+		metadata.SkipSslValidation, metadata.Origin = api.SkipSslValidation, api.Origin
+		metadata.Org, metadata.Space = api.Org, api.Space
+		metadata.OrgMap, metadata.SpaceMap = api.OrgMap, api.SpaceMap
+		metadata.CredentialHelper = api.CredentialHelper
+		metadata.LastUsed = &now
+		bailIfB0rked(target.WriteMetadata(chosenTarget.Path, metadata))
+
+		aTarget, err := target.Load(chosenTarget.Path, "batch")
+		bailIfB0rked(err)
+		targets = append(targets, aTarget)
+
+		config, err := cfconfig.Read(aTarget.Path)
+		bailIfB0rked(err)
+		loggedIn := config.LoggedInAs(api.Api, api.Username, now)
+		logins = append(logins, &batchLogin{api: api, target: aTarget, metadata: metadata, loggedIn: loggedIn})
 	}
 
+	mustLogInToBatch(cfPlexHome, logins)
 	return targets
 }
 
-func mustRunCf(cfHome string, args []string) string {
-	err, exitCode, output := cfcli.Run(cfHome, args)
+// mustLogInToBatch logs in to the batch targets that need it, up to
+// CF_PLEX_LOGIN_PARALLEL at a time. The output of each is written once it has
+// finished, so that it is not interleaved with the others. Every failure is
+// reported before exiting.
+func mustLogInToBatch(cfPlexHome string, logins []*batchLogin) {
+	if len(logins) == 0 {
+		return
+	}
+
+	parallel, err := strconv.Atoi(env.Get("CF_PLEX_LOGIN_PARALLEL", "4"))
+	if err != nil || parallel < 1 {
+		bailIfB0rked(errors.New("CF_PLEX_LOGIN_PARALLEL must be a positive number"))
+	}
+
+	var targets []target.Target
+	byPath := make(map[string]*batchLogin)
+	for _, pending := range logins {
+		targets = append(targets, pending.target)
+		byPath[pending.target.Path] = pending
+	}
+
+	resolver := &login.Resolver{PlexHome: cfPlexHome}
+	results := fanout.Run(targets, parallel, true, func(ctx context.Context, aTarget target.Target) (int, error) {
+		return 0, logInToBatch(ctx, resolver, byPath[aTarget.Path])
+	}, func(index int, result fanout.Result) {
+		progress.Write(logins[index].output.Bytes())
+	})
+
+	var failed []string
+	for index, result := range results {
+		if result.Failed() {
+			name := logins[index].api.DisplayName()
+			fmt.Fprintln(os.Stderr, "Could not log in to "+name+": "+redact.String(result.Err.Error()))
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintln(os.Stderr, "Logging in failed for: "+strings.Join(failed, ", "))
+		os.Exit(1)
+	}
+}
+
+// logInToBatch logs a batch target in, unless it is logged in already with
+// the same credentials. They are those it was given or, failing those, those
+// of its credential helper, CF_PLEX_CREDENTIAL_HELPER or the store, which are
+// looked up every time so that changes to them are noticed too. It then
+// records their fingerprint.
+func logInToBatch(ctx context.Context, resolver *login.Resolver, pending *batchLogin) error {
+	api, aTarget := pending.api, pending.target
+
+	credentials := login.Credentials{Username: api.Username, Password: api.Password}
+	if api.ClientCredentials() {
+		credentials = login.Credentials{ClientID: api.Username, ClientSecret: api.Password}
+	}
+	if api.Password == "" {
+		helper := aTarget.CredentialHelper
+		if helper == "" {
+			helper = env.Get(login.HelperVar, "")
+		}
+
+		var err error
+		if helper != "" {
+			credentials, err = login.FromHelper(helper, api.Api, api.Username)
+		} else {
+			credentials, err = resolver.FromStore(api.Api, api.Username)
+		}
+		if err != nil {
+			return err
+		}
+
+		api.Password = credentials.Password
+		if credentials.ClientID != "" {
+			api.Password = credentials.ClientSecret
+		}
+	}
+
+	stdout := output.NewLineWriter(&pending.output, &pending.lock)
+	defer stdout.Flush()
+	stderr := output.NewLineWriter(&pending.output, &pending.lock)
+	defer stderr.Flush()
+
+	if pending.loggedIn {
+		if api.HasFingerprint(pending.metadata.Fingerprint) {
+			return nil
+		}
+		if pending.metadata.Fingerprint != "" {
+			fmt.Fprintln(stdout, "The credentials for "+api.DisplayName()+" have changed, so logging in again")
+		}
+	}
+
+	binary, err := cfcli.ResolveBinary(aTarget.CfBinary, aTarget.CfVersion)
+	if err != nil {
+		return err
+	}
+	config, err := cfconfig.Read(aTarget.Path)
+	if err != nil {
+		return err
+	}
+
+	opts := cfcli.Options{Stdout: stdout, Stderr: stderr, Binary: binary}
+	for _, command := range login.SkipApi(login.Commands(aTarget, credentials, false), config, aTarget) {
+		err, exitCode, _ := cfcli.RunWithOptions(ctx, aTarget.Path, command, opts)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("cf %s exited with %d", command[1], exitCode)
+		}
+	}
+
+	pending.metadata.Fingerprint, err = api.Fingerprint()
+	if err != nil {
+		return err
+	}
+	return target.WriteMetadata(aTarget.Path, pending.metadata)
+}
+
+// mustReadNewPassword reads the password that credentials rotate saves from
+// CF_PLEX_NEW_PASSWORD, or failing that from the first line of stdin, so
+// that it never appears in the arguments of a process.
+func mustReadNewPassword() string {
+	password := env.Get("CF_PLEX_NEW_PASSWORD", "")
+	if password == "" {
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(os.Stderr, "New password> ")
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			bailIfB0rked(err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if password == "" {
+		bailIfB0rked(errors.New("give the new password in CF_PLEX_NEW_PASSWORD or on stdin"))
+	}
+	redact.Add(password)
+	return password
+}
+
+// mustLockHome locks CF_PLEX_HOME, creating it if need be, while targets,
+// groups and the credential store are changed. Unless it is released first,
+// the lock is held until cf-plex exits.
+func mustLockHome(cfPlexHome string) *lock.Lock {
+	bailIfB0rked(os.MkdirAll(cfPlexHome, 0700))
+	timeout, err := lock.Timeout()
+	bailIfB0rked(err)
+	held, err := lock.Acquire(cfPlexHome, timeout)
+	bailIfB0rked(err)
+	return held
+}
+
+// mustLockTargets locks the CF_HOME of each target until cf-plex exits, so
+// that batch targets stay locked from logging in until the command has been
+// run against them, and targets stay locked whilst they are changed. Every
+// process locks them in the same order, so that two processes cannot each
+// wait for a target that the other holds.
+func mustLockTargets(targets []target.Target) {
+	sorted := append([]target.Target(nil), targets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+	for _, aTarget := range sorted {
+		_, err := lockTarget(aTarget)
+		bailIfB0rked(err)
+	}
+}
+
+// mustLockFound locks the targets in group whose name or API URL is ref, as
+// mustLockTargets does, so that they can be changed or removed.
+func mustLockFound(cfPlexHome, group, ref string) {
+	found, err := target.Find(cfPlexHome, group, ref)
+	if err != nil && !os.IsNotExist(err) {
+		bailIfB0rked(err)
+	}
+	mustLockTargets(found)
+}
+
+// lockForRun locks aTarget while a command is run against it, unless batch
+// mode locked it already. The func it returns releases the lock.
+func lockForRun(aTarget target.Target, opts runOptions) (func(), error) {
+	if opts.locked {
+		return func() {}, nil
+	}
+	held, err := lockTarget(aTarget)
+	if err != nil {
+		return nil, err
+	}
+	return func() { held.Release() }, nil
+}
+
+// lockTarget locks the CF_HOME of aTarget, so that no other cf-plex process
+// uses its session until the lock is released. Everything that is run
+// against a target, from targeting an org and space to the command itself,
+// is run whilst holding the lock.
+func lockTarget(aTarget target.Target) (*lock.Lock, error) {
+	timeout, err := lock.Timeout()
+	if err != nil {
+		return nil, err
+	}
+	return lock.Acquire(aTarget.Path, timeout)
+}
+
+// mustPrune logs out of and removes stale batch targets: those that are not
+// in CF_PLEX_APIS, when it is set, and those that have not been used for
+// longer than age, when it is not zero. With prune.Both, a target must be
+// both to be removed. It returns how many there were.
+func mustPrune(cfPlexHome string, age time.Duration, match prune.Match, dryRun bool) int {
+	var referenced []string
+	if batchConfigured() {
+		referenced = []string{}
+		for _, api := range getBatchApis() {
+			referenced = append(referenced, api.Dir())
+		}
+	}
+	var cutoff time.Time
+	if age > 0 {
+		cutoff = time.Now().Add(-age)
+	}
+
+	stale, err := prune.Find(cfPlexHome, referenced, cutoff, match)
+	bailIfB0rked(err)
+
+	for _, each := range stale {
+		name := filepath.Base(each.Target.Path)
+		if dryRun {
+			fmt.Fprintln(progress, "Would remove "+name+" ("+each.Reason+")")
+			continue
+		}
+
+		held, err := lockTarget(each.Target)
+		bailIfB0rked(err)
+		if exitCode, err := runCf(each.Target, []string{"", "logout"}); err != nil || exitCode != 0 {
+			fmt.Fprintln(os.Stderr, "Could not log out of "+name+", so removing its session without logging out")
+		}
+		bailIfB0rked(target.Delete(cfPlexHome, each.Target))
+		bailIfB0rked(held.Release())
+		fmt.Fprintln(progress, "Removed "+name+" ("+each.Reason+")")
+	}
+	return len(stale)
+}
+
+// mustParseAge reads the duration given by name, where empty means none.
+func mustParseAge(name, age string) time.Duration {
+	if age == "" {
+		return 0
+	}
+	duration, err := time.ParseDuration(age)
+	if err != nil || duration <= 0 {
+		bailIfB0rked(errors.New(name + " must be a positive duration, such as 720h"))
+	}
+	return duration
+}
+
+func mustRunCf(aTarget target.Target, args []string) {
+	exitCode, err := runCf(aTarget, args)
 	bailIfB0rked(err)
 	if exitCode != 0 {
 		os.Exit(exitCode)
 	}
-	return output
+}
+
+func runCf(aTarget target.Target, args []string) (int, error) {
+	binary, err := cfcli.ResolveBinary(aTarget.CfBinary, aTarget.CfVersion)
+	if err != nil {
+		return -1, err
+	}
+
+	opts := cfcli.Options{Stdin: os.Stdin, Stdout: progress, Stderr: os.Stderr, Binary: binary}
+	err, exitCode, _ := cfcli.RunWithOptions(context.Background(), aTarget.Path, args, opts)
+	return exitCode, err
+}
+
+// enterScope targets the org and space that aTarget should be in before
+// args are run, unless they are already targeted or args do not need them.
+func enterScope(ctx context.Context, aTarget target.Target, args []string, override scope.Override, cfOpts cfcli.Options) (int, error) {
+	if !scope.Needed(args) {
+		return 0, nil
+	}
+
+	config, err := cfconfig.Read(aTarget.Path)
+	if err != nil {
+		return -1, err
+	}
+	org, space := scope.For(aTarget, override)
+	command := scope.Command(config, org, space)
+	if command == nil {
+		return 0, nil
+	}
+
+	cfOpts.Stdin = nil
+	err, exitCode, _ := cfcli.RunWithOptions(ctx, aTarget.Path, command, cfOpts)
+	if err == nil && exitCode != 0 {
+		err = fmt.Errorf("cf %s exited with %d", strings.Join(command[1:], " "), exitCode)
+	}
+	return exitCode, err
+}
+
+func mustParseMappings(mappings []string) map[string]string {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	table := make(map[string]string)
+	for _, mapping := range mappings {
+		name, actual, err := scope.ParseMapping(mapping)
+		bailIfB0rked(err)
+		table[name] = actual
+	}
+	return table
+}
+
+// logIn re-authenticates a target unless it already has a valid session,
+// using its credentials reference if it has one, or else letting cf prompt.
+func logIn(resolver *login.Resolver, aTarget target.Target, sso, force bool) error {
+	held, err := lockTarget(aTarget)
+	if err != nil {
+		return err
+	}
+	defer held.Release()
+
+	needed, err := login.Needed(aTarget, time.Now())
+	if err != nil {
+		return err
+	}
+	if !needed && !force {
+		fmt.Println("Already logged in to " + aTarget.Name)
+		return nil
+	}
+
+	var credentials login.Credentials
+	if !sso {
+		credentials, err = resolver.For(aTarget)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, command := range login.Commands(aTarget, credentials, sso) {
+		exitCode, err := runCf(aTarget, command)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("cf %s exited with %d", command[1], exitCode)
+		}
+	}
+	return nil
+}
+
+// resolveBinaries returns targets with CfBinary set to the cf binary that
+// satisfies each target's settings.
+func resolveBinaries(targets []target.Target) []target.Target {
+	var resolved []target.Target
+	for _, aTarget := range targets {
+		binary, err := cfcli.ResolveBinary(aTarget.CfBinary, aTarget.CfVersion)
+		bailIfB0rked(err)
+		aTarget.CfBinary = binary
+		resolved = append(resolved, aTarget)
+	}
+	return resolved
+}
+
+// checkCfVersions warns about targets whose CF_HOME was last used with a
+// different major version of the cf CLI, and records the version used now
+// whilst holding the lock on it.
+func checkCfVersions(targets []target.Target, opts runOptions) {
+	majors := make(map[string]int)
+
+	for _, aTarget := range targets {
+		binary, err := cfcli.ResolveBinary(aTarget.CfBinary, aTarget.CfVersion)
+		if err != nil {
+			continue
+		}
+
+		major, checked := majors[binary]
+		if !checked {
+			major, _ = cfcli.Version(binary)
+			majors[binary] = major
+		}
+		if major == 0 {
+			continue
+		}
+
+		release, err := lockForRun(aTarget, opts)
+		bailIfB0rked(err)
+		metadata, err := target.ReadMetadata(aTarget.Path)
+		bailIfB0rked(err)
+		if metadata.LastCfMajor != major {
+			if metadata.LastCfMajor != 0 {
+				fmt.Fprintf(os.Stderr, "Warning: %s was last used with cf v%d but is now using v%d (%s). Its config.json may be incompatible; re-add the API if commands fail.\n", aTarget.Name, metadata.LastCfMajor, major, binary)
+			}
+			metadata.LastCfMajor = major
+			bailIfB0rked(target.WriteMetadata(aTarget.Path, metadata))
+		}
+		release()
+	}
+}
+
+// mustPassPreflight exits, listing the targets that need attention, unless
+// every target passes its pre-flight check.
+func mustPassPreflight(targets []target.Target) {
+	problems := preflight.Check(targets, preflight.DefaultTimeout)
+	if len(problems) == 0 {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "Pre-flight check failed, so nothing has been run. These APIs need attention:")
+	writer := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, problem := range problems {
+		fmt.Fprintf(writer, "  %s\t%s\n", problem.Target.Name, problem.Reason)
+	}
+	writer.Flush()
+	fmt.Fprintln(os.Stderr, "Re-authenticate with cf-plex login, or skip this check with --skip-preflight.")
+	os.Exit(1)
+}
+
+// describeSession summarises who, if anyone, is logged in to a target,
+// according to its config.json.
+func describeSession(aTarget target.Target) string {
+	config, err := cfconfig.Read(aTarget.Path)
+	if err != nil {
+		return "\t(" + err.Error() + ")"
+	}
+	if !config.LoggedIn() {
+		return "\tnot logged in"
+	}
+
+	description := "\t" + config.User()
+	if config.OrganizationFields.Name != "" {
+		description += " " + config.OrganizationFields.Name + "/" + config.SpaceFields.Name
+	}
+	if !config.Valid(time.Now()) {
+		description += " (token expired)"
+	}
+	return description
+}
+
+func describeCf(aTarget target.Target) string {
+	if aTarget.CfBinary != "" {
+		return "\t(cf: " + aTarget.CfBinary + ")"
+	}
+	if aTarget.CfVersion != "" {
+		return "\t(cf v" + strings.TrimPrefix(aTarget.CfVersion, "v") + ")"
+	}
+	return ""
+}
+
+func mustGetGroup(cfPlexHome, groupName string) []target.Target {
+	groups, err := target.List(cfPlexHome)
+	bailIfB0rked(err)
+	for _, group := range groups {
+		if group.Name == groupName && len(group.Apis) > 0 {
+			return group.Apis
+		}
+	}
+
+	os.Stderr.WriteString("Group '" + groupName + "' not recognised")
+	os.Exit(1)
+	return nil
+}
+
+func mustGetAllTargets(cfPlexHome string) []target.Target {
+	groups, err := target.List(cfPlexHome)
+	bailIfB0rked(err)
+
+	var targets []target.Target
+	for _, group := range groups {
+		targets = append(targets, group.Apis...)
+	}
+	return targets
+}
+
+// mustNarrowTargets keeps only the targets chosen with -t and --selector.
+func mustNarrowTargets(targets []target.Target, opts runOptions) []target.Target {
+	if len(opts.names) > 0 {
+		var err error
+		targets, err = target.Select(targets, opts.names)
+		if err != nil {
+			os.Stderr.WriteString(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if opts.selector != nil {
+		targets = selectTargets(targets, opts.selector)
+		if len(targets) == 0 {
+			os.Stderr.WriteString("No APIs match the selector")
+			os.Exit(1)
+		}
+	}
+	return targets
+}
+
+func selectTargets(targets []target.Target, apiSelector selector.Selector) []target.Target {
+	var selected []target.Target
+	for _, aTarget := range targets {
+		if apiSelector.Matches(aTarget.Labels) {
+			selected = append(selected, aTarget)
+		}
+	}
+	return selected
+}
+
+// selectGroups returns only those groups with targets matching apiSelector,
+// and only those targets.
+func selectGroups(groups []target.Group, apiSelector selector.Selector) []target.Group {
+	var selected []target.Group
+	for _, group := range groups {
+		group.Apis = selectTargets(group.Apis, apiSelector)
+		if len(group.Apis) > 0 {
+			selected = append(selected, group)
+		}
+	}
+	return selected
+}
+
+// popFlags removes every occurrence of a repeatable flag, returning the
+// remaining args and the values in the order they were given.
+func popFlags(args []string, name string) ([]string, []string) {
+	var values []string
+	for {
+		var value string
+		args, value = popFlag(args, name)
+		if value == "" {
+			return args, values
+		}
+		values = append(values, value)
+	}
+}
+
+// popSwitch removes a flag that takes no value from anywhere after the
+// sub-command, reporting whether it was present.
+func popSwitch(args []string, name string) ([]string, bool) {
+	for index := 2; index < len(args); index++ {
+		if args[index] == name {
+			remaining := append([]string{}, args[:index]...)
+			return append(remaining, args[index+1:]...), true
+		}
+	}
+	return args, false
+}
+
+// popFlagPair removes a flag that takes two values from anywhere after the
+// sub-command, returning the remaining args and the values.
+func popFlagPair(args []string, name string) ([]string, string, string) {
+	for index := 2; index < len(args)-2; index++ {
+		if args[index] == name {
+			first, second := args[index+1], args[index+2]
+			remaining := append([]string{}, args[:index]...)
+			return append(remaining, args[index+3:]...), first, second
+		}
+	}
+	return args, "", ""
+}
+
+// popFlag removes a flag and its value from anywhere after the sub-command,
+// returning the remaining args and the value.
+func popFlag(args []string, name string) ([]string, string) {
+	for index := 2; index < len(args)-1; index++ {
+		if args[index] == name {
+			value := args[index+1]
+			remaining := append([]string{}, args[:index]...)
+			return append(remaining, args[index+2:]...), value
+		}
+	}
+	return args, ""
 }
 
 func printUsageAndBail() {
@@ -227,12 +1528,20 @@ func printUsageAndBail() {
 	fmt.Println(addUsage)
 	fmt.Println(listUsage)
 	fmt.Println(removeUsage)
+	fmt.Println(renameUsage)
+	fmt.Println(labelUsage)
+	fmt.Println(migrateUsage)
+	fmt.Println(syncUsage)
+	fmt.Println(statusUsage)
+	fmt.Println(loginUsage)
+	fmt.Println(credentialsUsage)
+	fmt.Println(pruneUsage)
 	os.Exit(1)
 }
 
 func bailIfB0rked(err error) {
 	if err != nil {
-		fmt.Println(err)
+		fmt.Println(redact.String(err.Error()))
 		os.Exit(1)
 	}
 }
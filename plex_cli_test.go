@@ -5,12 +5,17 @@ import (
 	"io/ioutil"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/EngineerBetter/cf-plex/batch"
 	"github.com/EngineerBetter/cf-plex/cfcli"
 	"github.com/EngineerBetter/cf-plex/clipr"
 	"github.com/EngineerBetter/cf-plex/env"
+	"github.com/EngineerBetter/cf-plex/fakecc"
+	"github.com/EngineerBetter/cf-plex/target"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/onsi/gomega/gbytes"
@@ -18,38 +23,60 @@ import (
 )
 
 var timeout = "10s"
-var addUsageMatcher = "cf-plex add-api \\[-g <group>\\] <apiUrl> \\[<username> <password>\\]"
-var listUsageMatcher = "cf-plex list-apis"
-var removeUsageMatcher = "cf-plex remove-api \\[-g <group>\\] <apiUrl>"
+var orgName = "plex-testing"
+var addUsageMatcher = "cf-plex add-api \\[-g <group>\\] \\[--name <name>\\] \\[--label <key>=<value>\\]... \\[--skip-ssl-validation\\] \\[--credentials <reference> \\| --save-credentials\\] \\[--credential-helper <command>\\] \\[--origin <origin>\\] \\[--org <org> \\[--space <space>\\]\\] \\[--map-org <name>=<actual>\\]... \\[--map-space <name>=<actual>\\]... \\[--cf-binary <path> \\| --cf-version <major>\\] \\[--group-cf-binary <path> \\| --group-cf-version <major>\\] \\[--group-credential-helper <command>\\] <apiUrl> \\[<username> <password> \\| --client-credentials <client> <secret>\\]"
+var listUsageMatcher = "cf-plex list-apis \\[--selector <selector>\\] \\[--output <format>\\]"
+var removeUsageMatcher = "cf-plex remove-api \\[-g <group>\\] <apiUrl \\| name>"
+var renameUsageMatcher = "cf-plex rename-api \\[-g <group>\\] <apiUrl \\| name> <new name>"
+var labelUsageMatcher = "cf-plex label \\[-g <group>\\] <apiUrl \\| name> <key>=<value> \\| <key>-..."
+var migrateUsageMatcher = "cf-plex migrate"
+var syncUsageMatcher = "cf-plex sync \\[--dry-run\\] <inventory file>"
+var statusUsageMatcher = "cf-plex status \\[-g <group>\\] \\[-t <name>\\]... \\[--selector <selector>\\] \\[--timeout <duration>\\] \\[--output <format>\\]"
+var loginUsageMatcher = "cf-plex login \\[-g <group>\\] \\[-t <name>\\]... \\[--selector <selector>\\] \\[--sso\\] \\[--force\\]"
+var credentialsUsageMatcher = "cf-plex credentials list \\| rotate <apiUrl> <username> \\| delete <apiUrl> <username> \\| rekey"
+var pruneUsageMatcher = "cf-plex prune \\[--dry-run\\] \\[--older-than <duration>\\]"
 
 var _ = Describe("cf-plex", func() {
 
 	var tmpDir string
 	var cfUsername string
 	var cfPassword string
+	// otherUsername can also log in to firstApi, with cfPassword
+	var otherUsername string
 	var cliPath string
 	var envVars []string
+	var foundations []*httptest.Server
+	// firstApi sorts before secondApi, and is the only one with an org called orgName
+	var firstApi, secondApi string
 
 	BeforeEach(func() {
 		var err error
 		tmpDir, err = ioutil.TempDir("", "plex-test")
 		Ω(err).ShouldNot(HaveOccurred())
 
-		cfUsername = os.Getenv("CF_USERNAME")
-		if cfUsername == "" {
-			cfUsername = "testing@engineerbetter.com"
-		}
+		cfUsername = "testing@engineerbetter.com"
+		cfPassword = "fake-password"
+		otherUsername = "user2@engineerbetter.com"
 
-		cfPassword = os.Getenv("CF_PASSWORD")
-		Ω(cfPassword).ShouldNot(BeZero(), "CF_PASSWORD env var must be set")
+		foundations = []*httptest.Server{httptest.NewServer(nil), httptest.NewServer(nil)}
+		sort.Slice(foundations, func(i, j int) bool {
+			return target.Sanitise(foundations[i].URL) < target.Sanitise(foundations[j].URL)
+		})
+		firstApi = foundations[0].URL
+		secondApi = foundations[1].URL
+		fakecc.Configure(foundations[0].Config, fakecc.Foundation{Addr: firstApi, Username: cfUsername, Password: cfPassword, OtherUsers: map[string]string{otherUsername: cfPassword}, Orgs: []string{orgName}})
+		fakecc.Configure(foundations[1].Config, fakecc.Foundation{Addr: secondApi, Username: cfUsername, Password: cfPassword})
 
 		envVars = env.Set("CF_PLEX_HOME", tmpDir, os.Environ())
 		envVars = env.Set("CF_COLOR", "false", envVars)
-		cliPath, err = Build("github.com/EngineerBetter/cf-plex")
+		cliPath, err = Build("github.com/EngineerBetter/cf-plex", buildArgs...)
 		Ω(err).ShouldNot(HaveOccurred())
 	})
 
 	AfterEach(func() {
+		for _, foundation := range foundations {
+			foundation.Close()
+		}
 		Ω(os.RemoveAll(tmpDir)).Should(Succeed())
 	})
 
@@ -82,13 +109,13 @@ var _ = Describe("cf-plex", func() {
 
 			Eventually(session).Should(Say("Plugin EchoDemo 0.1.4 successfully installed"))
 
-			addApi("https://api.run.pivotal.io", cfUsername, cfPassword, envVars, cliPath)
-			addApi("https://api.eu-gb.bluemix.net", cfUsername, cfPassword, envVars, cliPath)
+			addApi(secondApi, cfUsername, cfPassword, envVars, cliPath)
+			addApi(firstApi, cfUsername, cfPassword, envVars, cliPath)
 
 			session, _ = startSession(envVars, cliPath, "echo", "foobar")
-			expectRunning(session, "cf echo foobar", "https___api.eu-gb.bluemix.net")
+			expectRunning(session, "cf echo foobar", target.Sanitise(firstApi))
 			Eventually(session).Should(Say("foobar"))
-			expectRunning(session, "cf echo foobar", "https___api.run.pivotal.io")
+			expectRunning(session, "cf echo foobar", target.Sanitise(secondApi))
 			Eventually(session).Should(Say("foobar"))
 		})
 	})
@@ -96,7 +123,7 @@ var _ = Describe("cf-plex", func() {
 	Describe("adding apis", func() {
 		Context("when the username and password are absent", func() {
 			It("assumes the user wants interactive login", func() {
-				session, in := startSession(envVars, cliPath, "add-api", "https://api.run.pivotal.io")
+				session, in := startSession(envVars, cliPath, "add-api", secondApi)
 				confirm("Email>", cfUsername, session, in)
 				confirm("Password>", cfPassword, session, in)
 				Eventually(session, timeout).Should(Say("Authenticating...\nOK"))
@@ -105,7 +132,7 @@ var _ = Describe("cf-plex", func() {
 
 		Context("when the username is absent", func() {
 			It("outputs a useful errror message", func() {
-				session, _ := startSession(envVars, cliPath, "add-api", "https://api.run.pivotal.io", cfPassword)
+				session, _ := startSession(envVars, cliPath, "add-api", secondApi, cfPassword)
 				Eventually(session).Should(Say("Usage: " + addUsageMatcher))
 				Eventually(session).Should(Exit(1))
 			})
@@ -113,7 +140,7 @@ var _ = Describe("cf-plex", func() {
 
 		Context("when the password is absent", func() {
 			It("outputs a useful errror message", func() {
-				session, _ := startSession(envVars, cliPath, "add-api", "https://api.run.pivotal.io", cfUsername)
+				session, _ := startSession(envVars, cliPath, "add-api", secondApi, cfUsername)
 				Eventually(session).Should(Say("Usage: " + addUsageMatcher))
 				Eventually(session).Should(Exit(1))
 			})
@@ -129,14 +156,14 @@ var _ = Describe("cf-plex", func() {
 
 		Context("when -g is specified", func() {
 			It("requires a group name", func() {
-				session, _ := startSession(envVars, cliPath, "add-api", "-g", "https://api.run.pivotal.io", cfUsername, cfPassword)
+				session, _ := startSession(envVars, cliPath, "add-api", "-g", secondApi, cfUsername, cfPassword)
 				Eventually(session).Should(Say("Usage: " + addUsageMatcher))
 				Eventually(session).Should(Exit(1))
 			})
 
 			It("adds a group", func() {
-				session, _ := startSession(envVars, cliPath, "add-api", "-g", "nonprod", "https://api.run.pivotal.io", cfUsername, cfPassword)
-				Eventually(session, "5s").Should(Say("Added https://api.run.pivotal.io to group 'nonprod'"))
+				session, _ := startSession(envVars, cliPath, "add-api", "-g", "nonprod", secondApi, cfUsername, cfPassword)
+				Eventually(session, "5s").Should(Say("Added " + secondApi + " to group 'nonprod'"))
 				Eventually(session).Should(Exit(0))
 			})
 		})
@@ -152,7 +179,7 @@ var _ = Describe("cf-plex", func() {
 
 		Context("when -g is specified", func() {
 			It("requires a group name", func() {
-				session, _ := startSession(envVars, cliPath, "remove-api", "-g", "https://api.run.pivotal.io")
+				session, _ := startSession(envVars, cliPath, "remove-api", "-g", secondApi)
 				Eventually(session).Should(Say("Usage: " + removeUsageMatcher))
 				Eventually(session).Should(Exit(1))
 			})
@@ -165,27 +192,27 @@ var _ = Describe("cf-plex", func() {
 			session.Wait("1s")
 			Ω(session.Err).Should(Say("No APIs have been set"))
 
-			addApi("https://api.run.pivotal.io", cfUsername, cfPassword, envVars, cliPath)
-			addApi("https://api.eu-gb.bluemix.net", cfUsername, cfPassword, envVars, cliPath)
+			addApi(secondApi, cfUsername, cfPassword, envVars, cliPath)
+			addApi(firstApi, cfUsername, cfPassword, envVars, cliPath)
 
 			session, _ = startSession(envVars, cliPath, "list-apis")
 			session.Wait("1s")
-			Ω(session.Out).Should(Say("https://api.eu-gb.bluemix.net"), "APIs should be alphabetically listed")
-			Ω(session.Out).Should(Say("https://api.run.pivotal.io"))
+			Ω(session.Out).Should(Say(firstApi), "APIs should be alphabetically listed")
+			Ω(session.Out).Should(Say(secondApi))
 			Ω(string(session.Buffer().Contents())).ShouldNot(ContainSubstring(tmpDir))
 
 			session, in := startSession(envVars, cliPath, "delete-org", "does-not-exist")
-			expectRunning(session, "cf delete-org does-not-exist", "https___api.eu-gb.bluemix.net")
+			expectRunning(session, "cf delete-org does-not-exist", target.Sanitise(firstApi))
 			confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 			Eventually(session, timeout).Should(Say("Delete cancelled"))
 
-			expectRunning(session, "cf delete-org does-not-exist", "https___api.run.pivotal.io")
+			expectRunning(session, "cf delete-org does-not-exist", target.Sanitise(secondApi))
 			confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 			Eventually(session, timeout).Should(Say("Delete cancelled"))
 			Eventually(session).Should(Exit(0))
 
-			removeApi("https://api.run.pivotal.io", envVars, cliPath)
-			removeApi("https://api.eu-gb.bluemix.net", envVars, cliPath)
+			removeApi(secondApi, envVars, cliPath)
+			removeApi(firstApi, envVars, cliPath)
 
 			session, _ = startSession(envVars, cliPath, "apps")
 			Eventually(session.Err).Should(Say("No APIs have been set"))
@@ -197,8 +224,8 @@ var _ = Describe("cf-plex", func() {
 		})
 
 		It("does not run a command after it has failed against one API", func() {
-			addApi("https://api.run.pivotal.io", cfUsername, cfPassword, envVars, cliPath)
-			addApi("https://api.eu-gb.bluemix.net", cfUsername, cfPassword, envVars, cliPath)
+			addApi(secondApi, cfUsername, cfPassword, envVars, cliPath)
+			addApi(firstApi, cfUsername, cfPassword, envVars, cliPath)
 
 			session, _ := startSession(envVars, cliPath, "target", "-s", "does-not-exist")
 			session.Wait()
@@ -208,10 +235,9 @@ var _ = Describe("cf-plex", func() {
 
 		Context("when --force is supplied", func() {
 			It("runs against all targets even if the first command fails", func() {
-				addApi("https://api.run.pivotal.io", cfUsername, cfPassword, envVars, cliPath)
-				addApi("https://api.eu-gb.bluemix.net", cfUsername, cfPassword, envVars, cliPath)
-				// BlueMix has org named after user. Neither public CF allows us to create orgs
-				session, _ := startSession(envVars, cliPath, "target", "-o", "testing@engineerbetter.com", "--force")
+				addApi(secondApi, cfUsername, cfPassword, envVars, cliPath)
+				addApi(firstApi, cfUsername, cfPassword, envVars, cliPath)
+				session, _ := startSession(envVars, cliPath, "target", "-o", orgName, "--force")
 				session.Wait(timeout)
 				output := string(session.Buffer().Contents())
 				Ω(strings.Count(output, "FAILED")).Should(BeNumerically("==", 1))
@@ -224,21 +250,21 @@ var _ = Describe("cf-plex", func() {
 
 		Context("when using default separators", func() {
 			BeforeEach(func() {
-				cfEnvs = cfUsername + "^" + cfPassword + ">https://api.run.pivotal.io;" + cfUsername + "^" + cfPassword + ">https://api.eu-gb.bluemix.net"
+				cfEnvs = cfUsername + "^" + cfPassword + ">" + secondApi + ";" + cfUsername + "^" + cfPassword + ">" + firstApi
 				envVars = append(envVars, "CF_PLEX_APIS="+cfEnvs)
 			})
 
 			It("Runs commands against APIs in ENV, logging in only once", func() {
 				session, in := startSession(envVars, cliPath, "delete-org", "does-not-exist")
-				Eventually(session, timeout).Should(Say("Setting api endpoint to https://api.run.pivotal.io...\nOK"))
+				Eventually(session, timeout).Should(Say("Setting api endpoint to " + secondApi))
 				Eventually(session, timeout).Should(Say("Authenticating...\nOK"))
-				Eventually(session, timeout).Should(Say("Setting api endpoint to https://api.eu-gb.bluemix.net"))
+				Eventually(session, timeout).Should(Say("Setting api endpoint to " + firstApi))
 				Eventually(session, timeout).Should(Say("Authenticating...\nOK"))
-				expectRunning(session, "cf delete-org does-not-exist", "https___api.run.pivotal.io")
+				expectRunning(session, "cf delete-org does-not-exist", batch.Api{Api: secondApi, Username: cfUsername}.Dir())
 				confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 				Eventually(session, timeout).Should(Say("Delete cancelled"))
 
-				expectRunning(session, "cf delete-org does-not-exist", "https___api.eu-gb.bluemix.net")
+				expectRunning(session, "cf delete-org does-not-exist", batch.Api{Api: firstApi, Username: cfUsername}.Dir())
 				confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 				Eventually(session, timeout).Should(Say("Delete cancelled"))
 				Eventually(session).Should(Exit(0))
@@ -249,27 +275,27 @@ var _ = Describe("cf-plex", func() {
 			})
 
 			It("Disallows add-api", func() {
-				session, _ := startSession(envVars, cliPath, "add-api", "https://api.run.pivotal.io")
-				Eventually(session).Should(Say("Managing APIs is not allowed when CF_PLEX_APIS is set"))
+				session, _ := startSession(envVars, cliPath, "add-api", secondApi)
+				Eventually(session).Should(Say("Managing APIs is not allowed when CF_PLEX_APIS or CF_PLEX_APIS_FILE is set"))
 				Eventually(session).Should(Exit(1))
 			})
 
 			It("Disallows list-apis", func() {
 				session, _ := startSession(envVars, cliPath, "list-apis")
-				Eventually(session).Should(Say("Managing APIs is not allowed when CF_PLEX_APIS is set"))
+				Eventually(session).Should(Say("Managing APIs is not allowed when CF_PLEX_APIS or CF_PLEX_APIS_FILE is set"))
 				Eventually(session).Should(Exit(1))
 			})
 
 			It("Disallows remove-api", func() {
-				session, _ := startSession(envVars, cliPath, "remove-api", "https://api.run.pivotal.io")
-				Eventually(session).Should(Say("Managing APIs is not allowed when CF_PLEX_APIS is set"))
+				session, _ := startSession(envVars, cliPath, "remove-api", secondApi)
+				Eventually(session).Should(Say("Managing APIs is not allowed when CF_PLEX_APIS or CF_PLEX_APIS_FILE is set"))
 				Eventually(session).Should(Exit(1))
 			})
 		})
 
 		Context("when custom separators are defined", func() {
 			BeforeEach(func() {
-				cfEnvs = cfUsername + "-foo-" + cfPassword + "_https://api.run.pivotal.io|" + cfUsername + "-foo-" + cfPassword + "_https://api.eu-gb.bluemix.net"
+				cfEnvs = cfUsername + "-foo-" + cfPassword + "_" + secondApi + "|" + cfUsername + "-foo-" + cfPassword + "_" + firstApi
 				envVars = append(envVars, "CF_PLEX_SEP_TRIPLE=|")
 				envVars = append(envVars, "CF_PLEX_SEP_CREDS_API=_")
 				envVars = append(envVars, "CF_PLEX_SEP_USER_PASS=-foo-")
@@ -278,21 +304,37 @@ var _ = Describe("cf-plex", func() {
 
 			It("still works", func() {
 				session, in := startSession(envVars, cliPath, "delete-org", "does-not-exist")
-				Eventually(session, timeout).Should(Say("Setting api endpoint to https://api.run.pivotal.io...\nOK"))
+				Eventually(session, timeout).Should(Say("Setting api endpoint to " + secondApi))
 				Eventually(session, timeout).Should(Say("Authenticating...\nOK"))
-				Eventually(session, timeout).Should(Say("Setting api endpoint to https://api.eu-gb.bluemix.net"))
+				Eventually(session, timeout).Should(Say("Setting api endpoint to " + firstApi))
 				Eventually(session, timeout).Should(Say("Authenticating...\nOK"))
-				expectRunning(session, "cf delete-org does-not-exist", "https___api.run.pivotal.io")
+				expectRunning(session, "cf delete-org does-not-exist", batch.Api{Api: secondApi, Username: cfUsername}.Dir())
 				confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 				Eventually(session, timeout).Should(Say("Delete cancelled"))
 
-				expectRunning(session, "cf delete-org does-not-exist", "https___api.eu-gb.bluemix.net")
+				expectRunning(session, "cf delete-org does-not-exist", batch.Api{Api: firstApi, Username: cfUsername}.Dir())
 				confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 				Eventually(session, timeout).Should(Say("Delete cancelled"))
 				Eventually(session).Should(Exit(0))
 				Ω(string(session.Buffer().Contents())).ShouldNot(ContainSubstring(cfPassword))
 			})
 		})
+
+		It("gives different users of the same API separate CF_HOMEs", func() {
+			cfEnvs = cfUsername + "^" + cfPassword + ">" + firstApi + ";" + otherUsername + "^" + cfPassword + ">" + firstApi
+			session, in := startSession(env.Set("CF_PLEX_APIS", cfEnvs, envVars), cliPath, "delete-org", "does-not-exist")
+			expectRunning(session, "cf delete-org does-not-exist", batch.Api{Api: firstApi, Username: cfUsername}.Dir())
+			confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
+			Eventually(session, timeout).Should(Say("Delete cancelled"))
+
+			expectRunning(session, "cf delete-org does-not-exist", batch.Api{Api: firstApi, Username: otherUsername}.Dir())
+			confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
+			Eventually(session, timeout).Should(Say("Delete cancelled"))
+			Eventually(session).Should(Exit(0))
+
+			Ω(filepath.Join(tmpDir, "groups", "batch", batch.Api{Api: firstApi, Username: cfUsername}.Dir())).Should(BeADirectory())
+			Ω(filepath.Join(tmpDir, "groups", "batch", batch.Api{Api: firstApi, Username: otherUsername}.Dir())).Should(BeADirectory())
+		})
 	})
 
 	Describe("group management", func() {
@@ -303,11 +345,11 @@ var _ = Describe("cf-plex", func() {
 		})
 
 		It("allows groups to be added, listed, run against, and removed", func() {
-			session, _ := startSession(envVars, cliPath, "add-api", "-g", "nonprod", "https://api.run.pivotal.io", cfUsername, cfPassword)
+			session, _ := startSession(envVars, cliPath, "add-api", "-g", "nonprod", secondApi, cfUsername, cfPassword)
 			Eventually(session, "5s").Should(Exit(0))
 			session, _ = startSession(envVars, cliPath, "list-apis")
 			Eventually(session).Should(Say("nonprod"))
-			Eventually(session).Should(Say("\thttps://api.run.pivotal.io"))
+			Eventually(session).Should(Say("\t" + secondApi))
 			Eventually(session).Should(Exit(0))
 
 			session, _ = startSession(envVars, cliPath, "delete-org", "my-org")
@@ -315,59 +357,59 @@ var _ = Describe("cf-plex", func() {
 			Eventually(session.Err).Should(Say("-g <group> is mandatory whenever groups have been added. Use '-g default' to target APIs without an explicit group."))
 
 			session, in := startSession(envVars, cliPath, "-g", "nonprod", "delete-org", "does-not-exist")
-			expectRunning(session, "cf delete-org does-not-exist", "https___api.run.pivotal.io")
+			expectRunning(session, "cf delete-org does-not-exist", target.Sanitise(secondApi))
 			confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 			Eventually(session, timeout).Should(Say("Delete cancelled"))
 			Eventually(session).Should(Exit(0))
 
-			session, _ = startSession(envVars, cliPath, "remove-api", "-g", "nonprod", "https://api.run.pivotal.io")
-			Eventually(session).Should(Say("Removed https://api.run.pivotal.io from 'nonprod'"))
+			session, _ = startSession(envVars, cliPath, "remove-api", "-g", "nonprod", secondApi)
+			Eventually(session).Should(Say("Removed " + secondApi + " from 'nonprod'"))
 			Eventually(session).Should(Exit(0))
 
 			session, _ = startSession(envVars, cliPath, "list-apis")
 			Eventually(session).Should(Exit(0))
 			Eventually(session).ShouldNot(Say("nonprod"))
-			Eventually(session).ShouldNot(Say("\thttps://api.run.pivotal.io"))
+			Eventually(session).ShouldNot(Say("\t" + secondApi))
 		})
 
 		It("does not run commands against APIs not in the group", func() {
-			addApi("https://api.eu-gb.bluemix.net", cfUsername, cfPassword, envVars, cliPath)
-			session, _ := startSession(envVars, cliPath, "add-api", "-g", "nonprod", "https://api.run.pivotal.io", cfUsername, cfPassword)
+			addApi(firstApi, cfUsername, cfPassword, envVars, cliPath)
+			session, _ := startSession(envVars, cliPath, "add-api", "-g", "nonprod", secondApi, cfUsername, cfPassword)
 			Eventually(session, timeout).Should(Exit(0))
 			session, in := startSession(envVars, cliPath, "-g", "nonprod", "delete-org", "does-not-exist")
-			expectRunning(session, "cf delete-org does-not-exist", "https___api.run.pivotal.io")
+			expectRunning(session, "cf delete-org does-not-exist", target.Sanitise(secondApi))
 			confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 			Eventually(session, timeout).Should(Exit(0))
-			Ω(string(session.Buffer().Contents())).ShouldNot(ContainSubstring("api.eu-gb.bluemix.net"))
+			Ω(string(session.Buffer().Contents())).ShouldNot(ContainSubstring(firstApi))
 		})
 
 		It("allows users to target non-group APIs by specifying the default group", func() {
-			addApi("https://api.eu-gb.bluemix.net", cfUsername, cfPassword, envVars, cliPath)
-			session, _ := startSession(envVars, cliPath, "add-api", "-g", "nonprod", "https://api.run.pivotal.io", cfUsername, cfPassword)
+			addApi(firstApi, cfUsername, cfPassword, envVars, cliPath)
+			session, _ := startSession(envVars, cliPath, "add-api", "-g", "nonprod", secondApi, cfUsername, cfPassword)
 			Eventually(session, timeout).Should(Exit(0))
 			session, in := startSession(envVars, cliPath, "-g", "default", "delete-org", "does-not-exist")
-			expectRunning(session, "cf delete-org does-not-exist", "https___api.eu-gb.bluemix.net")
+			expectRunning(session, "cf delete-org does-not-exist", target.Sanitise(firstApi))
 			confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 			Eventually(session, timeout).Should(Exit(0))
-			Ω(string(session.Buffer().Contents())).ShouldNot(ContainSubstring("api.run.pivotal.io"))
+			Ω(string(session.Buffer().Contents())).ShouldNot(ContainSubstring(secondApi))
 		})
 
 		It("does not treat batch APIs as a group", func() {
-			cfEnvs := cfUsername + "^" + cfPassword + ">https://api.eu-gb.bluemix.net"
+			cfEnvs := cfUsername + "^" + cfPassword + ">" + firstApi
 			cfPlexApisEnvVars := append(envVars, "CF_PLEX_APIS="+cfEnvs)
 			session, in := startSession(cfPlexApisEnvVars, cliPath, "delete-org", "does-not-exist")
 			Eventually(session, timeout).Should(Say("Authenticating...\nOK"))
-			expectRunning(session, "cf delete-org does-not-exist", "https___api.eu-gb.bluemix.net")
+			expectRunning(session, "cf delete-org does-not-exist", batch.Api{Api: firstApi, Username: cfUsername}.Dir())
 			confirm("Really delete the org does-not-exist, including its spaces, apps, service instances, routes, private domains and space-scoped service brokers? [yN]:", "n", session, in)
 			Eventually(session).Should(Exit(0))
 
-			session, _ = startSession(envVars, cliPath, "add-api", "-g", "nonprod", "https://api.run.pivotal.io", cfUsername, cfPassword)
+			session, _ = startSession(envVars, cliPath, "add-api", "-g", "nonprod", secondApi, cfUsername, cfPassword)
 			Eventually(session, timeout).Should(Exit(0))
 
 			session, _ = startSession(envVars, cliPath, "list-apis")
 			Eventually(session).Should(Exit(0))
 			Eventually(session).ShouldNot(Say("batch"))
-			Eventually(session).ShouldNot(Say("\thttps://api.eu-gb.bluemix.net"))
+			Eventually(session).ShouldNot(Say("\t" + firstApi))
 		})
 	})
 
@@ -398,7 +440,8 @@ func startSession(envVars []string, args ...string) (*Session, io.Writer) {
 func addApi(api, cfUsername, cfPassword string, envVars []string, cliPath string) {
 	session, _ := startSession(envVars, cliPath, "add-api", api, cfUsername, cfPassword)
 	session.Wait(timeout)
-	Ω(session.Out).Should(Say("Setting api endpoint to " + api + "...\nOK"))
+	Ω(session.Out).Should(Say("Setting api endpoint to " + api))
+	Ω(session.Out).Should(Say("OK"))
 	Ω(session.Out).Should(Say("Authenticating...\nOK"))
 	Ω(string(session.Buffer().Contents())).ShouldNot(ContainSubstring(cfPassword))
 }
@@ -416,10 +459,18 @@ func confirm(expectedPrompt, input string, session *Session, in io.Writer) {
 
 func expectUsage(session *Session) {
 	Eventually(session).Should(Say("Usage:"))
-	Eventually(session).Should(Say("cf-plex \\[-g <group>\\] <cf cli command> \\[--force\\]"))
+	Eventually(session).Should(Say("cf-plex \\[-g <group>\\] \\[-t <name>\\]... \\[--selector <selector>\\] \\[--org <org>\\] \\[--space <space>\\] \\[--parallel <n>\\] \\[--prefix\\] \\[--exit-policy <policy>\\] \\[--output <format>\\] \\[--skip-preflight\\] <cf cli command> \\[--force\\]"))
 	Eventually(session).Should(Say(addUsageMatcher))
 	Eventually(session).Should(Say(listUsageMatcher))
 	Eventually(session).Should(Say(removeUsageMatcher))
+	Eventually(session).Should(Say(renameUsageMatcher))
+	Eventually(session).Should(Say(labelUsageMatcher))
+	Eventually(session).Should(Say(migrateUsageMatcher))
+	Eventually(session).Should(Say(syncUsageMatcher))
+	Eventually(session).Should(Say(statusUsageMatcher))
+	Eventually(session).Should(Say(loginUsageMatcher))
+	Eventually(session).Should(Say(credentialsUsageMatcher))
+	Eventually(session).Should(Say(pruneUsageMatcher))
 }
 
 func expectRunning(session *Session, cmd, api string) {
@@ -0,0 +1,1278 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/env"
+	"github.com/EngineerBetter/cf-plex/fakecc"
+	"github.com/EngineerBetter/cf-plex/fakecf"
+	"github.com/EngineerBetter/cf-plex/lock"
+	"github.com/EngineerBetter/cf-plex/target"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	. "github.com/onsi/gomega/gexec"
+)
+
+var _ = Describe("cf-plex orchestration", func() {
+	var tmpDir string
+	var cliPath string
+	var fakeCfPath string
+	var logPath string
+	var scriptPath string
+	var envVars []string
+
+	var apiOne = "https://api.one.example.com"
+	var apiTwo = "https://api.two.example.com"
+	var apiThree = "https://api.three.example.com"
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "plex-orchestration")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		cliPath, err = Build("github.com/EngineerBetter/cf-plex", buildArgs...)
+		Ω(err).ShouldNot(HaveOccurred())
+		fakeCfPath, err = Build("github.com/EngineerBetter/cf-plex/fakecf/cf")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		logPath = filepath.Join(tmpDir, "invocations")
+		scriptPath = filepath.Join(tmpDir, "script.json")
+		Ω(fakecf.WriteScript(scriptPath, fakecf.Script{})).Should(Succeed())
+
+		envVars = env.Set("CF_PLEX_HOME", filepath.Join(tmpDir, "home"), os.Environ())
+		envVars = env.Set("CF_COLOR", "false", envVars)
+		envVars = env.Set("CF_PLEX_CF_BINARY", fakeCfPath, envVars)
+		envVars = env.Set(fakecf.LogVar, logPath, envVars)
+		envVars = env.Set(fakecf.ScriptVar, scriptPath, envVars)
+		envVars = env.Set(fakecf.PasswordVar, "password", envVars)
+		envVars = env.Set("CF_PLEX_SKIP_PREFLIGHT", "true", envVars)
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(tmpDir)).Should(Succeed())
+	})
+
+	script := func(rules ...fakecf.Rule) {
+		Ω(fakecf.WriteScript(scriptPath, fakecf.Script{Rules: rules})).Should(Succeed())
+	}
+
+	invocationsOf := func(command string) []fakecf.Invocation {
+		invocations, err := fakecf.Invocations(logPath)
+		Ω(err).ShouldNot(HaveOccurred())
+
+		var matching []fakecf.Invocation
+		for _, invocation := range invocations {
+			if len(invocation.Args) > 0 && invocation.Args[0] == command {
+				matching = append(matching, invocation)
+			}
+		}
+		return matching
+	}
+
+	// argsOf is for commands such as logging in to batch APIs, which may run
+	// in any order.
+	argsOf := func(command string) [][]string {
+		var args [][]string
+		for _, invocation := range invocationsOf(command) {
+			args = append(args, invocation.Args)
+		}
+		return args
+	}
+
+	cfHomesOf := func(command string) []string {
+		var cfHomes []string
+		for _, invocation := range invocationsOf(command) {
+			cfHomes = append(cfHomes, filepath.Base(invocation.CfHome))
+		}
+		return cfHomes
+	}
+
+	run := func(args ...string) *Session {
+		session, _ := startSession(envVars, append([]string{cliPath}, args...)...)
+		Eventually(session, timeout).Should(Exit())
+		return session
+	}
+
+	add := func(args ...string) {
+		session := run(append([]string{"add-api"}, args...)...)
+		Ω(session).Should(Exit(0))
+	}
+
+	It("runs commands against every API, using each API's CF_HOME", func() {
+		add(apiOne, "admin", "password")
+		add(apiTwo, "admin", "password")
+
+		session := run("apps")
+		Ω(session).Should(Exit(0))
+		Ω(session.Out).Should(Say("Running 'cf apps' on " + target.Sanitise(apiOne)))
+		Ω(session.Out).Should(Say("Running 'cf apps' on " + target.Sanitise(apiTwo)))
+		Ω(cfHomesOf("apps")).Should(Equal([]string{target.Sanitise(apiOne), target.Sanitise(apiTwo)}))
+	})
+
+	It("does not run a command after it has failed against one API", func() {
+		add(apiOne, "admin", "password")
+		add(apiTwo, "admin", "password")
+		script(fakecf.Rule{Args: []string{"apps"}, CfHome: target.Sanitise(apiOne), Stdout: "FAILED\n", ExitCode: 3})
+
+		session := run("apps")
+		Ω(session).Should(Exit(3))
+		Ω(cfHomesOf("apps")).Should(Equal([]string{target.Sanitise(apiOne)}))
+		Ω(session.Out).Should(Say(`https://api.two.example.com\s+default\s+skipped`))
+	})
+
+	It("runs against every API when --force is supplied", func() {
+		add(apiOne, "admin", "password")
+		add(apiTwo, "admin", "password")
+		script(fakecf.Rule{Args: []string{"apps"}, CfHome: target.Sanitise(apiOne), ExitCode: 3})
+
+		session := run("apps", "--force")
+		Ω(session).Should(Exit(0))
+		Ω(cfHomesOf("apps")).Should(HaveLen(2))
+		Ω(session.Out).Should(Say(`https://api.one.example.com\s+default\s+failed\s+3`))
+
+		session = run("--exit-policy", "any-failed", "apps", "--force")
+		Ω(session).Should(Exit(1))
+	})
+
+	It("runs in parallel, prefixing output with the API", func() {
+		add(apiOne, "admin", "password")
+		add(apiTwo, "admin", "password")
+		add(apiThree, "admin", "password")
+		script(fakecf.Rule{Args: []string{"apps"}, Stdout: "some apps\n", DelayMs: 500})
+
+		session, _ := startSession(envVars, cliPath, "--parallel", "3", "apps")
+		Eventually(session, "1400ms").Should(Exit(0))
+		output := string(session.Out.Contents())
+		Ω(output).Should(ContainSubstring("[" + apiOne + "]   some apps"))
+		Ω(output).Should(ContainSubstring("[" + apiTwo + "]   some apps"))
+		Ω(output).Should(ContainSubstring("[" + apiThree + "] some apps"))
+	})
+
+	It("shows prompts when prefixing the output of one API at a time", func() {
+		add(apiOne, "admin", "password")
+
+		session, in := startSession(envVars, cliPath, "--prefix", "--skip-preflight", "login", "-a", apiOne)
+		Eventually(session.Out, timeout).Should(Say(`\[` + apiOne + `\] Email> `))
+		_, err := in.Write([]byte("admin\n"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Eventually(session.Out, timeout).Should(Say(`Password> `))
+		_, err = in.Write([]byte("password\n"))
+		Ω(err).ShouldNot(HaveOccurred())
+		Eventually(session, timeout).Should(Exit(0))
+	})
+
+		It("cancels running commands once one has failed", func() {
+		add(apiOne, "admin", "password")
+		add(apiTwo, "admin", "password")
+		script(
+			fakecf.Rule{Args: []string{"apps"}, CfHome: target.Sanitise(apiOne), ExitCode: 1},
+			fakecf.Rule{Args: []string{"apps"}, DelayMs: 5000},
+		)
+
+		session, _ := startSession(envVars, cliPath, "--parallel", "2", "apps")
+		Eventually(session, "3s").Should(Exit(1))
+		Ω(session.Out).Should(Say(`https://api.one.example.com\s+default\s+failed`))
+		Ω(session.Out).Should(Say(`https://api.two.example.com\s+default\s+cancelled`))
+	})
+
+	It("only runs against APIs in the given group", func() {
+		add("-g", "nonprod", apiOne, "admin", "password")
+		add("-g", "prod", apiTwo, "admin", "password")
+
+		session := run("-g", "nonprod", "apps")
+		Ω(session).Should(Exit(0))
+		Ω(cfHomesOf("apps")).Should(Equal([]string{target.Sanitise(apiOne)}))
+	})
+
+	It("writes JSON describing each API's result", func() {
+		add(apiOne, "admin", "password")
+		script(fakecf.Rule{Args: []string{"apps"}, Stdout: "some apps\n", Stderr: "a warning\n"})
+
+		session := run("--output", "jsonl", "apps")
+		Ω(session).Should(Exit(0))
+		output := string(session.Out.Contents())
+		Ω(output).Should(HavePrefix(`{"target":"` + apiOne + `","group":"default","args":["cf","apps"],"exit_code":0,"stdout":"some apps\n","stderr":"a warning\n"`))
+		Ω(output).ShouldNot(ContainSubstring("Running"))
+	})
+
+	Describe("named targets", func() {
+		It("allows the same API to be added under different names", func() {
+			add("--name", "admin", apiOne, "admin", "password")
+			add("--name", "developer", apiOne, "admin", "password")
+
+			session := run("list-apis")
+			Ω(session.Out).Should(Say(`admin\s+` + apiOne))
+			Ω(session.Out).Should(Say(`developer\s+` + apiOne))
+
+			session = run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{"admin", "developer"}))
+		})
+
+		It("refuses to reuse a name for a different API", func() {
+			add("--name", "admin", apiOne, "admin", "password")
+
+			session := run("add-api", "--name", "admin", apiTwo, "admin", "password")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("Name admin is already used for " + apiOne))
+		})
+
+		It("selects targets by name or URL with -t", func() {
+			add("-g", "prod", "--name", "admin", apiOne, "admin", "password")
+			add("-g", "nonprod", apiTwo, "admin", "password")
+			add("-g", "nonprod", apiThree, "admin", "password")
+
+			session := run("-t", "admin", "-t", apiThree, "apps")
+			Ω(session).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{target.Sanitise(apiThree), "admin"}))
+
+			session = run("-t", "missing", "apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Err).Should(Say("Target 'missing' not recognised"))
+		})
+
+		It("removes and renames targets by name", func() {
+			add("--name", "admin", apiOne, "admin", "password")
+			add(apiTwo, "admin", "password")
+
+			session := run("rename-api", apiTwo, "two")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("Renamed " + apiTwo + " to two"))
+
+			session = run("remove-api", "admin")
+			Ω(session).Should(Exit(0))
+
+			session = run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{"two"}))
+		})
+
+		It("migrates APIs added by older versions", func() {
+			Ω(os.MkdirAll(filepath.Join(tmpDir, "home", target.Sanitise(apiOne)), 0700)).Should(Succeed())
+
+			session := run("migrate")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("Migrated " + apiOne + " in group 'default'"))
+
+			session = run("migrate")
+			Ω(session.Out).Should(Say("Nothing to migrate"))
+		})
+	})
+
+	Describe("labels", func() {
+		BeforeEach(func() {
+			add("-g", "prod", "--label", "env=prod", "--label", "region=eu", apiOne, "admin", "password")
+			add("-g", "nonprod", "--label", "env=dev", "--label", "region=us", apiTwo, "admin", "password")
+			add("-g", "nonprod", "--label", "env=dev", "--label", "region=ap", apiThree, "admin", "password")
+		})
+
+		It("runs commands against APIs in any group matching a selector", func() {
+			session := run("--selector", "env!=prod,region in (eu,us)", "apps")
+			Ω(session).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{target.Sanitise(apiTwo)}))
+		})
+
+		It("fails when no APIs match", func() {
+			session := run("--selector", "env=staging", "apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Err).Should(Say("No APIs match the selector"))
+			Ω(invocationsOf("apps")).Should(BeEmpty())
+		})
+
+		It("lists only matching APIs", func() {
+			session := run("list-apis", "--selector", "region notin (eu)", "--output", "jsonl")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`"name":"` + apiThree + `"`))
+			Ω(session.Out).Should(Say(`"name":"` + apiTwo + `".*"labels":{"env":"dev","region":"us"}`))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring(apiOne))
+		})
+
+		It("changes labels", func() {
+			session := run("label", "-g", "prod", apiOne, "env=staging", "region-")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("Labelled " + apiOne + ": env=staging"))
+
+			session = run("list-apis")
+			Ω(session.Out).Should(Say(apiOne + `\s+env=staging\s+admin\n`))
+
+			session = run("--selector", "env=staging", "apps")
+			Ω(session).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{target.Sanitise(apiOne)}))
+		})
+
+		It("rejects invalid selectors and labels", func() {
+			session := run("--selector", "env in prod", "apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("selector 'env in prod' is invalid"))
+
+			session = run("label", "-g", "prod", apiOne, "env")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("label env is invalid"))
+		})
+	})
+
+	Describe("syncing with an inventory", func() {
+		var inventoryPath string
+
+		BeforeEach(func() {
+			inventoryPath = filepath.Join(tmpDir, "inventory.yml")
+			Ω(ioutil.WriteFile(inventoryPath, []byte(`
+groups:
+  prod:
+  - api: `+apiOne+`
+    name: one
+    labels: {env: prod}
+  - api: `+apiTwo+`
+`), 0600)).Should(Succeed())
+		})
+
+		It("reports what would change without changing anything", func() {
+			add("-g", "old", apiThree, "admin", "password")
+
+			session := run("sync", "--dry-run", inventoryPath)
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`create\s+prod/one\s+` + apiOne))
+			Ω(session.Out).Should(Say(`create\s+prod/` + apiTwo + `\s+` + apiTwo))
+			Ω(session.Out).Should(Say(`remove\s+old/` + apiThree))
+			Ω(session.Out).Should(Say("Dry run: 3 change"))
+
+			session = run("list-apis")
+			Ω(session.Out).Should(Say(apiThree))
+		})
+
+		It("makes the targets match the inventory", func() {
+			add("-g", "old", apiThree, "admin", "password")
+
+			session := run("sync", inventoryPath)
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("3 change"))
+
+			session = run("list-apis")
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring(apiThree))
+			Ω(session.Out).Should(Say(`one\s+` + apiOne + `\s+env=prod`))
+
+			session = run("sync", inventoryPath)
+			Ω(session.Out).Should(Say("Already in sync"))
+		})
+	})
+
+	It("skips SSL validation when asked to", func() {
+		add("--skip-ssl-validation", apiOne, "admin", "password")
+
+		config, err := fakecf.ReadConfig(filepath.Join(tmpDir, "home", target.Sanitise(apiOne)))
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(config.SSLDisabled).Should(BeTrue())
+	})
+
+	Describe("pre-flight checks", func() {
+		var foundations []*httptest.Server
+		var reachable, unreachable string
+
+		BeforeEach(func() {
+			envVars = env.Set("CF_PLEX_SKIP_PREFLIGHT", "", envVars)
+
+			foundations = []*httptest.Server{httptest.NewServer(nil), httptest.NewServer(nil)}
+			for _, foundation := range foundations {
+				fakecc.Configure(foundation.Config, fakecc.Foundation{Addr: foundation.URL})
+			}
+			reachable = foundations[0].URL
+			unreachable = foundations[1].URL
+
+			add("--name", "reachable", reachable, "admin", "password")
+			add("--name", "unreachable", unreachable, "admin", "password")
+		})
+
+		AfterEach(func() {
+			for _, foundation := range foundations {
+				foundation.Close()
+			}
+		})
+
+		It("runs commands when every API is ready", func() {
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(invocationsOf("oauth-token")).Should(BeEmpty(), "should check tokens without running cf")
+			Ω(cfHomesOf("apps")).Should(HaveLen(2))
+		})
+
+		It("runs nothing, and lists the APIs needing attention, when any are not ready", func() {
+			Ω(run("-t", "reachable", "logout")).Should(Exit(0))
+			foundations[1].Close()
+
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Err).Should(Say("Pre-flight check failed"))
+			Ω(session.Err).Should(Say(`reachable\s+not logged in, or token has expired`))
+			Ω(session.Err).Should(Say(`unreachable\s+API unreachable`))
+			Ω(invocationsOf("apps")).Should(BeEmpty())
+		})
+
+		It("shows the status of every API", func() {
+			Ω(run("-t", "reachable", "logout")).Should(Exit(0))
+			foundations[1].Close()
+
+			session := run("status")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`TARGET\s+GROUP\s+API\s+USER`))
+			Ω(session.Out).Should(Say(`reachable\s+default\s+` + reachable + `\s+-\s+-\s+none\s+` + fakecc.APIVersion + `\s+yes\s+\d`))
+			Ω(session.Out).Should(Say(`unreachable\s+default\s+` + unreachable + `\s+admin\s+-\s+valid for \S+\s+-\s+no\s+-\s+API unreachable`))
+
+			session = run("status", "-t", "unreachable", "--output", "json")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`"needs_attention": true`))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring(`"target": "reachable"`))
+		})
+
+		It("can be skipped", func() {
+			foundations[1].Close()
+
+			session := run("--skip-preflight", "apps")
+			Ω(session).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(HaveLen(2))
+		})
+	})
+
+	Describe("logging in again", func() {
+		expire := func(api string) {
+			cfHome := filepath.Join(tmpDir, "home", target.Sanitise(api))
+			config, err := fakecf.ReadConfig(cfHome)
+			Ω(err).ShouldNot(HaveOccurred())
+			config.AccessToken = fakecc.AccessToken(api, "admin", time.Now().Add(-time.Minute))
+			config.RefreshToken = ""
+			Ω(fakecf.WriteConfig(cfHome, config)).Should(Succeed())
+		}
+
+		It("only logs in to APIs without a valid session, using their credentials references", func() {
+			add("--credentials", "env:PLEX_ONE", apiOne, "admin", "password")
+			add(apiTwo, "admin", "password")
+			expire(apiOne)
+			envVars = env.Set("PLEX_ONE_USERNAME", "admin", envVars)
+			envVars = env.Set("PLEX_ONE_PASSWORD", "password", envVars)
+
+			session := run("login")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("Already logged in to " + apiTwo))
+			Ω(cfHomesOf("auth")).Should(Equal([]string{target.Sanitise(apiOne), target.Sanitise(apiTwo), target.Sanitise(apiOne)}))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring("password"))
+
+			session = run("status", "-t", apiOne)
+			Ω(session.Out).Should(Say(`valid for`))
+		})
+
+		It("logs in as a client", func() {
+			add("--credentials", "env:PLEX_ONE", apiOne, "admin", "password")
+			envVars = env.Set("PLEX_ONE_CLIENT_ID", "pipeline", envVars)
+			envVars = env.Set("PLEX_ONE_CLIENT_SECRET", "password", envVars)
+
+			Ω(run("login", "--force")).Should(Exit(0))
+			auths := invocationsOf("auth")
+			Ω(auths[len(auths)-1].Args).Should(Equal([]string{"auth", "pipeline", "password", "--client-credentials"}))
+		})
+
+		It("prompts for a passcode for each API when using SSO", func() {
+			add(apiOne, "admin", "password")
+			add(apiTwo, "admin", "password")
+			expire(apiOne)
+			expire(apiTwo)
+
+			session, in := startSession(envVars, cliPath, "login", "--sso")
+			confirm("Temporary Authentication Code", "passcode", session, in)
+			confirm("Temporary Authentication Code", "passcode", session, in)
+			Eventually(session, timeout).Should(Exit(0))
+			Ω(cfHomesOf("login")).Should(Equal([]string{target.Sanitise(apiOne), target.Sanitise(apiTwo)}))
+		})
+
+		It("carries on past APIs that fail, and reports them", func() {
+			add("--credentials", "env:PLEX_MISSING", apiOne, "admin", "password")
+			add("--credentials", "env:PLEX_TWO", apiTwo, "admin", "password")
+			envVars = env.Set("PLEX_TWO_USERNAME", "admin", envVars)
+			envVars = env.Set("PLEX_TWO_PASSWORD", "password", envVars)
+
+			session := run("login", "--force")
+			Ω(session).Should(Exit(1))
+			Ω(session.Err).Should(Say("Could not log in to " + apiOne + ": PLEX_MISSING_USERNAME"))
+			Ω(session.Err).Should(Say("Logging in failed for: " + apiOne + "\n"))
+			Ω(cfHomesOf("auth")).Should(Equal([]string{target.Sanitise(apiOne), target.Sanitise(apiTwo), target.Sanitise(apiTwo)}))
+		})
+	})
+
+	Describe("the credential store", func() {
+		BeforeEach(func() {
+			envVars = env.Set("CF_PLEX_PASSPHRASE", "passphrase", envVars)
+		})
+
+		It("saves credentials when adding an API, and uses them to log in again", func() {
+			add("--save-credentials", apiOne, "admin", "password")
+
+			session := run("credentials", "list")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`API\s+USERNAME\n`))
+			Ω(session.Out).Should(Say(apiOne + `\s+admin\n`))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring("password"))
+
+			Ω(run("login", "--force")).Should(Exit(0))
+			auths := invocationsOf("auth")
+			Ω(auths).Should(HaveLen(2))
+			Ω(auths[1].Args).Should(Equal([]string{"auth", "admin", "password"}))
+		})
+
+		It("is used by batch mode for APIs without a password", func() {
+			add("--save-credentials", apiOne, "admin", "password")
+
+			envVars = append(envVars, "CF_PLEX_APIS=admin^>"+apiOne)
+			Ω(run("apps")).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{"admin@" + target.Sanitise(apiOne)}))
+			Ω(invocationsOf("auth")[1].Args).Should(Equal([]string{"auth", "admin", "password"}))
+		})
+
+		It("rotates and deletes entries", func() {
+			add("--save-credentials", apiOne, "admin", "password")
+
+			session, in := startSession(envVars, cliPath, "credentials", "rotate", apiOne, "admin")
+			_, err := in.Write([]byte("new-password\n"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Eventually(session, timeout).Should(Exit(0))
+			Ω(session.Out).Should(Say("Changed the password saved for admin at " + apiOne))
+			envVars = env.Set(fakecf.PasswordVar, "new-password", envVars)
+			Ω(run("login", "--force")).Should(Exit(0))
+
+			envVars = env.Set("CF_PLEX_NEW_PASSWORD", "newer-password", envVars)
+			Ω(run("credentials", "rotate", apiOne, "admin")).Should(Exit(0))
+			envVars = env.Set(fakecf.PasswordVar, "newer-password", envVars)
+			Ω(run("login", "--force")).Should(Exit(0))
+
+			session = run("credentials", "delete", apiOne, "admin")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("Deleted the password saved for admin at " + apiOne))
+			Ω(run("credentials", "list").Out).ShouldNot(Say(apiOne))
+
+			session = run("credentials", "delete", apiOne, "admin")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("No password is saved for admin at " + apiOne))
+		})
+
+		It("checks its arguments before opening the store", func() {
+			add("--save-credentials", apiOne, "admin", "password")
+
+			envVars = env.Set("CF_PLEX_PASSPHRASE", "wrong", envVars)
+			session := run("credentials", "rotate", apiOne, "admin", "new-password")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("Usage: cf-plex credentials"))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring("could not be decrypted"))
+		})
+
+		It("is encrypted with a new passphrase when re-keyed", func() {
+			add("--save-credentials", apiOne, "admin", "password")
+
+			session := run("credentials", "rekey")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("set CF_PLEX_NEW_PASSPHRASE or CF_PLEX_NEW_KEY_FILE"))
+
+			envVars = env.Set("CF_PLEX_NEW_PASSPHRASE", "new-passphrase", envVars)
+			session = run("credentials", "rekey")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("Encrypted the credential store with the new passphrase or key file"))
+
+			Ω(run("credentials", "list")).Should(Exit(1))
+			envVars = env.Set("CF_PLEX_PASSPHRASE", "new-passphrase", envVars)
+			Ω(run("credentials", "list").Out).Should(Say(apiOne + `\s+admin`))
+		})
+
+				It("cannot be opened without the passphrase", func() {
+			add("--save-credentials", apiOne, "admin", "password")
+
+			envVars = env.Set("CF_PLEX_PASSPHRASE", "wrong", envVars)
+			session := run("credentials", "list")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("could not be decrypted"))
+		})
+	})
+
+	Describe("clients and origins", func() {
+		It("adds APIs as UAA clients", func() {
+			envVars = env.Set("CF_PLEX_PASSPHRASE", "passphrase", envVars)
+			add("--client-credentials", "pipeline", "password", "--save-credentials", apiOne)
+			Ω(invocationsOf("auth")[0].Args).Should(Equal([]string{"auth", "pipeline", "password", "--client-credentials"}))
+
+			envVars = env.Set("CF_PLEX_NEW_PASSWORD", "password", envVars)
+			Ω(run("credentials", "rotate", apiOne, "pipeline")).Should(Exit(0))
+			Ω(run("login", "--force")).Should(Exit(0))
+			Ω(invocationsOf("auth")[1].Args).Should(Equal([]string{"auth", "pipeline", "password", "--client-credentials"}))
+		})
+
+		It("refuses both a username and client credentials", func() {
+			session := run("add-api", "--client-credentials", "pipeline", "password", apiOne, "admin", "password")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("Usage: "))
+		})
+
+		It("logs users in with an origin", func() {
+			add("--origin", "ldap", "--credentials", "env:PLEX_ONE", apiOne, "admin", "password")
+			Ω(invocationsOf("auth")[0].Args).Should(Equal([]string{"auth", "admin", "password", "--origin", "ldap"}))
+
+			envVars = env.Set("PLEX_ONE_USERNAME", "admin", envVars)
+			envVars = env.Set("PLEX_ONE_PASSWORD", "password", envVars)
+			Ω(run("login", "--force")).Should(Exit(0))
+			Ω(invocationsOf("auth")[1].Args).Should(Equal([]string{"auth", "admin", "password", "--origin", "ldap"}))
+		})
+
+		It("reads the auth type of each API in batch mode", func() {
+			envVars = append(envVars, "CF_PLEX_APIS=client-credentials|pipeline^password>"+apiOne+";origin:ldap|admin^password>"+apiTwo)
+			Ω(run("apps")).Should(Exit(0))
+
+			Ω(argsOf("auth")).Should(ConsistOf(
+				[]string{"auth", "pipeline", "password", "--client-credentials"},
+				[]string{"auth", "admin", "password", "--origin", "ldap"},
+			))
+
+			Ω(run("apps")).Should(Exit(0))
+			Ω(invocationsOf("auth")).Should(HaveLen(2))
+		})
+	})
+
+	Describe("targeting orgs and spaces", func() {
+		It("targets each API's default org and space before running a command", func() {
+			add("--org", "system", "--space", "ops", apiOne, "admin", "password")
+			add("--org", "platform", apiTwo, "admin", "password")
+
+			Ω(run("apps")).Should(Exit(0))
+			targets := invocationsOf("target")
+			Ω(targets).Should(HaveLen(2))
+			Ω(targets[0].Args).Should(Equal([]string{"target", "-o", "system", "-s", "ops"}))
+			Ω(targets[1].Args).Should(Equal([]string{"target", "-o", "platform"}))
+
+			Ω(run("apps")).Should(Exit(0))
+			Ω(invocationsOf("target")).Should(HaveLen(2), "should not target again when already there")
+
+			Ω(run("target", "-o", "other")).Should(Exit(0))
+			Ω(invocationsOf("target")).Should(HaveLen(4), "should not target before running cf target")
+		})
+
+		It("overrides the defaults from the command line, using each API's mappings", func() {
+			add("--org", "system", "--map-org", "prod=production", "--map-space", "web=web-apps", apiOne, "admin", "password")
+			add(apiTwo, "admin", "password")
+
+			Ω(run("--org", "prod", "--space", "web", "apps")).Should(Exit(0))
+			targets := invocationsOf("target")
+			Ω(targets[0].Args).Should(Equal([]string{"target", "-o", "production", "-s", "web-apps"}))
+			Ω(targets[1].Args).Should(Equal([]string{"target", "-o", "prod", "-s", "web"}))
+			Ω(invocationsOf("apps")).Should(HaveLen(2))
+		})
+
+		It("does not run the command when the org cannot be targeted", func() {
+			add("--org", "missing", apiOne, "admin", "password")
+			script(fakecf.Rule{Args: []string{"target"}, Stdout: "FAILED\n", ExitCode: 1})
+
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("cf target -o missing exited with 1"))
+			Ω(invocationsOf("apps")).Should(BeEmpty())
+		})
+
+		It("refuses a space without an org, or an invalid mapping", func() {
+			session := run("add-api", "--space", "ops", apiOne, "admin", "password")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("--space needs --org"))
+
+			session = run("add-api", "--map-org", "prod", apiOne, "admin", "password")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("mapping prod is invalid"))
+		})
+
+		It("reads mappings from batch documents", func() {
+			envVars = append(envVars, `CF_PLEX_APIS=[{"api": "`+apiOne+`", "username": "admin", "password": "password", "org_map": {"prod": "production"}}]`)
+			Ω(run("--org", "prod", "apps")).Should(Exit(0))
+			Ω(invocationsOf("target")[0].Args).Should(Equal([]string{"target", "-o", "production"}))
+		})
+	})
+
+	Describe("credential helpers", func() {
+		var helperPath string
+
+		BeforeEach(func() {
+			helperPath = filepath.Join(tmpDir, "helper")
+			script := "#!/bin/sh\necho \"$2\" >> " + filepath.Join(tmpDir, "helped") + "\necho '{\"username\": \"admin\", \"password\": \"password\"}'\n"
+			Ω(ioutil.WriteFile(helperPath, []byte(script), 0700)).Should(Succeed())
+		})
+
+		helped := func() string {
+			bytes, err := ioutil.ReadFile(filepath.Join(tmpDir, "helped"))
+			Ω(err).ShouldNot(HaveOccurred())
+			return string(bytes)
+		}
+
+		It("asks the helper for credentials when adding an API and logging in again", func() {
+			add("--credential-helper", helperPath, apiOne)
+			Ω(helped()).Should(Equal(apiOne + "\n"))
+
+			Ω(run("login", "--force")).Should(Exit(0))
+			Ω(helped()).Should(Equal(apiOne + "\n" + apiOne + "\n"))
+			Ω(invocationsOf("auth")).Should(HaveLen(2))
+		})
+
+		It("uses the helper configured for the group", func() {
+			add("-g", "vault", "--group-credential-helper", helperPath, apiOne)
+			add("-g", "vault", apiTwo)
+
+			Ω(helped()).Should(Equal(apiOne + "\n" + apiTwo + "\n"))
+		})
+
+		It("is used by batch mode for APIs without a password", func() {
+			envVars = append(envVars, "CF_PLEX_APIS=admin^>"+apiOne, "CF_PLEX_CREDENTIAL_HELPER="+helperPath)
+			Ω(run("apps")).Should(Exit(0))
+			Ω(helped()).Should(Equal(apiOne + "\n"))
+			Ω(invocationsOf("auth")[0].Args).Should(Equal([]string{"auth", "admin", "password"}))
+		})
+
+		It("is read from batch documents", func() {
+			envVars = append(envVars, `CF_PLEX_APIS=[{"api": "`+apiOne+`", "username": "admin", "credential_helper": "`+helperPath+`"}]`)
+			Ω(run("apps")).Should(Exit(0))
+			Ω(helped()).Should(Equal(apiOne + "\n"))
+			Ω(invocationsOf("auth")[0].Args).Should(Equal([]string{"auth", "admin", "password"}))
+		})
+
+		It("makes batch mode log in again when the credentials it gives change", func() {
+			envVars = append(envVars, "CF_PLEX_APIS=admin^>"+apiOne, "CF_PLEX_CREDENTIAL_HELPER="+helperPath)
+			Ω(run("apps")).Should(Exit(0))
+			Ω(run("apps")).Should(Exit(0))
+			Ω(invocationsOf("auth")).Should(HaveLen(1))
+
+			script := "#!/bin/sh\necho '{\"username\": \"admin\", \"password\": \"rotated\"}'\n"
+			Ω(ioutil.WriteFile(helperPath, []byte(script), 0700)).Should(Succeed())
+			envVars = env.Set(fakecf.PasswordVar, "rotated", envVars)
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("The credentials for " + apiOne + " have changed, so logging in again"))
+			Ω(invocationsOf("auth")).Should(HaveLen(2))
+		})
+	})
+
+	It("shows who is logged in to each API", func() {
+		add(apiOne, "admin", "password")
+		add(apiTwo, "admin", "password")
+		Ω(run("-t", apiTwo, "logout")).Should(Exit(0))
+
+		session := run("list-apis")
+		Ω(session.Out).Should(Say(apiOne + `\s+admin\n`))
+		Ω(session.Out).Should(Say(apiTwo + `\s+not logged in\n`))
+
+		session = run("list-apis", "--output", "jsonl")
+		Ω(session.Out).Should(Say(`"session":{"api":"` + apiOne + `","user":"admin","token_expiry":"[^"]+","valid":true}`))
+		Ω(session.Out).Should(Say(`"session":{"api":"` + apiTwo + `","valid":false}`))
+	})
+
+	Describe("choosing cf binaries", func() {
+		var otherCfPath string
+
+		BeforeEach(func() {
+			otherCfPath = filepath.Join(tmpDir, "cf7")
+			bytes, err := ioutil.ReadFile(fakeCfPath)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(ioutil.WriteFile(otherCfPath, bytes, 0700)).Should(Succeed())
+		})
+
+		binariesOf := func(command string) []string {
+			var binaries []string
+			for _, invocation := range invocationsOf(command) {
+				binaries = append(binaries, invocation.Binary)
+			}
+			return binaries
+		}
+
+		It("uses the binary recorded for each API", func() {
+			add("-g", "mixed", "--cf-binary", otherCfPath, apiOne, "admin", "password")
+			add("-g", "mixed", apiTwo, "admin", "password")
+
+			session := run("-g", "mixed", "apps")
+			Ω(session).Should(Exit(0))
+			Ω(binariesOf("apps")).Should(Equal([]string{otherCfPath, fakeCfPath}))
+
+			session = run("list-apis")
+			Ω(session.Out).Should(Say(apiOne + `\s+\(cf: ` + otherCfPath + `\)`))
+		})
+
+		It("falls back to the binary recorded for the group", func() {
+			add("-g", "mixed", "--group-cf-binary", otherCfPath, apiOne, "admin", "password")
+			add("-g", "mixed", apiTwo, "admin", "password")
+
+			session := run("-g", "mixed", "apps")
+			Ω(session).Should(Exit(0))
+			Ω(binariesOf("apps")).Should(Equal([]string{otherCfPath, otherCfPath}))
+		})
+
+		It("finds binaries by version on the PATH", func() {
+			envVars = env.Set("PATH", tmpDir+string(os.PathListSeparator)+os.Getenv("PATH"), envVars)
+			add("--cf-version", "7", apiOne, "admin", "password")
+
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(binariesOf("apps")).Should(Equal([]string{otherCfPath}))
+		})
+
+		It("warns when an API was last used by a different major version", func() {
+			add(apiOne, "admin", "password")
+
+			envVars = env.Set(fakecf.VersionVar, "7.2.0+fake", envVars)
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(session.Err).Should(Say("Warning: " + apiOne + " was last used with cf v6 but is now using v7"))
+
+			session = run("apps")
+			Ω(session.Err).ShouldNot(Say("Warning"))
+		})
+	})
+
+	Describe("batch mode", func() {
+		BeforeEach(func() {
+			envVars = append(envVars, "CF_PLEX_APIS=admin^password>"+apiOne+";admin^password>"+apiTwo)
+		})
+
+		It("logs in to each API only once", func() {
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(invocationsOf("auth")).Should(HaveLen(2))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{"admin@" + target.Sanitise(apiOne), "admin@" + target.Sanitise(apiTwo)}))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring("password"))
+
+			session = run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(invocationsOf("auth")).Should(HaveLen(2))
+			Ω(invocationsOf("api")).Should(HaveLen(2), "should not need cf to tell whether it is logged in")
+		})
+
+		It("logs in again when the user changes", func() {
+			Ω(run("apps")).Should(Exit(0))
+
+			envVars = env.Set("CF_PLEX_APIS", "someone-else^password>"+apiOne+";admin^password>"+apiTwo, envVars)
+			Ω(run("apps")).Should(Exit(0))
+			Ω(invocationsOf("auth")).Should(HaveLen(3))
+		})
+
+		It("keeps the sessions of different users of the same API apart", func() {
+			envVars = env.Set("CF_PLEX_APIS", "admin^password>"+apiOne+";someone-else^password>"+apiOne, envVars)
+			Ω(run("apps")).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{"admin@" + target.Sanitise(apiOne), "someone-else@" + target.Sanitise(apiOne)}))
+
+			Ω(run("apps")).Should(Exit(0))
+			Ω(invocationsOf("auth")).Should(HaveLen(2))
+		})
+
+		It("logs in again when the password changes", func() {
+			Ω(run("apps")).Should(Exit(0))
+
+			metadata, err := target.ReadMetadata(filepath.Join(tmpDir, "home", "groups", "batch", "admin@"+target.Sanitise(apiOne)))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(metadata.Fingerprint).ShouldNot(BeEmpty())
+			Ω(metadata.Fingerprint).ShouldNot(ContainSubstring("password"))
+
+			envVars = env.Set(fakecf.PasswordVar, "rotated", envVars)
+			envVars = env.Set("CF_PLEX_APIS", "admin^rotated>"+apiOne+";admin^rotated>"+apiTwo, envVars)
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say("The credentials for " + apiOne + " have changed, so logging in again"))
+			Ω(invocationsOf("auth")).Should(HaveLen(4))
+			Ω(invocationsOf("api")).Should(HaveLen(2), "should not set the API again")
+		})
+
+		It("logs in again when the token has expired and cannot be refreshed", func() {
+			Ω(run("apps")).Should(Exit(0))
+
+			cfHome := filepath.Join(tmpDir, "home", "groups", "batch", "admin@"+target.Sanitise(apiOne))
+			config, err := fakecf.ReadConfig(cfHome)
+			Ω(err).ShouldNot(HaveOccurred())
+			config.AccessToken = fakecc.AccessToken(apiOne, "admin", time.Now().Add(-time.Minute))
+			config.RefreshToken = ""
+			Ω(fakecf.WriteConfig(cfHome, config)).Should(Succeed())
+
+			Ω(run("apps")).Should(Exit(0))
+			Ω(invocationsOf("auth")).Should(HaveLen(3))
+		})
+
+		It("stops when logging in fails", func() {
+			envVars = env.Set(fakecf.PasswordVar, "different", envVars)
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(invocationsOf("apps")).Should(BeEmpty())
+		})
+
+		It("tries to log in to every API, and reports each that failed", func() {
+			script(fakecf.Rule{Args: []string{"auth"}, CfHome: "admin@" + target.Sanitise(apiOne), Stdout: "Authenticating...\nFAILED\n", ExitCode: 1})
+
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(cfHomesOf("auth")).Should(ConsistOf("admin@"+target.Sanitise(apiOne), "admin@"+target.Sanitise(apiTwo)))
+			Ω(session.Err).Should(Say("Could not log in to " + apiOne + ": cf auth exited with 1"))
+			Ω(session.Err).Should(Say("Logging in failed for: " + apiOne + "\n"))
+			Ω(invocationsOf("apps")).Should(BeEmpty())
+
+			script()
+			Ω(run("apps")).Should(Exit(0))
+			Ω(cfHomesOf("auth")[2:]).Should(Equal([]string{"admin@" + target.Sanitise(apiOne)}), "should only log in again where it failed")
+		})
+
+		It("logs in to several APIs at once without interleaving their output", func() {
+			envVars = env.Set("CF_PLEX_LOGIN_PARALLEL", "2", envVars)
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+
+			banners := regexp.MustCompile(`Running '(cf \w+).*' on (.*)`).FindAllStringSubmatch(string(session.Out.Contents()), 4)
+			Ω(banners).Should(HaveLen(4))
+			for index := 0; index < 4; index += 2 {
+				Ω(banners[index][1:]).Should(Equal([]string{"cf api", banners[index][2]}))
+				Ω(banners[index+1][1:]).Should(Equal([]string{"cf auth", banners[index][2]}), "should keep the output of each login together")
+			}
+		})
+
+		It("keeps both of cf's streams when logging in to several APIs at once", func() {
+			envVars = env.Set("CF_PLEX_LOGIN_PARALLEL", "2", envVars)
+			script(fakecf.Rule{Args: []string{"auth"}, Stdout: "Authenticating...\nOK\n", Stderr: "Warning: the token will expire soon\n"})
+
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(strings.Count(string(session.Out.Contents()), "Authenticating...\nOK\n")).Should(Equal(2))
+			Ω(strings.Count(string(session.Out.Contents()), "Warning: the token will expire soon\n")).Should(Equal(2))
+		})
+
+		It("refuses an invalid CF_PLEX_LOGIN_PARALLEL", func() {
+			envVars = env.Set("CF_PLEX_LOGIN_PARALLEL", "0", envVars)
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("CF_PLEX_LOGIN_PARALLEL must be a positive number"))
+		})
+	})
+
+	Describe("batch mode with a document", func() {
+		apis := `[
+  {"api": "` + apiOne + `", "username": "admin", "password": "password", "org": "system", "space": "ops", "labels": {"env": "prod"}},
+  {"api": "` + apiOne + `", "name": "one-ci", "username": "ci", "password": "password", "auth": "client-credentials"},
+  {"api": "` + apiTwo + `", "username": "admin", "password": "password", "skip_ssl_validation": true}
+]`
+
+		It("reads APIs from CF_PLEX_APIS", func() {
+			envVars = append(envVars, "CF_PLEX_APIS="+apis)
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{"admin@" + target.Sanitise(apiOne), "one-ci", "admin@" + target.Sanitise(apiTwo)}))
+			Ω(argsOf("auth")).Should(ContainElement([]string{"auth", "ci", "password", "--client-credentials"}))
+			Ω(argsOf("api")).Should(ContainElement([]string{"api", apiTwo, "--skip-ssl-validation"}))
+			Ω(invocationsOf("target")[0].Args).Should(Equal([]string{"target", "-o", "system", "-s", "ops"}))
+
+			session = run("--selector", "env=prod", "apps")
+			Ω(session).Should(Exit(0))
+			Ω(cfHomesOf("apps")[3:]).Should(Equal([]string{"admin@" + target.Sanitise(apiOne)}))
+			Ω(invocationsOf("auth")).Should(HaveLen(3))
+			Ω(invocationsOf("target")).Should(HaveLen(1))
+		})
+
+		It("reads APIs from CF_PLEX_APIS_FILE", func() {
+			path := filepath.Join(tmpDir, "apis.json")
+			Ω(ioutil.WriteFile(path, []byte(apis), 0600)).Should(Succeed())
+			envVars = append(envVars, "CF_PLEX_APIS_FILE="+path)
+
+			Ω(run("apps")).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(HaveLen(3))
+
+			session := run("add-api", apiThree)
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("Managing APIs is not allowed when CF_PLEX_APIS or CF_PLEX_APIS_FILE is set"))
+		})
+
+		It("filters APIs by group, name and selector before logging in to them", func() {
+			envVars = append(envVars, `CF_PLEX_APIS=[
+  {"api": "`+apiOne+`", "group": "prod", "username": "admin", "password": "password", "labels": {"region": "eu"}},
+  {"api": "`+apiTwo+`", "group": "prod", "username": "admin", "password": "password", "labels": {"region": "us"}},
+  {"api": "`+apiThree+`", "username": "admin", "password": "password"}
+]`)
+
+			Ω(run("-g", "prod", "--selector", "region=eu", "apps")).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{"admin@" + target.Sanitise(apiOne)}))
+			Ω(cfHomesOf("auth")).Should(Equal([]string{"admin@" + target.Sanitise(apiOne)}), "should not log in to APIs that were not chosen")
+
+			Ω(run("-g", "default", "apps")).Should(Exit(0))
+			Ω(cfHomesOf("apps")[1:]).Should(Equal([]string{"admin@" + target.Sanitise(apiThree)}))
+
+			Ω(run("-g", "prod", "-t", apiTwo, "apps")).Should(Exit(0))
+			Ω(cfHomesOf("apps")[2:]).Should(Equal([]string{"admin@" + target.Sanitise(apiTwo)}))
+			for _, invocation := range invocationsOf("apps") {
+				Ω(invocation.Args).Should(Equal([]string{"apps"}))
+			}
+
+			session := run("-g", "staging", "apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Err).Should(Say("Group 'staging' not recognised"))
+		})
+
+		It("reads -g wherever it is given among the other flags", func() {
+			envVars = append(envVars, `CF_PLEX_APIS=[
+  {"api": "`+apiOne+`", "group": "prod", "username": "admin", "password": "password"},
+  {"api": "`+apiTwo+`", "username": "admin", "password": "password"}
+]`)
+
+			Ω(run("--parallel", "4", "-g", "prod", "apps")).Should(Exit(0))
+			Ω(cfHomesOf("apps")).Should(Equal([]string{"admin@" + target.Sanitise(apiOne)}))
+			Ω(invocationsOf("apps")[0].Args).Should(Equal([]string{"apps"}))
+		})
+
+		It("reports invalid documents", func() {
+			envVars = append(envVars, `CF_PLEX_APIS=[{"api": "`+apiOne+`", "auth": "sso"}]`)
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("auth for " + apiOne + " must be password or client-credentials"))
+		})
+	})
+
+	Describe("pruning batch CF_HOMEs", func() {
+		batchDir := func(api string) string {
+			return filepath.Join(tmpDir, "home", "groups", "batch", "admin@"+target.Sanitise(api))
+		}
+
+		BeforeEach(func() {
+			envVars = env.Set("CF_PLEX_APIS", "admin^password>"+apiOne+";admin^password>"+apiTwo, envVars)
+			Ω(run("apps")).Should(Exit(0))
+			envVars = env.Set("CF_PLEX_APIS", "admin^password>"+apiOne, envVars)
+		})
+
+		It("logs out of and removes those no longer in CF_PLEX_APIS", func() {
+			session := run("prune", "--dry-run")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`Would remove admin@` + target.Sanitise(apiTwo) + ` \(not in CF_PLEX_APIS\)`))
+			Ω(session.Out).Should(Say(`Dry run: 1 CF_HOME\(s\) not removed`))
+			Ω(batchDir(apiTwo)).Should(BeADirectory())
+			Ω(invocationsOf("logout")).Should(BeEmpty())
+
+			session = run("prune")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`Removed admin@` + target.Sanitise(apiTwo)))
+			Ω(cfHomesOf("logout")).Should(Equal([]string{"admin@" + target.Sanitise(apiTwo)}))
+			Ω(batchDir(apiTwo)).ShouldNot(BeADirectory())
+			Ω(batchDir(apiOne)).Should(BeADirectory())
+
+			Ω(run("prune").Out).Should(Say("Nothing to prune"))
+		})
+
+		It("removes those not used for longer than --older-than", func() {
+			metadata, err := target.ReadMetadata(batchDir(apiOne))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(metadata.LastUsed).ShouldNot(BeNil())
+			longAgo := time.Now().Add(-48 * time.Hour)
+			metadata.LastUsed = &longAgo
+			Ω(target.WriteMetadata(batchDir(apiOne), metadata)).Should(Succeed())
+
+			envVars = env.Set("CF_PLEX_APIS", "", envVars)
+			session := run("prune", "--older-than", "24h")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`Removed admin@` + target.Sanitise(apiOne) + ` \(last used`))
+			Ω(batchDir(apiTwo)).Should(BeADirectory())
+
+			session = run("prune")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("Set CF_PLEX_APIS or CF_PLEX_APIS_FILE, or give --older-than"))
+		})
+
+		It("prunes automatically in batch mode those that are unreferenced and unused, when asked to", func() {
+			envVars = env.Set("CF_PLEX_AUTO_PRUNE", "true", envVars)
+			envVars = env.Set("CF_PLEX_PRUNE_OLDER_THAN", "24h", envVars)
+			Ω(run("apps")).Should(Exit(0))
+			Ω(batchDir(apiTwo)).Should(BeADirectory(), "should keep directories that another job may be using")
+
+			metadata, err := target.ReadMetadata(batchDir(apiTwo))
+			Ω(err).ShouldNot(HaveOccurred())
+			longAgo := time.Now().Add(-48 * time.Hour)
+			metadata.LastUsed = &longAgo
+			Ω(target.WriteMetadata(batchDir(apiTwo), metadata)).Should(Succeed())
+
+			session := run("apps")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`Removed admin@` + target.Sanitise(apiTwo) + ` \(not in CF_PLEX_APIS, last used`))
+			Ω(batchDir(apiTwo)).ShouldNot(BeADirectory())
+			Ω(cfHomesOf("apps")[2:]).Should(Equal([]string{"admin@" + target.Sanitise(apiOne), "admin@" + target.Sanitise(apiOne)}))
+		})
+
+		It("refuses to prune automatically without CF_PLEX_PRUNE_OLDER_THAN", func() {
+			envVars = env.Set("CF_PLEX_AUTO_PRUNE", "true", envVars)
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("CF_PLEX_AUTO_PRUNE needs CF_PLEX_PRUNE_OLDER_THAN"))
+			Ω(batchDir(apiTwo)).Should(BeADirectory())
+			Ω(cfHomesOf("apps")).Should(HaveLen(2))
+		})
+
+		It("refuses an invalid age", func() {
+			session := run("prune", "--older-than", "a while")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("--older-than must be a positive duration"))
+		})
+	})
+
+	Describe("locking", func() {
+		BeforeEach(func() {
+			envVars = env.Set(lock.TimeoutVar, "200ms", envVars)
+		})
+
+		It("does not run cf against a CF_HOME that another process has locked", func() {
+			add(apiOne, "admin", "password")
+			held, err := lock.Acquire(filepath.Join(tmpDir, "home", target.Sanitise(apiOne)), time.Second)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer held.Release()
+
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say(target.Sanitise(apiOne) + " is locked by process " + strconv.Itoa(os.Getpid())))
+			Ω(session.Out).Should(Say("gave up after 200ms. Set CF_PLEX_LOCK_TIMEOUT to wait longer"))
+			Ω(invocationsOf("apps")).Should(BeEmpty())
+		})
+
+		It("does not bring back a CF_HOME that was removed whilst waiting for it", func() {
+			add(apiOne, "admin", "password")
+			cfHome := filepath.Join(tmpDir, "home", target.Sanitise(apiOne))
+			held, err := lock.Acquire(cfHome, time.Second)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			envVars = env.Set(lock.TimeoutVar, "10s", envVars)
+			session, _ := startSession(envVars, cliPath, "--skip-preflight", "apps")
+			Eventually(session.Err, timeout).Should(Say("Waiting for process " + strconv.Itoa(os.Getpid())))
+			Ω(os.RemoveAll(cfHome)).Should(Succeed())
+			Ω(held.Release()).Should(Succeed())
+
+			Eventually(session, timeout).Should(Exit(1))
+			Ω(session.Out).Should(Say(cfHome + " was removed whilst waiting for it to be unlocked"))
+			Ω(cfHome).ShouldNot(BeADirectory())
+			Ω(invocationsOf("apps")).Should(BeEmpty())
+		})
+
+		It("does not change or remove a target that another process has locked", func() {
+			add(apiOne, "admin", "password")
+			held, err := lock.Acquire(filepath.Join(tmpDir, "home", target.Sanitise(apiOne)), time.Second)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer held.Release()
+
+			inventoryPath := filepath.Join(tmpDir, "inventory.yml")
+			Ω(ioutil.WriteFile(inventoryPath, []byte("groups:\n  prod:\n  - api: "+apiTwo+"\n"), 0600)).Should(Succeed())
+
+			for _, args := range [][]string{
+				{"remove-api", apiOne},
+				{"rename-api", apiOne, "one"},
+				{"label", apiOne, "env=prod"},
+				{"sync", inventoryPath},
+			} {
+				session := run(args...)
+				Ω(session).Should(Exit(1), args[0])
+				Ω(session.Out).Should(Say(target.Sanitise(apiOne) + " is locked by process " + strconv.Itoa(os.Getpid())))
+			}
+
+			metadata, err := target.ReadMetadata(filepath.Join(tmpDir, "home", target.Sanitise(apiOne)))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(metadata.Name).Should(BeEmpty())
+			Ω(metadata.Labels).Should(BeEmpty())
+		})
+
+		It("does not record the cf version of a target that another process has locked", func() {
+			add(apiOne, "admin", "password")
+			cfHome := filepath.Join(tmpDir, "home", target.Sanitise(apiOne))
+			held, err := lock.Acquire(cfHome, time.Second)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer held.Release()
+
+			envVars = env.Set(fakecf.VersionVar, "7.2.0+fake", envVars)
+			Ω(run("--skip-preflight", "apps")).Should(Exit(1))
+
+			metadata, err := target.ReadMetadata(cfHome)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(metadata.LastCfMajor).Should(Equal(6))
+		})
+
+		It("leaves the CF_HOMEs of batch APIs that were not chosen alone", func() {
+			envVars = env.Set("CF_PLEX_APIS", "admin^password>"+apiOne+";admin^password>"+apiTwo, envVars)
+			Ω(run("apps")).Should(Exit(0))
+
+			cfHome := filepath.Join(tmpDir, "home", "groups", "batch", "admin@"+target.Sanitise(apiTwo))
+			before, err := target.ReadMetadata(cfHome)
+			Ω(err).ShouldNot(HaveOccurred())
+			held, err := lock.Acquire(cfHome, time.Second)
+			Ω(err).ShouldNot(HaveOccurred())
+			defer held.Release()
+
+			envVars = env.Set("CF_PLEX_APIS", `[
+  {"api": "`+apiOne+`", "username": "admin", "password": "password"},
+  {"api": "`+apiTwo+`", "username": "admin", "password": "password", "labels": {"env": "prod"}}
+]`, envVars)
+			Ω(run("-t", apiOne, "apps")).Should(Exit(0))
+
+			after, err := target.ReadMetadata(cfHome)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(after).Should(Equal(before))
+		})
+
+		It("waits for CF_PLEX_HOME to be unlocked before adding an API", func() {
+			Ω(os.MkdirAll(filepath.Join(tmpDir, "home"), 0700)).Should(Succeed())
+			held, err := lock.Acquire(filepath.Join(tmpDir, "home"), time.Second)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			session := run("add-api", apiOne, "admin", "password")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("is locked by process " + strconv.Itoa(os.Getpid())))
+
+			envVars = env.Set(lock.TimeoutVar, "10s", envVars)
+			session, _ = startSession(envVars, cliPath, "add-api", apiOne, "admin", "password")
+			Eventually(session.Err, timeout).Should(Say("Waiting for process " + strconv.Itoa(os.Getpid())))
+			Ω(held.Release()).Should(Succeed())
+			Eventually(session, timeout).Should(Exit(0))
+			Ω(invocationsOf("auth")).Should(HaveLen(1))
+		})
+	})
+
+	Describe("keeping secrets out of output", func() {
+		It("does not print the password from an invalid CF_PLEX_APIS", func() {
+			envVars = append(envVars, "CF_PLEX_APIS=admin^s3cret>"+apiOne+";admin^s3cret")
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("entry 2 is invalid"))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring("s3cret"))
+		})
+
+		It("does not print passwords from an invalid document", func() {
+			envVars = append(envVars, `CF_PLEX_APIS=[{"api": "`+apiOne+`", "skip_ssl_validation": "s3cret"}]`)
+			session := run("apps")
+			Ω(session).Should(Exit(1))
+			Ω(session.Out).Should(Say("batch APIs are invalid"))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring("s3cret"))
+		})
+
+		It("masks secrets in commands and in the output of cf", func() {
+			add(apiOne, "admin", "password")
+			script(fakecf.Rule{Args: []string{"set-env"}, Stdout: "Setting env variable DB_PASSWORD to d8-s3cret\n", Stderr: "d8-s3cret rejected\n"})
+
+			session := run("set-env", "my-app", "DB_PASSWORD", "d8-s3cret")
+			Ω(session).Should(Exit(0))
+			Ω(session.Out).Should(Say(`Running 'cf set-env my-app DB_PASSWORD \[expunged\]'`))
+			Ω(session.Out).Should(Say(`DB_PASSWORD to \[expunged\]`))
+			Ω(session.Err).Should(Say(`\[expunged\] rejected`))
+
+			session = run("--output", "json", "create-user", "bob", "b0b-s3cret")
+			Ω(session).Should(Exit(0))
+			Ω(string(session.Out.Contents())).ShouldNot(ContainSubstring("b0b-s3cret"))
+			Ω(string(session.Err.Contents())).ShouldNot(ContainSubstring("b0b-s3cret"))
+		})
+	})
+
+	Context("when the output of a failed command is inspected", func() {
+		It("reports which APIs failed", func() {
+			add(apiOne, "admin", "password")
+			add(apiTwo, "admin", "password")
+			script(fakecf.Rule{Args: []string{"apps"}, CfHome: target.Sanitise(apiTwo), ExitCode: 2})
+
+			session := run("apps", "--force")
+			lines := strings.Split(string(session.Out.Contents()), "\n")
+			Ω(lines[len(lines)-3]).Should(MatchRegexp(`^https://api.one.example.com\s+default\s+ok`))
+			Ω(lines[len(lines)-2]).Should(MatchRegexp(`^https://api.two.example.com\s+default\s+failed\s+2`))
+		})
+	})
+})
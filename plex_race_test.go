@@ -0,0 +1,9 @@
+//go:build race
+// +build race
+
+package main_test
+
+// When the specs are run with -race, so is cf-plex.
+func init() {
+	buildArgs = append(buildArgs, "-race")
+}
@@ -7,6 +7,9 @@ import (
 	"testing"
 )
 
+// buildArgs are passed to go build when cf-plex is built for the specs.
+var buildArgs []string
+
 func TestGoto(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Plex Suite")
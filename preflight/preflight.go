@@ -0,0 +1,83 @@
+// Package preflight checks that targets are ready for a command to be run
+// against them, so that a run does not stop part way through the estate.
+package preflight
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/ccapi"
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/fanout"
+	"github.com/EngineerBetter/cf-plex/target"
+)
+
+const DefaultTimeout = 10 * time.Second
+
+// Problem describes why a target failed its check.
+type Problem struct {
+	Target target.Target
+	Reason string
+}
+
+// Needed reports whether a cf command relies on an existing session. Those
+// that set one up, or that don't talk to an API at all, are not checked.
+func Needed(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+
+	switch args[1] {
+	case "api", "auth", "login", "logout", "help", "version", "-v", "--version", "-h", "--help":
+		return false
+	}
+	return true
+}
+
+// Check checks every target concurrently, returning the problems found in
+// the same order as targets.
+func Check(targets []target.Target, timeout time.Duration) []Problem {
+	results := fanout.Run(targets, len(targets), true, func(ctx context.Context, aTarget target.Target) (int, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		if err := CheckTarget(ctx, aTarget, time.Now()); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}, nil)
+
+	var problems []Problem
+	for index, result := range results {
+		if result.Err != nil {
+			problems = append(problems, Problem{Target: targets[index], Reason: result.Err.Error()})
+		}
+	}
+	return problems
+}
+
+// CheckTarget makes sure that config.json targets the target's API, that the
+// API can be reached, and that config.json has a token for it that is still
+// valid, or that can be refreshed. As with status, cf itself is not run.
+func CheckTarget(ctx context.Context, aTarget target.Target, now time.Time) error {
+	config, err := cfconfig.Read(aTarget.Path)
+	if err != nil {
+		return err
+	}
+	if !cfconfig.SameApi(config.Target, aTarget.Api) {
+		if config.Target == "" {
+			return errors.New("not logged in, or token has expired")
+		}
+		return errors.New("config.json targets " + config.Target + " instead")
+	}
+
+	_, err = ccapi.GetInfo(ctx, aTarget.Api, aTarget.SkipSslValidation || config.SSLDisabled)
+	if err != nil {
+		return err
+	}
+	if !config.Valid(now) {
+		return errors.New("not logged in, or token has expired")
+	}
+	return nil
+}
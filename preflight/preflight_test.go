@@ -0,0 +1,126 @@
+package preflight_test
+
+import (
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/fakecc"
+	"github.com/EngineerBetter/cf-plex/fakecf"
+	. "github.com/EngineerBetter/cf-plex/preflight"
+	"github.com/EngineerBetter/cf-plex/target"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("preflight", func() {
+	var plexHome string
+	var api *httptest.Server
+
+	// loggedIn gives the CF_HOME called name a token that is valid for an hour.
+	loggedIn := func(name string, config cfconfig.Config) string {
+		cfHome := filepath.Join(plexHome, name)
+		config.AccessToken = "bearer " + fakecc.AccessToken(config.Target, "admin", time.Now().Add(time.Hour))
+		Ω(fakecf.WriteConfig(cfHome, config)).Should(Succeed())
+		return cfHome
+	}
+
+	BeforeEach(func() {
+		var err error
+		plexHome, err = ioutil.TempDir("", "plex-preflight")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		api = httptest.NewServer(nil)
+		fakecc.Configure(api.Config, fakecc.Foundation{Addr: api.URL})
+	})
+
+	AfterEach(func() {
+		api.Close()
+		os.RemoveAll(plexHome)
+	})
+
+	It("only checks commands that need a session", func() {
+		Ω(Needed([]string{"", "apps"})).Should(BeTrue())
+		Ω(Needed([]string{"", "login", "-a", "https://api.example.com"})).Should(BeFalse())
+		Ω(Needed([]string{"", "auth", "user", "pass"})).Should(BeFalse())
+		Ω(Needed([]string{""})).Should(BeFalse())
+	})
+
+	It("reports every target that is not logged in or reachable, in order", func() {
+		targets := []target.Target{
+			{Name: "one", Api: api.URL, Path: loggedIn("one", cfconfig.Config{Target: api.URL})},
+			{Name: "two", Api: api.URL, Path: filepath.Join(plexHome, "two")},
+			{Name: "three", Api: "http://127.0.0.1:1", Path: loggedIn("three", cfconfig.Config{Target: "http://127.0.0.1:1"})},
+		}
+
+		problems := Check(targets, time.Second)
+		Ω(problems).Should(HaveLen(2))
+		Ω(problems[0].Target.Name).Should(Equal("two"))
+		Ω(problems[0].Reason).Should(Equal("not logged in, or token has expired"))
+		Ω(problems[1].Target.Name).Should(Equal("three"))
+		Ω(problems[1].Reason).Should(HavePrefix("API unreachable"))
+	})
+
+	It("reports tokens that have expired and cannot be refreshed", func() {
+		cfHome := filepath.Join(plexHome, "one")
+		config := cfconfig.Config{Target: api.URL, AccessToken: "bearer " + fakecc.AccessToken(api.URL, "admin", time.Now().Add(-time.Minute))}
+		Ω(fakecf.WriteConfig(cfHome, config)).Should(Succeed())
+
+		err := CheckTarget(context.Background(), target.Target{Name: "one", Api: api.URL, Path: cfHome}, time.Now())
+		Ω(err).Should(MatchError("not logged in, or token has expired"))
+	})
+
+	It("reports APIs that respond with an error", func() {
+		aTarget := target.Target{Name: "one", Api: api.URL + "/broken", Path: loggedIn("one", cfconfig.Config{Target: api.URL + "/broken"})}
+
+		Ω(CheckTarget(context.Background(), aTarget, time.Now())).Should(MatchError("API unhealthy: /v2/info returned 404"))
+	})
+
+	It("reports CF_HOMEs that target a different API", func() {
+		other := httptest.NewServer(nil)
+		defer other.Close()
+		fakecc.Configure(other.Config, fakecc.Foundation{Addr: other.URL})
+
+		aTarget := target.Target{Name: "one", Api: api.URL, Path: loggedIn("one", cfconfig.Config{Target: other.URL})}
+		Ω(CheckTarget(context.Background(), aTarget, time.Now())).Should(MatchError("config.json targets " + other.URL + " instead"))
+
+		aTarget.Path = loggedIn("two", cfconfig.Config{Target: api.URL + "/"})
+		Ω(CheckTarget(context.Background(), aTarget, time.Now())).Should(Succeed())
+	})
+
+	It("skips SSL validation when cf was told to", func() {
+		secure := httptest.NewTLSServer(nil)
+		defer secure.Close()
+		fakecc.Configure(secure.Config, fakecc.Foundation{Addr: secure.URL})
+
+		aTarget := target.Target{Name: "one", Api: secure.URL, Path: loggedIn("one", cfconfig.Config{Target: secure.URL})}
+		Ω(CheckTarget(context.Background(), aTarget, time.Now())).Should(MatchError(HavePrefix("API unreachable")))
+
+		aTarget.Path = loggedIn("two", cfconfig.Config{Target: secure.URL, SSLDisabled: true})
+		Ω(CheckTarget(context.Background(), aTarget, time.Now())).Should(Succeed())
+	})
+
+	It("gives up on targets that take too long", func() {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(2 * time.Second)
+		}))
+		defer slow.Close()
+		targets := []target.Target{{Name: "one", Api: slow.URL, Path: loggedIn("one", cfconfig.Config{Target: slow.URL})}}
+
+		start := time.Now()
+		problems := Check(targets, 100*time.Millisecond)
+		Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+		Ω(problems).Should(HaveLen(1))
+		Ω(problems[0].Reason).Should(HavePrefix("API unreachable"))
+	})
+
+	It("passes targets that are ready", func() {
+		targets := []target.Target{{Name: "one", Api: api.URL, Path: loggedIn("one", cfconfig.Config{Target: api.URL})}}
+		Ω(Check(targets, time.Second)).Should(BeEmpty())
+	})
+})
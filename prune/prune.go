@@ -0,0 +1,90 @@
+// Package prune finds the CF_HOME directories of batch APIs that are no
+// longer needed, so that their sessions can be ended and removed.
+package prune
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/target"
+)
+
+// Stale is a batch target that can be removed, and why.
+type Stale struct {
+	Target target.Target
+	Reason string
+}
+
+// Match says whether a batch target must meet either or both of the
+// criteria given to Find to be stale.
+type Match int
+
+const (
+	Either Match = iota
+	Both
+)
+
+// Find returns the batch targets in plexHome whose directories are not
+// among referenced, unless it is nil, or were last used before cutoff,
+// unless it is zero. With Both, targets must meet both criteria, and none
+// do if either is not given.
+func Find(plexHome string, referenced []string, cutoff time.Time, match Match) ([]Stale, error) {
+	targets, err := target.Batch(plexHome)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, dir := range referenced {
+		wanted[dir] = true
+	}
+
+	var stale []Stale
+	for _, aTarget := range targets {
+		unreferenced := referenced != nil && !wanted[filepath.Base(aTarget.Path)]
+		if unreferenced && match == Either {
+			stale = append(stale, Stale{Target: aTarget, Reason: "not in CF_PLEX_APIS"})
+			continue
+		}
+		if cutoff.IsZero() || (match == Both && !unreferenced) {
+			continue
+		}
+
+		lastUsed, err := LastUsed(aTarget)
+		if err != nil {
+			return nil, err
+		}
+		if !lastUsed.Before(cutoff) {
+			continue
+		}
+		reason := "last used " + lastUsed.Format(time.RFC3339)
+		if match == Both {
+			reason = "not in CF_PLEX_APIS, " + reason
+		}
+		stale = append(stale, Stale{Target: aTarget, Reason: reason})
+	}
+	return stale, nil
+}
+
+// LastUsed returns when a batch target was last used. Targets set up before
+// this was recorded were last used no later than their metadata was
+// written.
+func LastUsed(aTarget target.Target) (time.Time, error) {
+	metadata, err := target.ReadMetadata(aTarget.Path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if metadata.LastUsed != nil {
+		return *metadata.LastUsed, nil
+	}
+
+	info, err := os.Stat(filepath.Join(aTarget.Path, target.MetadataFile))
+	if os.IsNotExist(err) {
+		info, err = os.Stat(aTarget.Path)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
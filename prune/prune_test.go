@@ -0,0 +1,103 @@
+package prune_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/prune"
+	"github.com/EngineerBetter/cf-plex/target"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("prune", func() {
+	var plexHome string
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	addBatch := func(dir string, lastUsed *time.Time) {
+		path, err := target.AddToBatch(plexHome, dir)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(target.WriteMetadata(path, target.Metadata{Api: "https://api.example.com", LastUsed: lastUsed})).Should(Succeed())
+	}
+
+	names := func(stale []Stale) []string {
+		var names []string
+		for _, each := range stale {
+			names = append(names, filepath.Base(each.Target.Path)+": "+each.Reason)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		var err error
+		plexHome, err = ioutil.TempDir("", "plex-prune")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Ω(os.RemoveAll(plexHome)).Should(Succeed())
+	})
+
+	It("finds nothing when batch mode has never been used", func() {
+		stale, err := Find(plexHome, []string{}, now, Either)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stale).Should(BeEmpty())
+	})
+
+	It("finds directories that are not referenced", func() {
+		addBatch("admin@one", nil)
+		addBatch("admin@two", nil)
+
+		stale, err := Find(plexHome, []string{"admin@one"}, time.Time{}, Either)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(names(stale)).Should(Equal([]string{"admin@two: not in CF_PLEX_APIS"}))
+
+		stale, err = Find(plexHome, nil, time.Time{}, Either)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stale).Should(BeEmpty(), "should not consider references when there are none")
+	})
+
+	It("finds directories that have not been used since the cutoff", func() {
+		old := now.Add(-48 * time.Hour)
+		recent := now.Add(-time.Hour)
+		addBatch("admin@one", &old)
+		addBatch("admin@two", &recent)
+
+		stale, err := Find(plexHome, nil, now.Add(-24*time.Hour), Either)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(names(stale)).Should(Equal([]string{"admin@one: last used 2026-10-16T12:00:00Z"}))
+	})
+
+	It("can insist that directories are both unreferenced and unused since the cutoff", func() {
+		old := now.Add(-48 * time.Hour)
+		recent := now.Add(-time.Hour)
+		addBatch("admin@one", &old)
+		addBatch("admin@two", &old)
+		addBatch("admin@three", &recent)
+
+		stale, err := Find(plexHome, []string{"admin@one"}, now.Add(-24*time.Hour), Both)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(names(stale)).Should(Equal([]string{"admin@two: not in CF_PLEX_APIS, last used 2026-10-16T12:00:00Z"}))
+
+		stale, err = Find(plexHome, []string{"admin@one"}, time.Time{}, Both)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stale).Should(BeEmpty(), "should not prune anything without a cutoff")
+
+		stale, err = Find(plexHome, nil, now.Add(-24*time.Hour), Both)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(stale).Should(BeEmpty(), "should not prune anything without references")
+	})
+
+	It("falls back to when the metadata was written", func() {
+		addBatch("admin@one", nil)
+		path := filepath.Join(target.GroupDir(plexHome, "batch"), "admin@one")
+		written := now.Add(-72 * time.Hour)
+		Ω(os.Chtimes(filepath.Join(path, target.MetadataFile), written, written)).Should(Succeed())
+
+		lastUsed, err := LastUsed(target.Target{Path: path})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(lastUsed.Equal(written)).Should(BeTrue())
+	})
+})
@@ -0,0 +1,8 @@
+package redact
+
+import "io"
+
+// SetWarnings lets the specs see the warning about short secrets.
+func SetWarnings(writer io.Writer) {
+	warnings = writer
+}
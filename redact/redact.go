@@ -0,0 +1,216 @@
+// Package redact masks secrets in everything that cf-plex prints: error
+// messages, the commands it announces, and the output of cf itself.
+//
+// Secrets are masked wherever they appear once they are known. They become
+// known by being added explicitly, as credentials are read, or by appearing
+// in a cf command line in a position that holds a secret.
+package redact
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces each secret.
+const Mask = "[expunged]"
+
+// MinLength is the length below which secrets are only masked in the
+// positions of a command line that hold them, and not wherever they appear,
+// which would mangle ordinary text.
+const MinLength = 3
+
+var lock sync.RWMutex
+var secrets []string
+
+// warnings is where the warning about short secrets is written, and warned
+// whether it has been already.
+var warnings io.Writer = os.Stderr
+var warned bool
+
+// Add makes secrets known, so that they are masked wherever they appear.
+// The first time that a secret is too short for that, a warning is written
+// to stderr.
+func Add(newSecrets ...string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, secret := range newSecrets {
+		if secret != "" && len(secret) < MinLength && !warned {
+			fmt.Fprintf(warnings, "Warning: secrets shorter than %d characters are only masked where they are given on the command line\n", MinLength)
+			warned = true
+		}
+		if len(secret) < MinLength || contains(secrets, secret) {
+			continue
+		}
+		secrets = append(secrets, secret)
+	}
+	// Longer secrets go first, so that no part of one is left behind when a
+	// shorter secret that it contains is masked.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+}
+
+// Reset forgets every secret, and whether a short one has been warned about.
+func Reset() {
+	lock.Lock()
+	defer lock.Unlock()
+	secrets = nil
+	warned = false
+}
+
+// String masks every known secret in s.
+func String(s string) string {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, Mask, -1)
+	}
+	return s
+}
+
+// Error masks every known secret in an error's message. Errors without any
+// are returned as they are, so that they can still be compared.
+func Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if masked := String(err.Error()); masked != err.Error() {
+		return errors.New(masked)
+	}
+	return err
+}
+
+// valueFlags are the cf flags that take a value, which is therefore not a
+// positional argument.
+var valueFlags = map[string]bool{
+	"-a": true, "-u": true, "-p": true, "-o": true, "-s": true, "-c": true,
+	"--origin": true, "--sso-passcode": true, "--client-id": true,
+}
+
+// secretFlags are the flags whose values are secrets, by command.
+var secretFlags = map[string][]string{
+	"login":                        {"-p", "--sso-passcode"},
+	"l":                            {"-p", "--sso-passcode"},
+	"create-user-provided-service": {"-p"},
+	"cups":                         {"-p"},
+	"update-user-provided-service": {"-p"},
+	"uups":                         {"-p"},
+}
+
+// secretPositions are the positional arguments that are secrets, by
+// command, counting from zero after the command itself.
+var secretPositions = map[string][]int{
+	"auth":                  {1},
+	"create-user":           {1},
+	"set-env":               {2},
+	"se":                    {2},
+	"create-service-broker": {2},
+	"update-service-broker": {2},
+}
+
+// Command returns a copy of a cf command line, starting with the binary,
+// with the secrets it holds masked. Those secrets become known, and every
+// other known secret is masked too.
+func Command(args []string) []string {
+	redacted := make([]string, len(args))
+	for index, arg := range args {
+		redacted[index] = String(arg)
+	}
+	if len(args) < 2 {
+		return redacted
+	}
+
+	command := args[1]
+	var found []string
+	positional := 0
+	for index := 2; index < len(args); index++ {
+		arg := args[index]
+		if strings.HasPrefix(arg, "-") {
+			if valueFlags[arg] && index+1 < len(args) {
+				if contains(secretFlags[command], arg) {
+					found = append(found, args[index+1])
+					redacted[index+1] = Mask
+				}
+				index++
+			}
+			continue
+		}
+
+		for _, position := range secretPositions[command] {
+			if position == positional {
+				found = append(found, arg)
+				redacted[index] = Mask
+			}
+		}
+		positional++
+	}
+
+	Add(found...)
+	return redacted
+}
+
+// Writer masks known secrets in everything written to it before passing it
+// on. Text that might be the start of a secret is held back until more is
+// written, or until Flush is called.
+type Writer struct {
+	out     io.Writer
+	pending []byte
+}
+
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	text := String(string(append(w.pending, p...)))
+	held := heldBack(text)
+	w.pending = []byte(text[len(text)-held:])
+
+	if _, err := io.WriteString(w.out, text[:len(text)-held]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes anything held back.
+func (w *Writer) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	text := String(string(w.pending))
+	w.pending = nil
+	_, err := io.WriteString(w.out, text)
+	return err
+}
+
+// heldBack returns the length of the longest end of text that is the start
+// of a known secret.
+func heldBack(text string) int {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	longest := 0
+	for _, secret := range secrets {
+		for length := len(secret) - 1; length > longest; length-- {
+			if length <= len(text) && strings.HasSuffix(text, secret[:length]) {
+				longest = length
+				break
+			}
+		}
+	}
+	return longest
+}
+
+func contains(list []string, item string) bool {
+	for _, each := range list {
+		if each == item {
+			return true
+		}
+	}
+	return false
+}
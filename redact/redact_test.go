@@ -0,0 +1,155 @@
+package redact_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/redact"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+	"context"
+	"errors"
+	"os"
+)
+
+var _ = Describe("redact", func() {
+	var warnings *bytes.Buffer
+
+	BeforeEach(func() {
+		Reset()
+		warnings = new(bytes.Buffer)
+		SetWarnings(warnings)
+	})
+
+	AfterEach(func() {
+		Reset()
+		SetWarnings(os.Stderr)
+	})
+
+	Describe("String", func() {
+		It("masks every known secret, wherever it appears", func() {
+			Add("s3cret", "hunter2")
+			Ω(String("s3cret and hunter2, then s3cret again")).Should(Equal("[expunged] and [expunged], then [expunged] again"))
+		})
+
+		It("masks longer secrets before the shorter secrets they contain", func() {
+			Add("pass", "password123")
+			Ω(String("password123")).Should(Equal("[expunged]"))
+		})
+
+		It("ignores secrets too short to mask safely", func() {
+			Add("", "ab")
+			Ω(String("about a cab")).Should(Equal("about a cab"))
+		})
+
+		It("warns once that short secrets are not masked", func() {
+			Add("", "s3cret")
+			Ω(warnings.String()).Should(BeEmpty())
+
+			Add("ab")
+			Add("x")
+			Ω(warnings.String()).Should(Equal("Warning: secrets shorter than 3 characters are only masked where they are given on the command line\n"))
+		})
+
+		It("leaves text alone when no secrets are known", func() {
+			Ω(String("nothing to hide")).Should(Equal("nothing to hide"))
+		})
+	})
+
+	Describe("Error", func() {
+		It("masks secrets in the message", func() {
+			Add("s3cret")
+			Ω(Error(errors.New("admin^s3cret>api.com is invalid"))).Should(MatchError("admin^[expunged]>api.com is invalid"))
+			Ω(Error(nil)).Should(BeNil())
+		})
+
+		It("leaves errors without secrets as they are", func() {
+			Add("s3cret")
+			Ω(Error(context.Canceled)).Should(BeIdenticalTo(context.Canceled))
+		})
+	})
+
+	Describe("Command", func() {
+		expectMasked := func(args []string, expected []string, secret string) {
+			Ω(Command(args)).Should(Equal(expected))
+			Ω(String("output mentioning "+secret)).Should(Equal("output mentioning [expunged]"), "should remember "+secret)
+		}
+
+		It("masks the password or client secret given to cf auth", func() {
+			expectMasked([]string{"cf", "auth", "admin", "s3cret"}, []string{"cf", "auth", "admin", Mask}, "s3cret")
+			expectMasked([]string{"cf", "auth", "--client-credentials", "pipeline", "cl1ent"}, []string{"cf", "auth", "--client-credentials", "pipeline", Mask}, "cl1ent")
+			expectMasked([]string{"cf", "auth", "admin", "or1gin", "--origin", "ldap"}, []string{"cf", "auth", "admin", Mask, "--origin", "ldap"}, "or1gin")
+		})
+
+		It("masks passwords and passcodes given to cf login", func() {
+			expectMasked([]string{"cf", "login", "-a", "https://api.com", "-u", "admin", "-p", "l0gin", "-o", "org"},
+				[]string{"cf", "login", "-a", "https://api.com", "-u", "admin", "-p", Mask, "-o", "org"}, "l0gin")
+			expectMasked([]string{"cf", "login", "--sso-passcode", "abc123"}, []string{"cf", "login", "--sso-passcode", Mask}, "abc123")
+		})
+
+		It("masks the password given to cf create-user", func() {
+			expectMasked([]string{"cf", "create-user", "bob", "b0bpass"}, []string{"cf", "create-user", "bob", Mask}, "b0bpass")
+		})
+
+		It("masks values given to cf set-env", func() {
+			expectMasked([]string{"cf", "set-env", "my-app", "DB_PASSWORD", "d8pass"}, []string{"cf", "set-env", "my-app", "DB_PASSWORD", Mask}, "d8pass")
+			expectMasked([]string{"cf", "se", "my-app", "TOKEN", "t0ken"}, []string{"cf", "se", "my-app", "TOKEN", Mask}, "t0ken")
+		})
+
+		It("masks credentials given to user-provided services and service brokers", func() {
+			expectMasked([]string{"cf", "cups", "db", "-p", `{"password":"ups"}`}, []string{"cf", "cups", "db", "-p", Mask}, `{"password":"ups"}`)
+			expectMasked([]string{"cf", "create-service-broker", "broker", "admin", "br0ker", "https://broker.com"},
+				[]string{"cf", "create-service-broker", "broker", "admin", Mask, "https://broker.com"}, "br0ker")
+		})
+
+		It("masks known secrets in any command", func() {
+			Add("s3cret")
+			Ω(Command([]string{"cf", "curl", "/v2/info?token=s3cret"})).Should(Equal([]string{"cf", "curl", "/v2/info?token=[expunged]"}))
+		})
+
+		It("leaves other commands alone, without modifying its argument", func() {
+			args := []string{"cf", "push", "my-app", "-p", "./app"}
+			Ω(Command(args)).Should(Equal(args))
+			Ω(String("./app")).Should(Equal("./app"))
+
+			args = []string{"cf", "auth", "admin", "s3cret"}
+			Command(args)
+			Ω(args[3]).Should(Equal("s3cret"))
+		})
+	})
+
+	Describe("Writer", func() {
+		var out *bytes.Buffer
+		var writer *Writer
+
+		BeforeEach(func() {
+			Add("s3cret")
+			out = new(bytes.Buffer)
+			writer = NewWriter(out)
+		})
+
+		It("masks secrets written to it", func() {
+			n, err := writer.Write([]byte("the password is s3cret\n"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(n).Should(Equal(23))
+			Ω(out.String()).Should(Equal("the password is [expunged]\n"))
+		})
+
+		It("masks secrets split across writes", func() {
+			writer.Write([]byte("the password is s3"))
+			Ω(out.String()).Should(Equal("the password is "))
+			writer.Write([]byte("cret\n"))
+			Ω(out.String()).Should(Equal("the password is [expunged]\n"))
+		})
+
+		It("passes on what it held back when flushed", func() {
+			writer.Write([]byte("Password> s3c"))
+			Ω(writer.Flush()).Should(Succeed())
+			Ω(out.String()).Should(Equal("Password> s3c"))
+		})
+
+		It("does not hold back text that cannot be a secret", func() {
+			writer.Write([]byte("Email> "))
+			Ω(out.String()).Should(Equal("Email> "))
+		})
+	})
+})
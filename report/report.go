@@ -0,0 +1,289 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/fanout"
+	"github.com/EngineerBetter/cf-plex/status"
+	"github.com/EngineerBetter/cf-plex/target"
+)
+
+type Format string
+
+const (
+	Text      Format = "text"
+	JSON      Format = "json"
+	JSONLines Format = "jsonl"
+)
+
+// Record is the machine-readable form of running a command against a target.
+type Record struct {
+	Target          string    `json:"target"`
+	Group           string    `json:"group"`
+	Args            []string  `json:"args"`
+	ExitCode        int       `json:"exit_code"`
+	Stdout          string    `json:"stdout"`
+	Stderr          string    `json:"stderr"`
+	Status          string    `json:"status"`
+	Skipped         bool      `json:"skipped"`
+	Error           string    `json:"error,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+type ApiRecord struct {
+	Name      string            `json:"name"`
+	Api       string            `json:"api"`
+	Group     string            `json:"group"`
+	CfBinary  string            `json:"cf_binary,omitempty"`
+	CfVersion string            `json:"cf_version,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Session   *Session          `json:"session,omitempty"`
+}
+
+// Session describes what cf has recorded in a target's config.json.
+type Session struct {
+	Api         string     `json:"api"`
+	User        string     `json:"user,omitempty"`
+	Org         string     `json:"org,omitempty"`
+	Space       string     `json:"space,omitempty"`
+	TokenExpiry *time.Time `json:"token_expiry,omitempty"`
+	Valid       bool       `json:"valid"`
+	SSLDisabled bool       `json:"ssl_disabled,omitempty"`
+}
+
+// StatusRecord is the machine-readable form of a target's status.
+type StatusRecord struct {
+	Target         string     `json:"target"`
+	Group          string     `json:"group"`
+	Api            string     `json:"api"`
+	User           string     `json:"user,omitempty"`
+	Org            string     `json:"org,omitempty"`
+	Space          string     `json:"space,omitempty"`
+	LoggedIn       bool       `json:"logged_in"`
+	TokenValid     bool       `json:"token_valid"`
+	TokenExpiry    *time.Time `json:"token_expiry,omitempty"`
+	ApiVersion     string     `json:"api_version,omitempty"`
+	Reachable      bool       `json:"reachable"`
+	LatencySeconds float64    `json:"latency_seconds"`
+	NeedsAttention bool       `json:"needs_attention"`
+	Error          string     `json:"error,omitempty"`
+}
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case Text, JSON, JSONLines:
+		return Format(format), nil
+	}
+	return "", errors.New("output format must be one of text, json or jsonl")
+}
+
+// NewRecord builds a Record from a result. args should already be redacted.
+func NewRecord(result fanout.Result, args []string, stdout, stderr string) Record {
+	record := Record{
+		Target:    result.Name,
+		Group:     result.Group,
+		Args:      args,
+		ExitCode:  result.ExitCode,
+		Stdout:    stdout,
+		Stderr:    stderr,
+		Status:    Status(result),
+		Skipped:   result.Skipped,
+		StartedAt: result.Started,
+	}
+
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+	}
+	if !result.Skipped {
+		record.DurationSeconds = result.Duration.Seconds()
+	}
+	return record
+}
+
+// ApiRecords describes every target in groups, including the session held
+// in its CF_HOME if cf has been run there.
+func ApiRecords(groups []target.Group) []ApiRecord {
+	records := []ApiRecord{}
+	for _, group := range groups {
+		for _, aTarget := range group.Apis {
+			record := ApiRecord{Name: aTarget.Name, Api: aTarget.Api, Group: group.Name, CfBinary: aTarget.CfBinary, CfVersion: aTarget.CfVersion, Labels: aTarget.Labels}
+			if config, err := cfconfig.Read(aTarget.Path); err == nil && config.Target != "" {
+				record.Session = NewSession(config, time.Now())
+			}
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+func NewSession(config cfconfig.Config, now time.Time) *Session {
+	session := &Session{
+		Api:         config.Target,
+		User:        config.User(),
+		Org:         config.OrganizationFields.Name,
+		Space:       config.SpaceFields.Name,
+		Valid:       config.Valid(now),
+		SSLDisabled: config.SSLDisabled,
+	}
+	if expiry := config.TokenExpiry(); !expiry.IsZero() {
+		session.TokenExpiry = &expiry
+	}
+	return session
+}
+
+// Write writes records as a single JSON array, or as one JSON object per
+// line when format is JSONLines.
+func Write(out io.Writer, format Format, records interface{}) error {
+	if format == JSONLines {
+		values, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+
+		var lines []json.RawMessage
+		if err := json.Unmarshal(values, &lines); err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintf(out, "%s\n", line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(records)
+}
+
+// WriteLine writes a single record as a line of JSON.
+func WriteLine(out io.Writer, record interface{}) error {
+	return json.NewEncoder(out).Encode(record)
+}
+
+// Summary writes a table with a row for each target's result.
+func Summary(out io.Writer, results []fanout.Result) error {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TARGET\tGROUP\tSTATUS\tEXIT CODE\tDURATION\tERROR")
+
+	for _, result := range results {
+		exitCode := strconv.Itoa(result.ExitCode)
+		duration := result.Duration.Round(time.Millisecond).String()
+		if result.Skipped {
+			exitCode = "-"
+			duration = "-"
+		}
+
+		var errMessage string
+		if result.Err != nil && !result.Cancelled() {
+			errMessage = result.Err.Error()
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", result.Name, result.Group, Status(result), exitCode, duration, errMessage)
+	}
+
+	return table.Flush()
+}
+
+func Status(result fanout.Result) string {
+	switch {
+	case result.Skipped:
+		return "skipped"
+	case result.Cancelled():
+		return "cancelled"
+	case result.Failed():
+		return "failed"
+	}
+	return "ok"
+}
+
+func StatusRecords(statuses []status.Status) []StatusRecord {
+	records := []StatusRecord{}
+	for _, aStatus := range statuses {
+		record := StatusRecord{
+			Target:         aStatus.Target.Name,
+			Group:          aStatus.Target.Group,
+			Api:            aStatus.Target.Api,
+			User:           aStatus.User,
+			Org:            aStatus.Org,
+			Space:          aStatus.Space,
+			LoggedIn:       aStatus.LoggedIn,
+			TokenValid:     aStatus.TokenValid,
+			ApiVersion:     aStatus.ApiVersion,
+			Reachable:      aStatus.Reachable,
+			LatencySeconds: aStatus.Latency.Seconds(),
+			NeedsAttention: aStatus.NeedsAttention(),
+		}
+		if !aStatus.TokenExpiry.IsZero() {
+			expiry := aStatus.TokenExpiry
+			record.TokenExpiry = &expiry
+		}
+		if aStatus.Err != nil {
+			record.Error = aStatus.Err.Error()
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// StatusTable writes a table with a row for each target's status.
+func StatusTable(out io.Writer, statuses []status.Status, now time.Time) error {
+	table := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "TARGET\tGROUP\tAPI\tUSER\tORG/SPACE\tTOKEN\tAPI VERSION\tREACHABLE\tLATENCY\tERROR")
+
+	for _, aStatus := range statuses {
+		orgSpace := "-"
+		if aStatus.Org != "" {
+			orgSpace = aStatus.Org + "/" + aStatus.Space
+		}
+
+		reachable, latency := "no", "-"
+		if aStatus.Reachable {
+			reachable = "yes"
+			latency = aStatus.Latency.Round(time.Millisecond).String()
+		}
+
+		var errMessage string
+		if aStatus.Err != nil {
+			errMessage = aStatus.Err.Error()
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			aStatus.Target.Name, aStatus.Target.Group, aStatus.Target.Api, orDash(aStatus.User), orgSpace,
+			TokenState(aStatus, now), orDash(aStatus.ApiVersion), reachable, latency, errMessage)
+	}
+
+	return table.Flush()
+}
+
+// TokenState describes a target's token: none, expired, refreshable once the
+// access token has expired, or how long the access token has left.
+func TokenState(aStatus status.Status, now time.Time) string {
+	switch {
+	case !aStatus.LoggedIn:
+		return "none"
+	case !aStatus.TokenValid:
+		return "expired"
+	case aStatus.TokenExpiry.IsZero():
+		return "valid"
+	case !now.Before(aStatus.TokenExpiry):
+		return "refreshable"
+	}
+	return "valid for " + aStatus.TokenExpiry.Sub(now).Round(time.Minute).String()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,146 @@
+package report_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/report"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/fanout"
+	"github.com/EngineerBetter/cf-plex/status"
+	"github.com/EngineerBetter/cf-plex/target"
+	"github.com/bitly/go-simplejson"
+)
+
+var _ = Describe("Summary", func() {
+	It("writes a row for every result", func() {
+		results := []fanout.Result{
+			{Name: "https://api.one.com", Group: "prod", ExitCode: 0, Duration: 1500 * time.Millisecond},
+			{Name: "https://api.two.com", Group: "prod", ExitCode: 1, Duration: 2 * time.Second},
+			{Name: "https://api.three.com", Group: "prod", ExitCode: 137, Err: context.Canceled},
+			{Name: "https://api.four.com", Group: "prod", ExitCode: -1, Err: errors.New("exec: cf not found")},
+			{Name: "https://api.five.com", Group: "prod", Skipped: true},
+		}
+
+		out := new(bytes.Buffer)
+		Ω(Summary(out, results)).Should(Succeed())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Ω(lines).Should(HaveLen(6))
+		Ω(lines[0]).Should(MatchRegexp(`^TARGET\s+GROUP\s+STATUS\s+EXIT CODE\s+DURATION\s+ERROR$`))
+		Ω(lines[1]).Should(MatchRegexp(`^https://api.one.com\s+prod\s+ok\s+0\s+1.5s\s*$`))
+		Ω(lines[2]).Should(MatchRegexp(`^https://api.two.com\s+prod\s+failed\s+1\s+2s\s*$`))
+		Ω(lines[3]).Should(MatchRegexp(`^https://api.three.com\s+prod\s+cancelled\s+137\s+`))
+		Ω(lines[4]).Should(MatchRegexp(`^https://api.four.com\s+prod\s+failed\s+-1\s+.*exec: cf not found$`))
+		Ω(lines[5]).Should(MatchRegexp(`^https://api.five.com\s+prod\s+skipped\s+-\s+-\s*$`))
+	})
+})
+
+var _ = Describe("JSON output", func() {
+	var started time.Time
+	var records []Record
+
+	BeforeEach(func() {
+		started = time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+		args := []string{"cf", "auth", "admin", "[expunged]"}
+		records = []Record{
+			NewRecord(fanout.Result{Name: "https://api.one.com", Group: "prod", Started: started, Duration: 2 * time.Second}, args, "OK\n", ""),
+			NewRecord(fanout.Result{Name: "https://api.two.com", Group: "prod", Skipped: true}, args, "", ""),
+		}
+	})
+
+	It("parses formats", func() {
+		format, err := ParseFormat("jsonl")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(format).Should(Equal(JSONLines))
+
+		_, err = ParseFormat("xml")
+		Ω(err).Should(MatchError("output format must be one of text, json or jsonl"))
+	})
+
+	It("writes a JSON array of records", func() {
+		out := new(bytes.Buffer)
+		Ω(Write(out, JSON, records)).Should(Succeed())
+
+		json, err := simplejson.NewFromReader(out)
+		Ω(err).ShouldNot(HaveOccurred())
+		first := json.GetIndex(0)
+		Ω(first.Get("target").MustString()).Should(Equal("https://api.one.com"))
+		Ω(first.Get("group").MustString()).Should(Equal("prod"))
+		Ω(first.Get("args").MustStringArray()).Should(Equal([]string{"cf", "auth", "admin", "[expunged]"}))
+		Ω(first.Get("exit_code").MustInt()).Should(Equal(0))
+		Ω(first.Get("stdout").MustString()).Should(Equal("OK\n"))
+		Ω(first.Get("status").MustString()).Should(Equal("ok"))
+		Ω(first.Get("started_at").MustString()).Should(Equal("2016-05-01T12:00:00Z"))
+		Ω(first.Get("duration_seconds").MustFloat64()).Should(Equal(2.0))
+		Ω(json.GetIndex(1).Get("skipped").MustBool()).Should(BeTrue())
+	})
+
+	It("writes a line of JSON for each record", func() {
+		out := new(bytes.Buffer)
+		Ω(Write(out, JSONLines, records)).Should(Succeed())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Ω(lines).Should(HaveLen(2))
+		Ω(lines[0]).Should(HavePrefix(`{"target":"https://api.one.com"`))
+		Ω(lines[1]).Should(HavePrefix(`{"target":"https://api.two.com"`))
+	})
+
+	It("describes APIs", func() {
+		groups := []target.Group{
+			{Name: "default", Apis: []target.Target{{Name: "one", Api: "https://api.one.com", Path: "/secret/path"}}},
+			{Name: "prod", Apis: []target.Target{{Name: "https://api.two.com", Api: "https://api.two.com", Path: "/secret/path"}}},
+		}
+
+		out := new(bytes.Buffer)
+		Ω(Write(out, JSONLines, ApiRecords(groups))).Should(Succeed())
+		Ω(out.String()).Should(Equal(`{"name":"one","api":"https://api.one.com","group":"default"}` + "\n" + `{"name":"https://api.two.com","api":"https://api.two.com","group":"prod"}` + "\n"))
+	})
+})
+
+var _ = Describe("Status output", func() {
+	now := time.Unix(1600000000, 0)
+	statuses := []status.Status{
+		{
+			Target: target.Target{Name: "prod", Group: "live", Api: "https://api.prod.com"},
+			User:   "admin", Org: "my-org", Space: "my-space",
+			LoggedIn: true, TokenValid: true, TokenExpiry: now.Add(90 * time.Minute),
+			ApiVersion: "2.150.0", Reachable: true, Latency: 42 * time.Millisecond,
+		},
+		{
+			Target: target.Target{Name: "https://api.dev.com", Group: "default", Api: "https://api.dev.com"},
+			Err:    errors.New("API unreachable: connection refused"),
+		},
+	}
+
+	It("writes a row for every target", func() {
+		out := new(bytes.Buffer)
+		Ω(StatusTable(out, statuses, now)).Should(Succeed())
+
+		lines := strings.Split(out.String(), "\n")
+		Ω(lines[0]).Should(MatchRegexp(`^TARGET\s+GROUP\s+API\s+USER\s+ORG/SPACE\s+TOKEN\s+API VERSION\s+REACHABLE\s+LATENCY\s+ERROR$`))
+		Ω(lines[1]).Should(MatchRegexp(`^prod\s+live\s+https://api.prod.com\s+admin\s+my-org/my-space\s+valid for 1h30m0s\s+2.150.0\s+yes\s+42ms\s*$`))
+		Ω(lines[2]).Should(MatchRegexp(`^https://api.dev.com\s+default\s+https://api.dev.com\s+-\s+-\s+none\s+-\s+no\s+-\s+API unreachable: connection refused$`))
+	})
+
+	It("describes tokens", func() {
+		Ω(TokenState(status.Status{LoggedIn: true, TokenValid: false}, now)).Should(Equal("expired"))
+		Ω(TokenState(status.Status{LoggedIn: true, TokenValid: true, TokenExpiry: now.Add(-time.Minute)}, now)).Should(Equal("refreshable"))
+	})
+
+	It("writes JSON", func() {
+		out := new(bytes.Buffer)
+		Ω(Write(out, JSONLines, StatusRecords(statuses))).Should(Succeed())
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Ω(lines).Should(HaveLen(2))
+		Ω(lines[0]).Should(ContainSubstring(`"user":"admin","org":"my-org","space":"my-space","logged_in":true,"token_valid":true,"token_expiry":"`))
+		Ω(lines[0]).Should(ContainSubstring(`"api_version":"2.150.0","reachable":true,"latency_seconds":0.042,"needs_attention":false}`))
+		Ω(lines[1]).Should(ContainSubstring(`"needs_attention":true,"error":"API unreachable: connection refused"}`))
+	})
+})
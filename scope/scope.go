@@ -0,0 +1,80 @@
+// Package scope works out which org and space each target should have
+// targeted before a command is run against it.
+package scope
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/target"
+)
+
+// Override is an org and space given on the command line, which take the
+// place of each target's defaults.
+type Override struct {
+	Org   string
+	Space string
+}
+
+// Needed reports whether a command runs within an org and space, rather
+// than setting up the session or the target itself.
+func Needed(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	switch args[1] {
+	case "api", "auth", "login", "l", "logout", "lo", "target", "t", "help", "h", "version", "-v", "--version", "-h", "--help":
+		return false
+	}
+	return true
+}
+
+// For returns the org and space that aTarget should be in. Those of the
+// override are looked up in the target's mapping tables, so that the same
+// name can be given for foundations whose orgs and spaces are named
+// differently. Without an override, the target's defaults are used.
+func For(aTarget target.Target, override Override) (string, string) {
+	org, space := aTarget.Org, aTarget.Space
+	if override.Org != "" {
+		org, space = mapped(aTarget.OrgMap, override.Org), ""
+	}
+	if override.Space != "" {
+		space = mapped(aTarget.SpaceMap, override.Space)
+	}
+	return org, space
+}
+
+// Command returns the cf command that targets org and space, or nil if
+// config already has them targeted. An empty org or space is left as it is.
+func Command(config cfconfig.Config, org, space string) []string {
+	if (org == "" || config.OrganizationFields.Name == org) && (space == "" || config.SpaceFields.Name == space) {
+		return nil
+	}
+
+	command := []string{"", "target"}
+	if org != "" {
+		command = append(command, "-o", org)
+	}
+	if space != "" {
+		command = append(command, "-s", space)
+	}
+	return command
+}
+
+// ParseMapping reads an entry of a mapping table, given as <name>=<actual>,
+// where actual is what the org or space called name is called on a target.
+func ParseMapping(mapping string) (string, string, error) {
+	parts := strings.SplitN(mapping, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("mapping " + mapping + " is invalid: use <name>=<actual>")
+	}
+	return parts[0], parts[1], nil
+}
+
+func mapped(table map[string]string, name string) string {
+	if actual, found := table[name]; found {
+		return actual
+	}
+	return name
+}
@@ -0,0 +1,85 @@
+package scope_test
+
+import (
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	. "github.com/EngineerBetter/cf-plex/scope"
+	"github.com/EngineerBetter/cf-plex/target"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("scope", func() {
+	aTarget := target.Target{
+		Org:      "system",
+		Space:    "ops",
+		OrgMap:   map[string]string{"prod": "production"},
+		SpaceMap: map[string]string{"web": "web-apps"},
+	}
+
+	It("only applies to commands that run within a space", func() {
+		Ω(Needed([]string{"", "apps"})).Should(BeTrue())
+		Ω(Needed([]string{"", "target", "-o", "system"})).Should(BeFalse())
+		Ω(Needed([]string{"", "login", "-a", "https://api.example.com"})).Should(BeFalse())
+	})
+
+	Describe("For", func() {
+		It("uses the target's defaults", func() {
+			org, space := For(aTarget, Override{})
+			Ω(org).Should(Equal("system"))
+			Ω(space).Should(Equal("ops"))
+		})
+
+		It("looks an override up in the target's mapping tables", func() {
+			org, space := For(aTarget, Override{Org: "prod", Space: "web"})
+			Ω(org).Should(Equal("production"))
+			Ω(space).Should(Equal("web-apps"))
+
+			org, space = For(aTarget, Override{Org: "dev", Space: "api"})
+			Ω(org).Should(Equal("dev"))
+			Ω(space).Should(Equal("api"))
+		})
+
+		It("does not keep the default space when only the org is overridden", func() {
+			org, space := For(aTarget, Override{Org: "prod"})
+			Ω(org).Should(Equal("production"))
+			Ω(space).Should(BeEmpty())
+		})
+
+		It("keeps the default org when only the space is overridden", func() {
+			org, space := For(aTarget, Override{Space: "web"})
+			Ω(org).Should(Equal("system"))
+			Ω(space).Should(Equal("web-apps"))
+		})
+	})
+
+	Describe("Command", func() {
+		var config cfconfig.Config
+
+		BeforeEach(func() {
+			config = cfconfig.Config{}
+			config.OrganizationFields.Name = "system"
+			config.SpaceFields.Name = "ops"
+		})
+
+		It("is nil when the org and space are already targeted", func() {
+			Ω(Command(config, "system", "ops")).Should(BeNil())
+			Ω(Command(config, "system", "")).Should(BeNil())
+			Ω(Command(config, "", "")).Should(BeNil())
+		})
+
+		It("targets whatever differs", func() {
+			Ω(Command(config, "production", "ops")).Should(Equal([]string{"", "target", "-o", "production", "-s", "ops"}))
+			Ω(Command(config, "", "web")).Should(Equal([]string{"", "target", "-s", "web"}))
+		})
+	})
+
+	It("parses mappings", func() {
+		name, actual, err := ParseMapping("prod=production")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(name).Should(Equal("prod"))
+		Ω(actual).Should(Equal("production"))
+
+		_, _, err = ParseMapping("prod")
+		Ω(err).Should(MatchError("mapping prod is invalid: use <name>=<actual>"))
+	})
+})
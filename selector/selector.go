@@ -0,0 +1,263 @@
+// Package selector parses and evaluates label selectors modelled on those of
+// Kubernetes, such as "env!=prod,region in (eu,us)".
+package selector
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type Operator string
+
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Selector matches labels that satisfy all of its requirements. An empty
+// Selector matches everything.
+type Selector []Requirement
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+var valuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, present := labels[r.Key]
+
+	switch r.Operator {
+	case Exists:
+		return present
+	case DoesNotExist:
+		return !present
+	case Equals, In:
+		return present && contains(r.Values, value)
+	case NotEquals, NotIn:
+		return !present || !contains(r.Values, value)
+	}
+	return false
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		if !requirement.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse parses a comma-separated list of requirements, each of which is one
+// of key=value, key==value, key!=value, key in (a,b), key notin (a,b), key,
+// or !key.
+func Parse(selector string) (Selector, error) {
+	p := &parser{tokens: tokenise(selector)}
+	result := Selector{}
+	if len(p.tokens) == 0 {
+		return result, nil
+	}
+
+	for {
+		requirement, err := p.requirement()
+		if err != nil {
+			return nil, errors.New("selector '" + selector + "' is invalid: " + err.Error())
+		}
+		result = append(result, requirement)
+
+		if p.done() {
+			return result, nil
+		}
+		if p.next() != "," {
+			return nil, errors.New("selector '" + selector + "' is invalid: expected ','")
+		}
+	}
+}
+
+// ParseLabel splits a key=value label, checking that both are valid.
+func ParseLabel(label string) (string, string, error) {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("label " + label + " is invalid: use key=value")
+	}
+	if err := ValidateKey(parts[0]); err != nil {
+		return "", "", err
+	}
+	if err := ValidateValue(parts[1]); err != nil {
+		return "", "", err
+	}
+	return parts[0], parts[1], nil
+}
+
+func ValidateKey(key string) error {
+	if !keyPattern.MatchString(key) {
+		return errors.New("label key '" + key + "' is invalid: use letters, numbers, '.', '_', '/' and '-'")
+	}
+	return nil
+}
+
+func ValidateValue(value string) error {
+	if !valuePattern.MatchString(value) {
+		return errors.New("label value '" + value + "' is invalid: use letters, numbers, '.', '_' and '-'")
+	}
+	return nil
+}
+
+// Format renders labels as a sorted, comma-separated list of key=value.
+func Format(labels map[string]string) string {
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+type parser struct {
+	tokens   []string
+	position int
+}
+
+func (p *parser) done() bool {
+	return p.position >= len(p.tokens)
+}
+
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.position]
+}
+
+func (p *parser) next() string {
+	token := p.peek()
+	p.position++
+	return token
+}
+
+func (p *parser) requirement() (Requirement, error) {
+	if p.peek() == "!" {
+		p.next()
+		key := p.next()
+		if err := ValidateKey(key); err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: key, Operator: DoesNotExist}, nil
+	}
+
+	key := p.next()
+	if err := ValidateKey(key); err != nil {
+		return Requirement{}, err
+	}
+
+	switch operator := p.peek(); operator {
+	case "", ",":
+		return Requirement{Key: key, Operator: Exists}, nil
+	case "=", "==", "!=":
+		p.next()
+		value := p.next()
+		if value == "," {
+			value = ""
+			p.position--
+		}
+		if err := ValidateValue(value); err != nil {
+			return Requirement{}, err
+		}
+		if operator == "!=" {
+			return Requirement{Key: key, Operator: NotEquals, Values: []string{value}}, nil
+		}
+		return Requirement{Key: key, Operator: Equals, Values: []string{value}}, nil
+	case "in", "notin":
+		p.next()
+		values, err := p.values()
+		if err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: key, Operator: Operator(operator), Values: values}, nil
+	default:
+		return Requirement{}, errors.New("unexpected '" + operator + "' after " + key)
+	}
+}
+
+func (p *parser) values() ([]string, error) {
+	if p.next() != "(" {
+		return nil, errors.New("expected '('")
+	}
+
+	var values []string
+	for {
+		value := p.next()
+		if value == "" {
+			return nil, errors.New("expected ')'")
+		}
+		if value == ")" && len(values) == 0 {
+			return nil, errors.New("expected at least one value")
+		}
+		if err := ValidateValue(value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		switch p.next() {
+		case ",":
+		case ")":
+			return values, nil
+		default:
+			return nil, errors.New("expected ',' or ')'")
+		}
+	}
+}
+
+func tokenise(selector string) []string {
+	var tokens []string
+	var word strings.Builder
+
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for index := 0; index < len(selector); index++ {
+		char := selector[index]
+		switch {
+		case char == ' ' || char == '\t':
+			flush()
+		case char == '(' || char == ')' || char == ',':
+			flush()
+			tokens = append(tokens, string(char))
+		case char == '!' || char == '=':
+			flush()
+			if index+1 < len(selector) && selector[index+1] == '=' {
+				tokens = append(tokens, string(char)+"=")
+				index++
+			} else {
+				tokens = append(tokens, string(char))
+			}
+		default:
+			word.WriteByte(char)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
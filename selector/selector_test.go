@@ -0,0 +1,104 @@
+package selector_test
+
+import (
+	. "github.com/EngineerBetter/cf-plex/selector"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Selector", func() {
+	prodEu := map[string]string{"env": "prod", "region": "eu"}
+	devUs := map[string]string{"env": "dev", "region": "us", "iaas": "vsphere"}
+	unlabelled := map[string]string{}
+
+	matches := func(selector string, labels map[string]string) bool {
+		parsed, err := Parse(selector)
+		Ω(err).ShouldNot(HaveOccurred())
+		return parsed.Matches(labels)
+	}
+
+	It("parses every kind of requirement", func() {
+		parsed, err := Parse("env==prod, region in (eu, us),iaas notin (aws),!legacy,team,tier!=web,zone=a")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(parsed).Should(Equal(Selector{
+			{Key: "env", Operator: Equals, Values: []string{"prod"}},
+			{Key: "region", Operator: In, Values: []string{"eu", "us"}},
+			{Key: "iaas", Operator: NotIn, Values: []string{"aws"}},
+			{Key: "legacy", Operator: DoesNotExist},
+			{Key: "team", Operator: Exists},
+			{Key: "tier", Operator: NotEquals, Values: []string{"web"}},
+			{Key: "zone", Operator: Equals, Values: []string{"a"}},
+		}))
+	})
+
+	It("matches equality", func() {
+		Ω(matches("env=prod", prodEu)).Should(BeTrue())
+		Ω(matches("env=prod", devUs)).Should(BeFalse())
+		Ω(matches("env=prod", unlabelled)).Should(BeFalse())
+	})
+
+	It("matches inequality, including labels that are missing", func() {
+		Ω(matches("env!=prod", prodEu)).Should(BeFalse())
+		Ω(matches("env!=prod", devUs)).Should(BeTrue())
+		Ω(matches("env!=prod", unlabelled)).Should(BeTrue())
+	})
+
+	It("matches sets", func() {
+		Ω(matches("region in (eu,us)", prodEu)).Should(BeTrue())
+		Ω(matches("region in (us)", prodEu)).Should(BeFalse())
+		Ω(matches("region notin (us)", prodEu)).Should(BeTrue())
+		Ω(matches("region notin (us)", unlabelled)).Should(BeTrue())
+	})
+
+	It("matches the existence of keys", func() {
+		Ω(matches("iaas", devUs)).Should(BeTrue())
+		Ω(matches("iaas", prodEu)).Should(BeFalse())
+		Ω(matches("!iaas", prodEu)).Should(BeTrue())
+	})
+
+	It("requires every requirement to match", func() {
+		Ω(matches("env!=prod,region in (eu,us)", devUs)).Should(BeTrue())
+		Ω(matches("env!=prod,region in (eu,us)", prodEu)).Should(BeFalse())
+	})
+
+	It("matches everything when empty", func() {
+		Ω(matches("", unlabelled)).Should(BeTrue())
+	})
+
+	It("rejects malformed selectors", func() {
+		for _, selector := range []string{"env=", "env in eu", "env in (eu", "env in ()", "=prod", "env=prod,", "env prod", "env=pr*d"} {
+			_, err := Parse(selector)
+			if selector == "env=" {
+				Ω(err).ShouldNot(HaveOccurred(), "an empty value is allowed")
+				continue
+			}
+			Ω(err).Should(MatchError(HavePrefix("selector '"+selector+"' is invalid")), selector)
+		}
+	})
+})
+
+var _ = Describe("ParseLabel", func() {
+	It("splits keys from values", func() {
+		key, value, err := ParseLabel("region=eu-west")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(key).Should(Equal("region"))
+		Ω(value).Should(Equal("eu-west"))
+	})
+
+	It("rejects labels that could not be selected", func() {
+		_, _, err := ParseLabel("region")
+		Ω(err).Should(MatchError("label region is invalid: use key=value"))
+
+		_, _, err = ParseLabel("region=eu west")
+		Ω(err).Should(HaveOccurred())
+
+		_, _, err = ParseLabel("in valid=eu")
+		Ω(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("Format", func() {
+	It("sorts labels", func() {
+		Ω(Format(map[string]string{"region": "eu", "env": "prod"})).Should(Equal("env=prod,region=eu"))
+	})
+})
@@ -0,0 +1,78 @@
+// Package status gathers what is known about each target: its session,
+// from config.json, and whether its API is up, from the API itself.
+package status
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/EngineerBetter/cf-plex/ccapi"
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/target"
+)
+
+type Status struct {
+	Target      target.Target
+	User        string
+	Org         string
+	Space       string
+	LoggedIn    bool
+	TokenValid  bool
+	TokenExpiry time.Time
+	ApiVersion  string
+	Reachable   bool
+	Latency     time.Duration
+	Err         error
+}
+
+// NeedsAttention reports whether commands would fail against the target.
+func (s Status) NeedsAttention() bool {
+	return !s.Reachable || !s.TokenValid
+}
+
+// Check gets the status of every target concurrently, giving up on any API
+// that hasn't responded within timeout. Statuses are returned in the same
+// order as targets.
+func Check(targets []target.Target, timeout time.Duration) []Status {
+	statuses := make([]Status, len(targets))
+	var wg sync.WaitGroup
+	for index, aTarget := range targets {
+		wg.Add(1)
+		go func(index int, aTarget target.Target) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			statuses[index] = CheckTarget(ctx, aTarget, time.Now())
+		}(index, aTarget)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func CheckTarget(ctx context.Context, aTarget target.Target, now time.Time) Status {
+	status := Status{Target: aTarget}
+
+	config, err := cfconfig.Read(aTarget.Path)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.User = config.User()
+	status.Org = config.OrganizationFields.Name
+	status.Space = config.SpaceFields.Name
+	status.LoggedIn = config.LoggedIn()
+	status.TokenValid = config.Valid(now)
+	status.TokenExpiry = config.TokenExpiry()
+
+	start := time.Now()
+	info, err := ccapi.GetInfo(ctx, aTarget.Api, aTarget.SkipSslValidation || config.SSLDisabled)
+	if err != nil {
+		status.Err = err
+		return status
+	}
+	status.Reachable = true
+	status.Latency = time.Since(start)
+	status.ApiVersion = info.ApiVersion
+	return status
+}
@@ -0,0 +1,76 @@
+package status_test
+
+import (
+	"github.com/EngineerBetter/cf-plex/cfconfig"
+	"github.com/EngineerBetter/cf-plex/fakecc"
+	"github.com/EngineerBetter/cf-plex/fakecf"
+	. "github.com/EngineerBetter/cf-plex/status"
+	"github.com/EngineerBetter/cf-plex/target"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Check", func() {
+	var plexHome string
+	var server *httptest.Server
+
+	BeforeEach(func() {
+		var err error
+		plexHome, err = ioutil.TempDir("", "plex-status")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		server = httptest.NewServer(nil)
+		fakecc.Configure(server.Config, fakecc.Foundation{Addr: server.URL})
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(plexHome)
+	})
+
+	It("combines the session from config.json with the state of the API", func() {
+		cfHome := filepath.Join(plexHome, "up")
+		expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+		config := cfconfig.Config{Target: server.URL, AccessToken: "bearer " + fakecc.AccessToken(server.URL, "admin", expiry)}
+		config.OrganizationFields.Name = "my-org"
+		Ω(fakecf.WriteConfig(cfHome, config)).Should(Succeed())
+
+		statuses := Check([]target.Target{
+			{Name: "up", Api: server.URL, Path: cfHome},
+			{Name: "down", Api: "http://127.0.0.1:1", Path: filepath.Join(plexHome, "down")},
+		}, time.Second)
+
+		Ω(statuses).Should(HaveLen(2))
+		Ω(statuses[0].User).Should(Equal("admin"))
+		Ω(statuses[0].Org).Should(Equal("my-org"))
+		Ω(statuses[0].TokenExpiry).Should(Equal(expiry))
+		Ω(statuses[0].ApiVersion).Should(Equal(fakecc.APIVersion))
+		Ω(statuses[0].Reachable).Should(BeTrue())
+		Ω(statuses[0].NeedsAttention()).Should(BeFalse())
+
+		Ω(statuses[1].Target.Name).Should(Equal("down"))
+		Ω(statuses[1].LoggedIn).Should(BeFalse())
+		Ω(statuses[1].Reachable).Should(BeFalse())
+		Ω(statuses[1].Err).Should(MatchError(HavePrefix("API unreachable")))
+		Ω(statuses[1].NeedsAttention()).Should(BeTrue())
+	})
+
+	It("gives up on APIs that take too long", func() {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(2 * time.Second)
+		}))
+		defer slow.Close()
+
+		start := time.Now()
+		statuses := Check([]target.Target{{Name: "slow", Api: slow.URL, Path: plexHome}}, 100*time.Millisecond)
+		Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+		Ω(statuses[0].Reachable).Should(BeFalse())
+	})
+})
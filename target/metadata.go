@@ -0,0 +1,75 @@
+package target
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MetadataFile is kept in each target's directory, and in each group's, to
+// hold settings that cannot be derived from the directory name.
+const MetadataFile = "cf-plex.json"
+
+type Metadata struct {
+	Api         string            `json:"api,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	CfBinary    string            `json:"cf_binary,omitempty"`
+	CfVersion   string            `json:"cf_version,omitempty"`
+	LastCfMajor int               `json:"last_cf_major,omitempty"`
+
+	// SkipSslValidation is passed on to cf whenever the API is set.
+	SkipSslValidation bool `json:"skip_ssl_validation,omitempty"`
+	// Origin is the UAA identity provider that users log in with.
+	Origin string `json:"origin,omitempty"`
+	// Credentials refers to where the credentials for this target can be
+	// found. It never holds the credentials themselves.
+	Credentials string `json:"credentials,omitempty"`
+	// CredentialHelper is a command that prints the credentials for this
+	// target, or for every target in a group.
+	CredentialHelper string `json:"credential_helper,omitempty"`
+	// Org and Space are targeted before each command, unless others are
+	// given on the command line.
+	Org   string `json:"org,omitempty"`
+	Space string `json:"space,omitempty"`
+	// OrgMap and SpaceMap give the names on this target of the orgs and
+	// spaces given on the command line, where they differ.
+	OrgMap   map[string]string `json:"org_map,omitempty"`
+	SpaceMap map[string]string `json:"space_map,omitempty"`
+	// Fingerprint is a salted hash of the credentials that a batch target
+	// was last logged in with.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// LastUsed is when a batch target was last chosen to run a command.
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+func ReadMetadata(dir string) (Metadata, error) {
+	var metadata Metadata
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, MetadataFile))
+	if os.IsNotExist(err) {
+		return metadata, nil
+	}
+	if err != nil {
+		return metadata, err
+	}
+	err = json.Unmarshal(bytes, &metadata)
+	return metadata, err
+}
+
+func WriteMetadata(dir string, metadata Metadata) error {
+	bytes, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, MetadataFile), bytes, 0600)
+}
+
+// GroupDir returns the directory that holds a group's targets.
+func GroupDir(plexHome, group string) string {
+	if group == "default" {
+		return plexHome
+	}
+	return filepath.Join(plexHome, "groups", group)
+}
@@ -5,13 +5,31 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 )
 
+// Target is an API that commands are run against. Name is the target's alias
+// if it was given one, or otherwise the same as Api.
 type Target struct {
-	Name string
-	Path string
+	Name      string
+	Api       string
+	Group     string
+	Path      string
+	CfBinary  string
+	CfVersion string
+	Labels    map[string]string
+
+	SkipSslValidation bool
+	Origin            string
+	Credentials       string
+	CredentialHelper  string
+
+	Org      string
+	Space    string
+	OrgMap   map[string]string
+	SpaceMap map[string]string
 }
 
 type Group struct {
@@ -26,16 +44,19 @@ func Add(plexHome, api string) (string, error) {
 	return fullPath, err
 }
 
-func Remove(plexHome, api string) error {
-	apiDir := Sanitise(api)
-	fullPath := filepath.Join(plexHome, apiDir)
+// Remove deletes the target without a group whose name or API URL is ref.
+func Remove(plexHome, ref string) error {
+	fullPath, err := findDir(plexHome, "default", ref)
+	if err != nil {
+		return err
+	}
 	return os.RemoveAll(fullPath)
 }
 
 func List(plexHome string) ([]Group, error) {
 	var groups []Group
 
-	targets, err := getTargets(plexHome)
+	targets, err := getTargets(plexHome, "default")
 	if err != nil {
 		return nil, err
 	}
@@ -48,14 +69,14 @@ func List(plexHome string) ([]Group, error) {
 		}
 
 		for _, groupDir := range dirs {
-			targets, err := getTargets(groupDir)
+			groupName := filepath.Base(groupDir)
+			targets, err := getTargets(groupDir, groupName)
 			if err != nil {
 				return nil, err
 			}
 
-			groupName := filepath.Base(groupDir)
 			if groupIsVisible(groupName) {
-				groups = append(groups, Group{Name: filepath.Base(groupDir), Apis: targets})
+				groups = append(groups, Group{Name: groupName, Apis: targets})
 			}
 		}
 	}
@@ -74,6 +95,15 @@ func GroupsExist(plexHome string) bool {
 	return true
 }
 
+// Batch returns the targets that batch mode has set up.
+func Batch(plexHome string) ([]Target, error) {
+	targets, err := getTargets(GroupDir(plexHome, "batch"), "batch")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return targets, err
+}
+
 func AddToBatch(plexHome, api string) (string, error) {
 	return addToGroup(plexHome, "batch", api)
 }
@@ -91,14 +121,29 @@ func RemoveFromGroup(plexHome, group, api string) error {
 		return errors.New("group name " + group + " is reserved")
 	}
 
-	apiDir := Sanitise(api)
-	fullPath := filepath.Join(plexHome, "groups", group, apiDir)
+	groupDir := filepath.Join(plexHome, "groups", group)
+	fullPath, err := findDir(groupDir, group, api)
+	if err != nil {
+		return err
+	}
+	return removeFromGroupDir(groupDir, fullPath)
+}
+
+// Delete removes aTarget's directory, and its group's if it was the last
+// target in the group.
+func Delete(plexHome string, aTarget Target) error {
+	if aTarget.Group == "default" {
+		return os.RemoveAll(aTarget.Path)
+	}
+	return removeFromGroupDir(GroupDir(plexHome, aTarget.Group), aTarget.Path)
+}
+
+func removeFromGroupDir(groupDir, fullPath string) error {
 	err := os.RemoveAll(fullPath)
 	if err != nil {
 		return err
 	}
 
-	groupDir := filepath.Join(plexHome, "groups", group)
 	dirs, err := listDirs(groupDir)
 	if err != nil {
 		return err
@@ -109,6 +154,163 @@ func RemoveFromGroup(plexHome, group, api string) error {
 	return err
 }
 
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidateName checks that name can be used as a target's alias, and so as
+// the name of its directory.
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) || name == "groups" {
+		return errors.New("name " + name + " is invalid: use letters, numbers, '.', '_' and '-'")
+	}
+	return nil
+}
+
+// Find returns the targets in group whose name or API URL is ref. More than
+// one target is returned if the same API has been added under several names.
+func Find(plexHome, group, ref string) ([]Target, error) {
+	targets, err := getTargets(GroupDir(plexHome, group), group)
+	if err != nil {
+		return nil, err
+	}
+	return matching(targets, ref), nil
+}
+
+// Select returns those of targets whose name or API URL is one of refs,
+// failing if any ref matches nothing.
+func Select(targets []Target, refs []string) ([]Target, error) {
+	selected := make(map[string]bool)
+	for _, ref := range refs {
+		matches := matching(targets, ref)
+		if len(matches) == 0 {
+			return nil, errors.New("Target '" + ref + "' not recognised")
+		}
+		for _, aTarget := range matches {
+			selected[aTarget.Path] = true
+		}
+	}
+
+	var result []Target
+	for _, aTarget := range targets {
+		if selected[aTarget.Path] {
+			result = append(result, aTarget)
+		}
+	}
+	return result, nil
+}
+
+// Rename gives the target in group whose name or API URL is ref a new name,
+// moving its directory to match.
+func Rename(plexHome, group, ref, name string) (Target, error) {
+	if err := ValidateName(name); err != nil {
+		return Target{}, err
+	}
+
+	parentPath := GroupDir(plexHome, group)
+	oldPath, err := findExistingDir(parentPath, group, ref)
+	if err != nil {
+		return Target{}, err
+	}
+	aTarget, err := Load(oldPath, group)
+	if err != nil {
+		return Target{}, err
+	}
+
+	newPath := filepath.Join(parentPath, name)
+	if _, err := os.Stat(newPath); err == nil {
+		return Target{}, errors.New("name " + name + " is already in use")
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return Target{}, err
+	}
+
+	metadata, err := ReadMetadata(newPath)
+	if err != nil {
+		return Target{}, err
+	}
+	metadata.Name = name
+	metadata.Api = aTarget.Api
+	if err := WriteMetadata(newPath, metadata); err != nil {
+		return Target{}, err
+	}
+	return Load(newPath, group)
+}
+
+// Label sets and removes labels on the target in group whose name or API URL
+// is ref.
+func Label(plexHome, group, ref string, set map[string]string, remove []string) (Target, error) {
+	apiDir, err := findExistingDir(GroupDir(plexHome, group), group, ref)
+	if err != nil {
+		return Target{}, err
+	}
+
+	metadata, err := ReadMetadata(apiDir)
+	if err != nil {
+		return Target{}, err
+	}
+	if metadata.Labels == nil {
+		metadata.Labels = make(map[string]string)
+	}
+	for key, value := range set {
+		metadata.Labels[key] = value
+	}
+	for _, key := range remove {
+		delete(metadata.Labels, key)
+	}
+
+	if err := WriteMetadata(apiDir, metadata); err != nil {
+		return Target{}, err
+	}
+	return Load(apiDir, group)
+}
+
+// Migrate records the API URL in the metadata of every target whose directory
+// is named after its URL, so that it no longer depends on the directory name.
+// It returns the targets that were changed.
+func Migrate(plexHome string) ([]Target, error) {
+	parents := []string{plexHome}
+	groups := []string{"default"}
+	if GroupsExist(plexHome) {
+		dirs, err := listDirs(filepath.Join(plexHome, "groups"))
+		if err != nil {
+			return nil, err
+		}
+		for _, groupDir := range dirs {
+			parents = append(parents, groupDir)
+			groups = append(groups, filepath.Base(groupDir))
+		}
+	}
+
+	var migrated []Target
+	for index, parentPath := range parents {
+		targets, err := getTargets(parentPath, groups[index])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, aTarget := range targets {
+			apiDir := path.Base(aTarget.Path)
+			if apiDir == MakeFilthy(apiDir) {
+				continue
+			}
+
+			metadata, err := ReadMetadata(aTarget.Path)
+			if err != nil {
+				return nil, err
+			}
+			if metadata.Api != "" {
+				continue
+			}
+
+			metadata.Api = MakeFilthy(apiDir)
+			if err := WriteMetadata(aTarget.Path, metadata); err != nil {
+				return nil, err
+			}
+			migrated = append(migrated, aTarget)
+		}
+	}
+	return migrated, nil
+}
+
 func Sanitise(apiUrl string) string {
 	api := strings.Replace(apiUrl, "https://", "https___", -1)
 	api = strings.Replace(api, "http://", "http___", -1)
@@ -128,7 +330,47 @@ func addToGroup(plexHome, group, api string) (string, error) {
 	return fullPath, err
 }
 
-func getTargets(parentPath string) ([]Target, error) {
+// findDir returns the directory of the target in parentPath whose name or
+// API URL is ref. If there is none, the directory that ref would have been
+// added to is returned.
+func findDir(parentPath, group, ref string) (string, error) {
+	targets, err := getTargets(parentPath, group)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	matches := matching(targets, ref)
+	switch len(matches) {
+	case 0:
+		return filepath.Join(parentPath, Sanitise(ref)), nil
+	case 1:
+		return matches[0].Path, nil
+	}
+	return "", errors.New(ref + " has been added more than once; use the name of the one you mean")
+}
+
+func findExistingDir(parentPath, group, ref string) (string, error) {
+	apiDir, err := findDir(parentPath, group, ref)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(apiDir); os.IsNotExist(err) {
+		return "", errors.New("Target '" + ref + "' not recognised")
+	}
+	return apiDir, nil
+}
+
+func matching(targets []Target, ref string) []Target {
+	var matches []Target
+	for _, aTarget := range targets {
+		if aTarget.Name == ref || aTarget.Api == ref {
+			matches = append(matches, aTarget)
+		}
+	}
+	return matches
+}
+
+func getTargets(parentPath, group string) ([]Target, error) {
 	apiDirs, err := listDirs(parentPath)
 	if err != nil {
 		return nil, err
@@ -136,15 +378,61 @@ func getTargets(parentPath string) ([]Target, error) {
 
 	var targets []Target
 	for _, apiDir := range apiDirs {
-		name := MakeFilthy(path.Base(apiDir))
-
-		if name != "groups" {
-			targets = append(targets, Target{Name: name, Path: apiDir})
+		if path.Base(apiDir) != "groups" {
+			aTarget, err := Load(apiDir, group)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, aTarget)
 		}
 	}
 	return targets, nil
 }
 
+// Load builds the Target kept in apiDir, falling back to the metadata of its
+// group for any settings the target does not have itself. Targets added
+// before metadata existed take their URL from the directory name.
+func Load(apiDir, group string) (Target, error) {
+	url := MakeFilthy(path.Base(apiDir))
+	aTarget := Target{Name: url, Api: url, Group: group, Path: apiDir}
+
+	groupMetadata, err := ReadMetadata(filepath.Dir(apiDir))
+	if err != nil {
+		return aTarget, err
+	}
+	metadata, err := ReadMetadata(apiDir)
+	if err != nil {
+		return aTarget, err
+	}
+
+	if metadata.Api != "" {
+		aTarget.Name = metadata.Api
+		aTarget.Api = metadata.Api
+	}
+	if metadata.Name != "" {
+		aTarget.Name = metadata.Name
+	}
+	aTarget.Labels = metadata.Labels
+	aTarget.SkipSslValidation = metadata.SkipSslValidation
+	aTarget.Origin = metadata.Origin
+	aTarget.Credentials = metadata.Credentials
+	aTarget.Org, aTarget.Space = metadata.Org, metadata.Space
+	aTarget.OrgMap, aTarget.SpaceMap = metadata.OrgMap, metadata.SpaceMap
+
+	aTarget.CredentialHelper = groupMetadata.CredentialHelper
+	if metadata.CredentialHelper != "" {
+		aTarget.CredentialHelper = metadata.CredentialHelper
+	}
+
+	aTarget.CfBinary = groupMetadata.CfBinary
+	aTarget.CfVersion = groupMetadata.CfVersion
+	if metadata.CfBinary != "" || metadata.CfVersion != "" {
+		aTarget.CfBinary = metadata.CfBinary
+		aTarget.CfVersion = metadata.CfVersion
+	}
+	return aTarget, nil
+}
+
 func groupIsVisible(groupName string) bool {
 	return groupName != "batch"
 }
@@ -158,11 +446,18 @@ func listDirs(path string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	names, err := f.Readdirnames(-1)
+	infos, err := f.Readdir(-1)
 	f.Close()
 	if err != nil {
 		return nil, err
 	}
+
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
 	sort.Strings(names)
 
 	for index, apiDir := range names {
@@ -52,6 +52,217 @@ var _ = Describe("target", func() {
 	})
 })
 
+var _ = Describe("target metadata", func() {
+	var plexHome string
+
+	BeforeEach(func() {
+		var err error
+		plexHome, err = ioutil.TempDir("", "plex-metadata")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(plexHome)
+	})
+
+	It("reads back what was written", func() {
+		apiDir, err := AddToGroup(plexHome, "prod", "https://api.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		metadata := Metadata{Api: "https://api.example.com", CfBinary: "/usr/local/bin/cf7"}
+		Ω(WriteMetadata(apiDir, metadata)).Should(Succeed())
+		Ω(ReadMetadata(apiDir)).Should(Equal(metadata))
+	})
+
+	It("is empty when nothing has been written", func() {
+		Ω(ReadMetadata(plexHome)).Should(Equal(Metadata{}))
+	})
+
+	It("does not treat metadata files as targets", func() {
+		_, err := Add(plexHome, "https://api.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(WriteMetadata(plexHome, Metadata{CfVersion: "7"})).Should(Succeed())
+
+		groups, err := List(plexHome)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(groups[0].Apis).Should(HaveLen(1))
+	})
+
+	It("falls back to the group's cf settings", func() {
+		groupApi, err := AddToGroup(plexHome, "prod", "https://api.group.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		ownApi, err := AddToGroup(plexHome, "prod", "https://api.own.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(WriteMetadata(GroupDir(plexHome, "prod"), Metadata{CfVersion: "6"})).Should(Succeed())
+		Ω(WriteMetadata(ownApi, Metadata{CfBinary: "/opt/cf7"})).Should(Succeed())
+
+		aTarget, err := Load(groupApi, "prod")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(aTarget.CfVersion).Should(Equal("6"))
+		Ω(aTarget.CfBinary).Should(BeEmpty())
+
+		aTarget, err = Load(ownApi, "prod")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(aTarget.CfBinary).Should(Equal("/opt/cf7"))
+		Ω(aTarget.CfVersion).Should(BeEmpty())
+	})
+
+	It("removes the group, metadata and all, with its last API", func() {
+		_, err := AddToGroup(plexHome, "prod", "https://api.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(WriteMetadata(GroupDir(plexHome, "prod"), Metadata{CfVersion: "6"})).Should(Succeed())
+
+		Ω(RemoveFromGroup(plexHome, "prod", "https://api.example.com")).Should(Succeed())
+		Ω(exists(GroupDir(plexHome, "prod"))).Should(BeFalse())
+	})
+})
+
+var _ = Describe("target names", func() {
+	var plexHome string
+
+	BeforeEach(func() {
+		var err error
+		plexHome, err = ioutil.TempDir("", "plex-names")
+		Ω(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(plexHome)
+	})
+
+	addNamed := func(group, name, api string) string {
+		var apiDir string
+		var err error
+		if group == "default" {
+			apiDir, err = Add(plexHome, name)
+		} else {
+			apiDir, err = AddToGroup(plexHome, group, name)
+		}
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(WriteMetadata(apiDir, Metadata{Api: api, Name: name})).Should(Succeed())
+		return apiDir
+	}
+
+	It("rejects names that cannot be directories", func() {
+		Ω(ValidateName("prod-admin")).Should(Succeed())
+		Ω(ValidateName("https://api.example.com")).Should(MatchError(ContainSubstring("is invalid")))
+		Ω(ValidateName("../elsewhere")).ShouldNot(Succeed())
+		Ω(ValidateName("groups")).ShouldNot(Succeed())
+	})
+
+	It("loads the name and URL from metadata", func() {
+		apiDir := addNamed("default", "admin", "https://api.example.com")
+
+		aTarget, err := Load(apiDir, "default")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(aTarget.Name).Should(Equal("admin"))
+		Ω(aTarget.Api).Should(Equal("https://api.example.com"))
+	})
+
+	It("takes the URL from the directory of targets added before metadata", func() {
+		apiDir, err := Add(plexHome, "https://api.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		aTarget, err := Load(apiDir, "default")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(aTarget.Name).Should(Equal("https://api.example.com"))
+		Ω(aTarget.Api).Should(Equal("https://api.example.com"))
+	})
+
+	It("finds targets by name or URL", func() {
+		addNamed("prod", "admin", "https://api.example.com")
+		addNamed("prod", "developer", "https://api.example.com")
+
+		found, err := Find(plexHome, "prod", "developer")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(found).Should(HaveLen(1))
+		Ω(found[0].Name).Should(Equal("developer"))
+
+		found, err = Find(plexHome, "prod", "https://api.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(found).Should(HaveLen(2))
+	})
+
+	It("selects targets by name or URL, in their original order", func() {
+		targets := []Target{
+			{Name: "one", Api: "https://api.one.com", Path: "/one"},
+			{Name: "two", Api: "https://api.two.com", Path: "/two"},
+			{Name: "https://api.three.com", Api: "https://api.three.com", Path: "/three"},
+		}
+
+		selected, err := Select(targets, []string{"https://api.three.com", "one"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(selected).Should(Equal([]Target{targets[0], targets[2]}))
+
+		_, err = Select(targets, []string{"four"})
+		Ω(err).Should(MatchError("Target 'four' not recognised"))
+	})
+
+	It("removes targets by name", func() {
+		adminDir := addNamed("default", "admin", "https://api.example.com")
+		developerDir := addNamed("default", "developer", "https://api.example.com")
+
+		Ω(Remove(plexHome, "admin")).Should(Succeed())
+		Ω(exists(adminDir)).Should(BeFalse())
+		Ω(exists(developerDir)).Should(BeTrue())
+	})
+
+	It("refuses to remove by a URL that has been added more than once", func() {
+		addNamed("prod", "admin", "https://api.example.com")
+		addNamed("prod", "developer", "https://api.example.com")
+
+		err := RemoveFromGroup(plexHome, "prod", "https://api.example.com")
+		Ω(err).Should(MatchError(ContainSubstring("has been added more than once")))
+	})
+
+	It("renames targets", func() {
+		oldDir, err := AddToGroup(plexHome, "prod", "https://api.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+
+		aTarget, err := Rename(plexHome, "prod", "https://api.example.com", "prod-admin")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(aTarget.Name).Should(Equal("prod-admin"))
+		Ω(aTarget.Api).Should(Equal("https://api.example.com"))
+		Ω(aTarget.Path).Should(Equal(filepath.Join(plexHome, "groups", "prod", "prod-admin")))
+		Ω(exists(oldDir)).Should(BeFalse())
+
+		_, err = Rename(plexHome, "prod", "https://api.missing.com", "missing")
+		Ω(err).Should(MatchError("Target 'https://api.missing.com' not recognised"))
+	})
+
+	It("sets and removes labels", func() {
+		addNamed("prod", "admin", "https://api.example.com")
+
+		_, err := Label(plexHome, "prod", "admin", map[string]string{"env": "prod", "region": "eu"}, nil)
+		Ω(err).ShouldNot(HaveOccurred())
+		aTarget, err := Label(plexHome, "prod", "https://api.example.com", map[string]string{"env": "staging"}, []string{"region"})
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(aTarget.Labels).Should(Equal(map[string]string{"env": "staging"}))
+
+		found, err := Find(plexHome, "prod", "admin")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(found[0].Labels).Should(Equal(map[string]string{"env": "staging"}))
+
+		_, err = Label(plexHome, "prod", "missing", map[string]string{"env": "prod"}, nil)
+		Ω(err).Should(MatchError("Target 'missing' not recognised"))
+	})
+
+	It("migrates URL-named directories", func() {
+		oldDir, err := AddToGroup(plexHome, "prod", "https://api.example.com")
+		Ω(err).ShouldNot(HaveOccurred())
+		addNamed("default", "admin", "https://api.other.com")
+
+		migrated, err := Migrate(plexHome)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(migrated).Should(HaveLen(1))
+		Ω(ReadMetadata(oldDir)).Should(Equal(Metadata{Api: "https://api.example.com"}))
+
+		migrated, err = Migrate(plexHome)
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(migrated).Should(BeEmpty())
+	})
+})
+
 func exists(dir string) bool {
 	_, err := os.Stat(dir)
 